@@ -0,0 +1,42 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+)
+
+// Operation classifies a call for the authorizer set with WithAuthorizer.
+type Operation string
+
+const (
+	// OpRead reads values or information of states.
+	OpRead Operation = "read"
+	// OpSet requests a value with SetState and its variants.
+	OpSet Operation = "set"
+	// OpForce overrides the configured behaviour, e.g. ForceState, Reset, Override or Acquire.
+	OpForce Operation = "force"
+	// OpConfigure adds, updates or removes states.
+	OpConfigure Operation = "configure"
+)
+
+// Authorizer decides whether the caller identified by ctx, e.g. by a token placed there by a
+// transport, may perform op on the named state. A non-nil error denies the call and is returned
+// to the caller.
+type Authorizer func(ctx context.Context, op Operation, name string) error
+
+// Authorize consults the authorizer set with WithAuthorizer, for transports exposing the
+// controller, e.g. over HTTP. Returns nil if no authorizer is set.
+func (sc *StateController) Authorize(ctx context.Context, op Operation, name string) error {
+	if sc.authorizer == nil {
+		return nil
+	}
+	if err := sc.authorizer(ctx, op, name); err != nil {
+		return sc.stateError(name, err)
+	}
+	return nil
+}
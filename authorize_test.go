@@ -0,0 +1,92 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type tokenKey struct{}
+
+func TestWithAuthorizer(t *testing.T) {
+	errForbidden := errors.New("forbidden")
+	sc := NewStateController(WithAuthorizer(func(ctx context.Context, op Operation, name string) error {
+		if op != OpRead && ctx.Value(tokenKey{}) != "admin" {
+			return errForbidden
+		}
+		return nil
+	}))
+	sc.AddState("safety", State{})
+
+	readOnly := context.WithValue(context.Background(), tokenKey{}, "viewer")
+	admin := context.WithValue(context.Background(), tokenKey{}, "admin")
+
+	if err := sc.SetStateCtx(readOnly, "safety", true); !errors.Is(err, errForbidden) {
+		t.Fatalf("Expected the read-only caller to be denied, got %v", err)
+	}
+	if _, err := sc.TrySetState("safety", true); !errors.Is(err, errForbidden) {
+		t.Fatalf("Expected the anonymous caller to be denied, got %v", err)
+	}
+	if sc.IsActive("safety") {
+		t.Fatal("Expected denied requests not to be applied")
+	}
+	if err := sc.SetStateCtx(admin, "safety", true); err != nil {
+		t.Fatalf("Expected the admin to be allowed, got %v", err)
+	}
+
+	if err := sc.Authorize(readOnly, OpRead, "safety"); err != nil {
+		t.Fatalf("Expected reads to be allowed, got %v", err)
+	}
+	if err := sc.Authorize(readOnly, OpConfigure, "safety"); !errors.Is(err, errForbidden) {
+		t.Fatalf("Expected configuration by the read-only caller to be denied, got %v", err)
+	}
+}
+
+func TestAuthorizerForce(t *testing.T) {
+	errForbidden := errors.New("forbidden")
+	sc := NewStateController(WithAuthorizer(func(ctx context.Context, op Operation, name string) error {
+		if op == OpForce {
+			return errForbidden
+		}
+		return nil
+	}))
+	defer sc.Close()
+	sc.AddState("safety", State{})
+	sc.SetState("safety", true)
+
+	if err := sc.ForceState("safety", false); !errors.Is(err, errForbidden) {
+		t.Fatalf("Expected ForceState to be denied, got %v", err)
+	}
+	if err := sc.Reset("safety"); !errors.Is(err, errForbidden) {
+		t.Fatalf("Expected Reset to be denied, got %v", err)
+	}
+	if err := sc.SetUnknown("safety"); !errors.Is(err, errForbidden) {
+		t.Fatalf("Expected SetUnknown to be denied, got %v", err)
+	}
+	if err := sc.Override("safety", false, time.Hour); !errors.Is(err, errForbidden) {
+		t.Fatalf("Expected Override to be denied, got %v", err)
+	}
+	if err := sc.ClearOverride("safety"); !errors.Is(err, errForbidden) {
+		t.Fatalf("Expected ClearOverride to be denied, got %v", err)
+	}
+	if err := sc.Acquire("safety", "owner", 0); !errors.Is(err, errForbidden) {
+		t.Fatalf("Expected Acquire to be denied, got %v", err)
+	}
+	if err := sc.DisableState("safety"); !errors.Is(err, errForbidden) {
+		t.Fatalf("Expected DisableState to be denied, got %v", err)
+	}
+	if err := sc.ExtendPending("safety", time.Second); !errors.Is(err, errForbidden) {
+		t.Fatalf("Expected ExtendPending to be denied, got %v", err)
+	}
+	info, _ := sc.Info("safety")
+	if !sc.IsActive("safety") || sc.IsUnknown("safety") || info.Disabled || info.LeaseOwner != "" || info.OverrideSource != "" {
+		t.Fatalf("Expected denied calls not to change the state, got %+v", info)
+	}
+}
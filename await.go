@@ -0,0 +1,128 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+)
+
+// ErrAwaitTimeout is returned by AwaitActive and AwaitInactive if the state did not reach the
+// value in time.
+var ErrAwaitTimeout = errors.New("timed out waiting for state")
+
+// Await blocks until the named state's IsActive value equals active, ctx is done, or the state is
+// removed. Returns nil at once if the state already has the value, ctx.Err() if ctx is done, and
+// ErrStateNotFound if the state does not exist or is removed while waiting, even if a state of
+// the same name was added again meanwhile. An unknown value (see SetUnknown) matches neither
+// value, although IsActive reports the last known one.
+func (sc *StateController) Await(ctx context.Context, name string, active bool) error {
+	return sc.awaitStates(ctx, []string{name}, func(values, known []bool) bool {
+		return known[0] && values[0] == active
+	})
+}
+
+// AwaitAll blocks until all of the named states are active at the same time, e.g. to gate a
+// startup sequence on several delayed conditions. Errors and unknown values are handled as by
+// Await.
+func (sc *StateController) AwaitAll(ctx context.Context, names ...string) error {
+	return sc.awaitStates(ctx, names, func(values, known []bool) bool {
+		for i, active := range values {
+			if !known[i] || !active {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// AwaitAny blocks until at least one of the named states is active. Without names, it blocks
+// until ctx is done. Errors and unknown values are handled as by Await.
+func (sc *StateController) AwaitAny(ctx context.Context, names ...string) error {
+	return sc.awaitStates(ctx, names, func(values, known []bool) bool {
+		for i, active := range values {
+			if known[i] && active {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// awaitStates blocks until done reports true for the IsActive values of the named states, in
+// order, and whether each value is known. done is evaluated initially and again after each change
+// of any of the states. The states are those found initially; a state of the same name added
+// after one was removed is not waited on.
+func (sc *StateController) awaitStates(ctx context.Context, names []string, done func(values, known []bool) bool) error {
+	values := make([]bool, len(names))
+	known := make([]bool, len(names))
+	states := make([]*delayedState, len(names))
+	cases := make([]reflect.SelectCase, len(names)+1)
+	cases[0] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
+
+	for {
+		for i, name := range names {
+			state := sc.lockState(name)
+			if state == nil {
+				return sc.stateError(name, ErrStateNotFound)
+			}
+			if states[i] == nil {
+				states[i] = state
+			} else if state != states[i] {
+				state.mu.Unlock()
+				return sc.stateError(name, ErrStateNotFound)
+			}
+			values[i] = state.IsActive
+			known[i] = !state.Unknown
+			if state.changed == nil {
+				state.changed = make(chan struct{})
+			}
+			cases[i+1] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(state.changed)}
+			state.mu.Unlock()
+		}
+		if done(values, known) {
+			return nil
+		}
+
+		if chosen, _, _ := reflect.Select(cases); chosen == 0 {
+			return ctx.Err()
+		}
+	}
+}
+
+// AwaitActive is a convenience wrapper of Await for callers without a context, e.g. scripts and
+// tests. It waits up to timeout for the state to become active and returns ErrAwaitTimeout if it
+// does not.
+func (sc *StateController) AwaitActive(name string, timeout time.Duration) error {
+	return sc.awaitTimeout(name, true, timeout)
+}
+
+// AwaitInactive is like AwaitActive, but waits for the state to become inactive.
+func (sc *StateController) AwaitInactive(name string, timeout time.Duration) error {
+	return sc.awaitTimeout(name, false, timeout)
+}
+
+func (sc *StateController) awaitTimeout(name string, active bool, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := sc.Await(ctx, name, active)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return sc.stateError(name, ErrAwaitTimeout)
+	}
+	return err
+}
+
+// notify wakes up all Await calls waiting on state. Must be called with the state's mutex held.
+func (s *delayedState) notify() {
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAwaitActive(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: 20 * time.Millisecond, DelayOnActivation: true})
+
+	sc.SetState("state1", true)
+	if err := sc.AwaitActive("state1", time.Second); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !sc.IsActive("state1") {
+		t.Fatal("Expected state1 to be active")
+	}
+
+	// Already active: returns at once.
+	if err := sc.AwaitActive("state1", 0); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestAwaitTimeout(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{})
+
+	if err := sc.AwaitActive("state1", 10*time.Millisecond); !errors.Is(err, ErrAwaitTimeout) {
+		t.Fatalf("Expected ErrAwaitTimeout, got %v", err)
+	}
+	if err := sc.AwaitInactive("missing", time.Second); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound, got %v", err)
+	}
+}
+
+func TestAwaitStateRemoved(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{})
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		sc.RemoveState("state1")
+	}()
+
+	if err := sc.Await(context.Background(), "state1", true); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound after removal, got %v", err)
+	}
+}
+
+func TestAwaitStateCleared(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{})
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		sc.Clear()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sc.Await(ctx, "state1", true); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound after Clear, got %v", err)
+	}
+}
+
+func TestAwaitStateReadded(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{})
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		sc.RemoveState("state1")
+		sc.AddState("state1", State{})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sc.Await(ctx, "state1", true); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound after the state was replaced, got %v", err)
+	}
+}
+
+func TestAwaitUnknown(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{IsActive: true})
+	sc.SetUnknown("state1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := sc.Await(ctx, "state1", true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the unknown value not to match, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		sc.SetState("state1", true)
+	}()
+	if err := sc.AwaitActive("state1", time.Second); err != nil {
+		t.Fatalf("Expected the known value to match, got %v", err)
+	}
+}
+
+func TestAwaitAllAndAny(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("a", State{Delay: 10 * time.Millisecond, DelayOnActivation: true})
+	sc.AddState("b", State{Delay: 30 * time.Millisecond, DelayOnActivation: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	sc.SetState("a", true)
+	sc.SetState("b", true)
+
+	if err := sc.AwaitAny(ctx, "a", "b"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !sc.IsActive("a") {
+		t.Fatal("Expected a, the faster state, to be active")
+	}
+
+	if err := sc.AwaitAll(ctx, "a", "b"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !sc.IsActive("a") || !sc.IsActive("b") {
+		t.Fatal("Expected both states to be active")
+	}
+
+	if err := sc.AwaitAll(ctx, "a", "missing"); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound, got %v", err)
+	}
+}
+
+func TestAwaitAnyContextDone(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("a", State{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := sc.AwaitAny(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+//go:build chaos
+
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ChaosConfig configures the faults injected by WithChaos.
+type ChaosConfig struct {
+	DeliveryDelay time.Duration // Maximum random delay before a change is delivered.
+	DropRate      float64       // Probability between 0 and 1 that a change is not delivered at all.
+	TimerJitter   time.Duration // Maximum random delay added to every timer, e.g. of delayed transitions.
+	Seed          int64         // Seed of the random choices.
+}
+
+// WithChaos injects faults for tests, so that integrations can be hardened against slow or lost
+// notifications and late timers: each change is delivered to onStateChange and subscriptions
+// after a random delay up to DeliveryDelay on the goroutine flushing it, or dropped with
+// probability DropRate, and every timer of the controller, e.g. of delayed transitions and
+// StaleAfter, fires up to TimerJitter late. The values of the states are not affected.
+// WithChaos only exists in builds with the chaos build tag (go test -tags chaos), so it cannot
+// end up in production binaries.
+func WithChaos(cfg ChaosConfig) Option {
+	return func(sc *StateController) {
+		sc.chaos = &chaos{cfg: cfg, rnd: rand.New(rand.NewSource(cfg.Seed))}
+	}
+}
+
+// chaos makes the random choices of WithChaos. A nil chaos injects no faults.
+type chaos struct {
+	cfg ChaosConfig
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// delivery returns how long to delay the delivery of a change, and whether to drop it instead.
+func (c *chaos) delivery() (time.Duration, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cfg.DropRate > 0 && c.rnd.Float64() < c.cfg.DropRate {
+		return 0, true
+	}
+	return c.random(c.cfg.DeliveryDelay), false
+}
+
+// jitter returns the random delay to add to a timer.
+func (c *chaos) jitter() time.Duration {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.random(c.cfg.TimerJitter)
+}
+
+// random returns a duration between zero and max. Must be called with c.mu held.
+func (c *chaos) random(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(c.rnd.Int63n(int64(max) + 1))
+}
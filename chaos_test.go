@@ -0,0 +1,59 @@
+//go:build chaos
+
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestChaosDeliveries(t *testing.T) {
+	delivered := 0
+	sc := NewStateController(
+		WithChaos(ChaosConfig{DropRate: 0.5, DeliveryDelay: time.Millisecond, Seed: 1}),
+		WithOnStateChange(func(name string, active bool) { delivered++ }),
+	)
+	defer sc.Close()
+	sc.AddState("state1", State{})
+
+	const changes = 100
+	for i := 0; i < changes; i++ {
+		sc.ForceState("state1", i%2 == 0)
+	}
+	if delivered == 0 || delivered == changes {
+		t.Fatalf("Expected some of the changes to be dropped, got %d of %d delivered", delivered, changes)
+	}
+	if sc.IsActive("state1") {
+		t.Fatal("Expected dropped deliveries not to affect the value")
+	}
+}
+
+func TestChaosTimerJitter(t *testing.T) {
+	sc := NewStateController(WithChaos(ChaosConfig{TimerJitter: 20 * time.Millisecond, Seed: 1}))
+	defer sc.Close()
+	for i := 0; i < 100; i++ {
+		if j := sc.chaos.jitter(); j < 0 || j > 20*time.Millisecond {
+			t.Fatalf("Expected the jitter to be at most 20ms, got %v", j)
+		}
+	}
+
+	sc.AddState("state1", State{Delay: 10 * time.Millisecond})
+	sc.SetState("state1", true)
+	start := time.Now()
+	sc.SetState("state1", false)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sc.Await(ctx, "state1", false); err != nil {
+		t.Fatal("Expected the delayed transition to fire despite the jitter")
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Fatalf("Expected the jitter not to fire the transition early, got %v", elapsed)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"time"
+)
+
+// timerBatch is the single timer firing all transitions with the same coalesced deadline; see
+// WithTimerCoalescing.
+type timerBatch struct {
+	timer  *trackedTimer
+	states map[*delayedState]string // May include states rescheduled or cancelled since joining.
+}
+
+// joinBatch rounds the deadline of state up to the coalescing granularity and schedules the
+// transition with the batch of that deadline, starting the batch's timer if it is the first.
+// Must be called with the state's mutex held.
+func (sc *StateController) joinBatch(name string, state *delayedState) {
+	key := sc.batchKey(state.deadline)
+	// Add keeps the monotonic clock reading of the deadline, unlike rounding the time itself.
+	offset := time.Duration(key - state.deadline.UnixNano())
+	state.deadline = state.deadline.Add(offset)
+	state.wallDeadline = state.wallDeadline.Add(offset)
+
+	sc.batchMu.Lock()
+	defer sc.batchMu.Unlock()
+	b, ok := sc.batches[key]
+	if !ok {
+		b = &timerBatch{states: make(map[*delayedState]string)}
+		b.timer = sc.afterFunc(sc.untilDue(state, time.Now()), func() { sc.fireBatch(key) })
+		sc.batches[key] = b
+	}
+	b.states[state] = name
+}
+
+// batchKey returns the deadline rounded up to the coalescing granularity, in Unix nanoseconds.
+func (sc *StateController) batchKey(deadline time.Time) int64 {
+	granularity := int64(sc.coalesceGranularity)
+	return (deadline.UnixNano() + granularity - 1) / granularity * granularity
+}
+
+// fireBatch fires the transitions of the batch with the given key that are still due.
+func (sc *StateController) fireBatch(key int64) {
+	sc.batchMu.Lock()
+	b := sc.batches[key]
+	delete(sc.batches, key)
+	sc.batchMu.Unlock()
+	if b == nil {
+		return
+	}
+
+	now := time.Now()
+	for state, name := range b.states {
+		if sc.fireIfDue(name, state, now) {
+			sc.rejoinBatch(name, state)
+		}
+	}
+}
+
+// rejoinBatch schedules the pending transition of state with the batch of its deadline again,
+// after its batch fired before the transition was due, e.g. as the wall clock was set back.
+// If the transition was rescheduled meanwhile, it already belongs to that batch, which is kept.
+func (sc *StateController) rejoinBatch(name string, state *delayedState) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if !state.removed && state.pending() {
+		sc.joinBatch(name, state)
+	}
+}
+
+// isBatched reports whether state is scheduled with the batch of its deadline.
+func (sc *StateController) isBatched(state *delayedState) bool {
+	sc.batchMu.Lock()
+	defer sc.batchMu.Unlock()
+	b, ok := sc.batches[sc.batchKey(state.deadline)]
+	if !ok {
+		return false
+	}
+	_, ok = b.states[state]
+	return ok
+}
+
+// stopBatches stops the timers of all batches.
+func (sc *StateController) stopBatches() {
+	sc.batchMu.Lock()
+	defer sc.batchMu.Unlock()
+	for key, b := range sc.batches {
+		b.timer.Stop()
+		delete(sc.batches, key)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithTimerCoalescing(t *testing.T) {
+	const granularity = 50 * time.Millisecond
+	var (
+		mu     sync.Mutex
+		events []StateEvent
+	)
+	sc := NewStateController(
+		WithTimerCoalescing(granularity),
+		WithInvariantChecks(true),
+		WithOnStateEvent(func(ctx context.Context, ev StateEvent) {
+			mu.Lock()
+			events = append(events, ev)
+			mu.Unlock()
+		}),
+	)
+	defer sc.Close()
+
+	for i := 0; i < 100; i++ {
+		name := "session" + strconv.Itoa(i)
+		sc.AddState(name, State{Delay: 20 * time.Millisecond, IsActive: true})
+		sc.SetState(name, false)
+	}
+	sc.AddState("kept", State{Delay: 20 * time.Millisecond, IsActive: true})
+	sc.SetState("kept", false)
+	sc.SetState("kept", true) // Cancelled; its batch must not deactivate it.
+
+	sc.batchMu.Lock()
+	batches := len(sc.batches)
+	sc.batchMu.Unlock()
+	if batches > 2 {
+		t.Fatalf("Expected the deadlines to share at most 2 timers, got %d", batches)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 100; i++ {
+		if err := sc.Await(ctx, "session"+strconv.Itoa(i), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !sc.IsActive("kept") {
+		t.Fatal("Expected the cancelled transition not to fire")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, ev := range events {
+		if ev.Cause == CauseTimer && ev.Deadline.UnixNano()%int64(granularity) != 0 {
+			t.Fatalf("Expected deadlines rounded to %s, got %s", granularity, ev.Deadline)
+		}
+	}
+}
+
+func TestWithTimerCoalescingClose(t *testing.T) {
+	sc := NewStateController(WithTimerCoalescing(time.Second))
+	sc.AddState("state1", State{Delay: time.Minute, IsActive: true})
+	sc.SetState("state1", false)
+
+	sc.Close()
+	if len(sc.batches) != 0 || sc.PendingGoroutines() != 0 {
+		t.Fatal("Expected Close to stop the batch timers")
+	}
+}
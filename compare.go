@@ -0,0 +1,188 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Comparison runs two state configurations, e.g. the current delays and a candidate, against the
+// same inputs and reports where their transitions diverge, so configuration changes can be
+// reviewed with evidence. Both run in dry-run mode, so callbacks given as options never fire.
+type Comparison struct {
+	current   *StateController
+	candidate *StateController
+
+	mu           sync.Mutex
+	currentLog   []StateEvent
+	candidateLog []StateEvent
+}
+
+// Divergence is a transition that differs between the configurations of a Comparison: the n-th
+// change of a state made by one configuration differs in value or time from the n-th change made by
+// the other, or has no counterpart.
+type Divergence struct {
+	Name      string      // Name of the state.
+	N         int         // Index of the change among the changes of the state, starting at 0.
+	Current   *StateEvent // Change made with the current configuration; nil if none.
+	Candidate *StateEvent // Change made with the candidate configuration; nil if none.
+}
+
+// String describes the divergence in one line.
+func (d Divergence) String() string {
+	return fmt.Sprintf("%s change %d: current %s, candidate %s",
+		d.Name, d.N, describeChange(d.Current), describeChange(d.Candidate))
+}
+
+func describeChange(ev *StateEvent) string {
+	switch {
+	case ev == nil:
+		return "none"
+	case ev.Unknown:
+		return "unknown at " + ev.Time.Format("15:04:05.000")
+	default:
+		return fmt.Sprintf("%t at %s", ev.Active, ev.Time.Format("15:04:05.000"))
+	}
+}
+
+// NewComparison creates a Comparison of the current and candidate state configurations.
+// opts apply to both, e.g. WithCancelOnOpposite or WithOnStateNotExist; callbacks given in opts
+// never fire. The comparison must be closed when no longer needed.
+func NewComparison(current, candidate map[string]State, opts ...Option) *Comparison {
+	c := &Comparison{}
+	c.current = newComparedController(current, opts, func(ctx context.Context, ev StateEvent) {
+		c.mu.Lock()
+		c.currentLog = append(c.currentLog, ev)
+		c.mu.Unlock()
+	})
+	c.candidate = newComparedController(candidate, opts, func(ctx context.Context, ev StateEvent) {
+		c.mu.Lock()
+		c.candidateLog = append(c.candidateLog, ev)
+		c.mu.Unlock()
+	})
+	return c
+}
+
+func newComparedController(states map[string]State, opts []Option, report StateEventCallback) *StateController {
+	opts = append(opts[:len(opts):len(opts)], WithInitializeStates(states), WithDryRun(report))
+	return NewStateController(opts...)
+}
+
+// SetState passes the input to both configurations. Returns the error of the current
+// configuration, if any, or else that of the candidate.
+func (c *Comparison) SetState(name string, active bool, opts ...SetOption) error {
+	errCurrent := c.current.SetState(name, active, opts...)
+	errCandidate := c.candidate.SetState(name, active, opts...)
+	if errCurrent != nil {
+		return errCurrent
+	}
+	return errCandidate
+}
+
+// Settle blocks until no transition of either configuration is pending or ctx is done, so a
+// following Report covers all transitions the inputs so far lead to.
+func (c *Comparison) Settle(ctx context.Context) error {
+	for _, sc := range []*StateController{c.current.shadow, c.candidate.shadow} {
+		for _, state := range sc.loadIndex() {
+			if err := sc.awaitSettled(ctx, state); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Report returns the divergent transitions so far, ordered by time. Changes to the same value
+// within tolerance of each other are considered equal. Transitions still pending are not
+// included; call Settle first to include them.
+func (c *Comparison) Report(tolerance time.Duration) []Divergence {
+	c.mu.Lock()
+	current := changesByState(c.currentLog)
+	candidate := changesByState(c.candidateLog)
+	c.mu.Unlock()
+
+	names := make(map[string]struct{}, len(current)+len(candidate))
+	for name := range current {
+		names[name] = struct{}{}
+	}
+	for name := range candidate {
+		names[name] = struct{}{}
+	}
+
+	var report []Divergence
+	for name := range names {
+		a, b := current[name], candidate[name]
+		for n := 0; n < len(a) || n < len(b); n++ {
+			d := Divergence{Name: name, N: n}
+			if n < len(a) {
+				d.Current = &a[n]
+			}
+			if n < len(b) {
+				d.Candidate = &b[n]
+			}
+			if !sameChange(d.Current, d.Candidate, tolerance) {
+				report = append(report, d)
+			}
+		}
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		ti, tj := report[i].time(), report[j].time()
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		if report[i].Name != report[j].Name {
+			return report[i].Name < report[j].Name
+		}
+		return report[i].N < report[j].N
+	})
+	return report
+}
+
+// Close releases the resources of both configurations.
+func (c *Comparison) Close() {
+	c.current.Close()
+	c.candidate.Close()
+}
+
+// time returns the time of the earlier change of the divergence.
+func (d Divergence) time() time.Time {
+	switch {
+	case d.Current == nil:
+		return d.Candidate.Time
+	case d.Candidate == nil || d.Current.Time.Before(d.Candidate.Time):
+		return d.Current.Time
+	default:
+		return d.Candidate.Time
+	}
+}
+
+func changesByState(log []StateEvent) map[string][]StateEvent {
+	changes := make(map[string][]StateEvent)
+	for _, ev := range log {
+		changes[ev.Name] = append(changes[ev.Name], ev)
+	}
+	return changes
+}
+
+func sameChange(a, b *StateEvent, tolerance time.Duration) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	if a.Active != b.Active || a.Unknown != b.Unknown {
+		return false
+	}
+	diff := a.Time.Sub(b.Time)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= tolerance
+}
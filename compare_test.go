@@ -0,0 +1,75 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComparison(t *testing.T) {
+	changes := 0
+	c := NewComparison(
+		map[string]State{"door": {Delay: 10 * time.Millisecond}, "light": {}},
+		map[string]State{"door": {Delay: time.Second}, "light": {}},
+		WithOnStateChange(func(name string, active bool) { changes++ }),
+	)
+	defer c.Close()
+
+	c.SetState("light", true)
+	c.SetState("door", true)
+	c.SetState("door", false)
+	time.Sleep(100 * time.Millisecond)
+	c.SetState("door", true) // Cancels the pending deactivation of the candidate only.
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Settle(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	report := c.Report(5 * time.Millisecond)
+	if len(report) != 2 {
+		t.Fatalf("Expected 2 divergences, got %v", report)
+	}
+	// Current deactivated door and activated it again; the candidate never deactivated it.
+	if d := report[0]; d.Name != "door" || d.N != 1 || d.Current == nil || d.Current.Active || d.Candidate != nil {
+		t.Fatalf("Expected the deactivation of door by current only, got %v", d)
+	}
+	if d := report[1]; d.Name != "door" || d.N != 2 || d.Current == nil || !d.Current.Active || d.Candidate != nil {
+		t.Fatalf("Expected the reactivation of door by current only, got %v", d)
+	}
+	if s := report[0].String(); !strings.HasPrefix(s, "door change 1: current false at ") || !strings.HasSuffix(s, ", candidate none") {
+		t.Fatalf("Unexpected description %q", s)
+	}
+	if changes != 0 {
+		t.Fatalf("Expected callbacks never to fire, got %d changes", changes)
+	}
+}
+
+func TestComparisonReportTolerance(t *testing.T) {
+	c := NewComparison(
+		map[string]State{"door": {Delay: 10 * time.Millisecond}},
+		map[string]State{"door": {Delay: 300 * time.Millisecond}},
+	)
+	defer c.Close()
+
+	c.SetState("door", true)
+	c.SetState("door", false)
+	if err := c.Settle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if report := c.Report(time.Hour); len(report) != 0 {
+		t.Fatalf("Expected no divergence within tolerance, got %v", report)
+	}
+	if report := c.Report(time.Millisecond); len(report) != 1 || report[0].Current.Time.After(report[0].Candidate.Time) {
+		t.Fatalf("Expected the earlier deactivation by current, got %v", report)
+	}
+}
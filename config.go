@@ -0,0 +1,298 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variable suffixes recognised by StatesFromEnv.
+const (
+	envDelaySuffix             = "_DELAY"
+	envDelayOnActivationSuffix = "_DELAY_ON_ACTIVATION"
+	envActiveSuffix            = "_ACTIVE"
+	envTagsSuffix              = "_TAGS"
+)
+
+// StatesFromEnv builds state configurations from environment variables of the form
+// <PREFIX>_<NAME>_DELAY, <PREFIX>_<NAME>_DELAY_ON_ACTIVATION, <PREFIX>_<NAME>_ACTIVE and
+// <PREFIX>_<NAME>_TAGS (comma-separated),
+// e.g. DELAYEDSTATE_DOOR_DELAY=5m defines the state "door" with a five minute delay.
+// Delays are parsed with ParseDelay.
+// State names are lower-cased. The result can be passed to WithInitializeStates.
+func StatesFromEnv(prefix string) (map[string]State, error) {
+	return statesFromEnviron(prefix, os.Environ())
+}
+
+func statesFromEnviron(prefix string, environ []string) (map[string]State, error) {
+	prefix = strings.TrimSuffix(prefix, "_") + "_"
+	states := make(map[string]State)
+
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		key = strings.TrimPrefix(key, prefix)
+
+		var err error
+		switch {
+		case strings.HasSuffix(key, envDelayOnActivationSuffix):
+			name := strings.ToLower(strings.TrimSuffix(key, envDelayOnActivationSuffix))
+			state := states[name]
+			state.DelayOnActivation, err = strconv.ParseBool(value)
+			states[name] = state
+		case strings.HasSuffix(key, envDelaySuffix):
+			name := strings.ToLower(strings.TrimSuffix(key, envDelaySuffix))
+			state := states[name]
+			state.Delay, err = ParseDelay(value)
+			states[name] = state
+		case strings.HasSuffix(key, envActiveSuffix):
+			name := strings.ToLower(strings.TrimSuffix(key, envActiveSuffix))
+			state := states[name]
+			state.IsActive, err = strconv.ParseBool(value)
+			states[name] = state
+		case strings.HasSuffix(key, envTagsSuffix):
+			name := strings.ToLower(strings.TrimSuffix(key, envTagsSuffix))
+			state := states[name]
+			state.Tags = splitTags(value)
+			states[name] = state
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("env %s%s: %w", prefix, key, err)
+		}
+	}
+
+	delete(states, "")
+	return states, nil
+}
+
+// StatesFromSettings builds state configurations from a generic settings tree, as returned by
+// viper's Sub(key).AllSettings() or koanf's Cut(key).Raw(). Each top-level key is a state name
+// mapping to the keys "delay", "delay_on_activation", "active" and "tags". Delays may be given as a
+// string accepted by ParseDelay, a time.Duration, or a number of seconds. Keys are matched
+// case-insensitively and ignore "_" and "-", since viper lower-cases all keys.
+func StatesFromSettings(settings map[string]interface{}) (map[string]State, error) {
+	states := make(map[string]State, len(settings))
+
+	for name, raw := range settings {
+		fields, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("setting %s: expected a map, got %T", name, raw)
+		}
+
+		var state State
+		for key, value := range fields {
+			var err error
+			switch normalizeSettingKey(key) {
+			case "delay":
+				state.Delay, err = settingDelay(value)
+			case "delayonactivation":
+				state.DelayOnActivation, err = settingBool(value)
+			case "active", "isactive":
+				state.IsActive, err = settingBool(value)
+			case "tags":
+				state.Tags, err = settingTags(value)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("setting %s.%s: %w", name, key, err)
+			}
+		}
+		states[name] = state
+	}
+
+	return states, nil
+}
+
+// Reconcile makes the controller's states match the given configuration, e.g. after a live
+// configuration reload: missing states are added, states not in the configuration are removed,
+// and the delay settings and tags of existing states are updated. Existing states keep their current
+// IsActive value and the settings not covered by configuration files, such as PendingPolicy and
+// StaleAfter, and their pending timers are only cancelled if their configuration changes.
+// onStateChange is fired as for RemoveState and UpdateState.
+// States with an invalid configuration are left as they are, and their errors are returned.
+func (sc *StateController) Reconcile(states map[string]State) error {
+	sc.RemoveWhere(func(name string, _ State) bool {
+		_, keep := states[name]
+		return !keep
+	})
+
+	var errs reconcileErrors
+	for _, name := range stateNames(states) {
+		state := states[name]
+		current, err := sc.GetState(name)
+		if err != nil {
+			// The state may have been added concurrently.
+			if err := sc.AddState(name, state); err != nil && !errors.Is(err, ErrStateExists) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if !configChanged(current, state) {
+			continue
+		}
+		state.IsActive = current.IsActive
+		state.PendingPolicy = current.PendingPolicy
+		state.Extension = current.Extension
+		state.Clock = current.Clock
+		state.StaleAfter = current.StaleAfter
+		if err := sc.UpdateState(name, state); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// reconcileErrors are the errors of the states Reconcile could not apply.
+type reconcileErrors []error
+
+func (e reconcileErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the errors matches target.
+func (e reconcileErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// stateNames returns the names of states, sorted.
+func stateNames(states map[string]State) []string {
+	names := make([]string, 0, len(states))
+	for name := range states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// configChanged reports whether Reconcile updates current to the configuration state.
+func configChanged(current, state State) bool {
+	return current.Delay != state.Delay || current.DelayOnActivation != state.DelayOnActivation ||
+		!equalTags(current.Tags, state.Tags)
+}
+
+func normalizeSettingKey(key string) string {
+	key = strings.ToLower(key)
+	key = strings.ReplaceAll(key, "_", "")
+	return strings.ReplaceAll(key, "-", "")
+}
+
+func settingDelay(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case string:
+		return ParseDelay(v)
+	case time.Duration:
+		return v, nil
+	case int:
+		return time.Duration(v) * time.Second, nil
+	case int64:
+		return time.Duration(v) * time.Second, nil
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	default:
+		return 0, fmt.Errorf("unsupported delay type %T", value)
+	}
+}
+
+func settingTags(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return splitTags(v), nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		tags := make([]string, 0, len(v))
+		for _, t := range v {
+			tag, ok := t.(string)
+			if !ok {
+				return nil, fmt.Errorf("unsupported tag type %T", t)
+			}
+			tags = append(tags, tag)
+		}
+		return tags, nil
+	default:
+		return nil, fmt.Errorf("unsupported tags type %T", value)
+	}
+}
+
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func equalTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func settingBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		return strconv.ParseBool(v)
+	default:
+		return false, fmt.Errorf("unsupported bool type %T", value)
+	}
+}
+
+// StateVar registers flags on fs that configure state: -<name>-delay and -<name>-delay-on-activation.
+// The current values of state are used as flag defaults. Since pflag can import a standard
+// flag.FlagSet via AddGoFlagSet, the same binding also works for pflag-based command lines.
+func StateVar(fs *flag.FlagSet, state *State, name string, usage string) {
+	fs.Var((*delayValue)(&state.Delay), name+"-delay", usage+" (delay)")
+	fs.BoolVar(&state.DelayOnActivation, name+"-delay-on-activation", state.DelayOnActivation, usage+" (delay activation instead of deactivation)")
+}
+
+// delayValue is a flag.Value for a state delay.
+type delayValue time.Duration
+
+func (d *delayValue) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *delayValue) Set(s string) error {
+	v, err := ParseDelay(s)
+	if err != nil {
+		return err
+	}
+	*d = delayValue(v)
+	return nil
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatesFromEnviron(t *testing.T) {
+	environ := []string{
+		"DELAYEDSTATE_DOOR_DELAY=5m",
+		"DELAYEDSTATE_BUTTON_DELAY=500ms",
+		"DELAYEDSTATE_BUTTON_DELAY_ON_ACTIVATION=true",
+		"DELAYEDSTATE_LIGHT_ACTIVE=true",
+		"DELAYEDSTATE_LIGHT_DELAY=PT1H",
+		"DELAYEDSTATE_LIGHT_TAGS=zone1, indoor",
+		"DELAYEDSTATE_UNRELATED=1",
+		"OTHER_DOOR_DELAY=1s",
+	}
+
+	states, err := statesFromEnviron("DELAYEDSTATE", environ)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(states) != 3 {
+		t.Fatalf("Expected 3 states, got %d: %v", len(states), states)
+	}
+	if states["door"].Delay != 5*time.Minute {
+		t.Fatalf("Expected door delay 5m, got %v", states["door"].Delay)
+	}
+	if states["button"].Delay != 500*time.Millisecond || !states["button"].DelayOnActivation {
+		t.Fatalf("Expected button to delay activation by 500ms, got %+v", states["button"])
+	}
+	if !states["light"].IsActive || states["light"].Delay != time.Hour {
+		t.Fatalf("Expected light to be initially active with 1h delay, got %+v", states["light"])
+	}
+	if tags := states["light"].Tags; len(tags) != 2 || tags[0] != "zone1" || tags[1] != "indoor" {
+		t.Fatalf("Expected light tags [zone1 indoor], got %v", tags)
+	}
+}
+
+func TestStatesFromEnvironInvalidValue(t *testing.T) {
+	_, err := statesFromEnviron("DELAYEDSTATE_", []string{"DELAYEDSTATE_DOOR_DELAY=soon"})
+	if err == nil {
+		t.Fatal("Expected error for invalid delay")
+	}
+}
+
+func TestStatesFromEnv(t *testing.T) {
+	t.Setenv("DELAYEDSTATE_TEST_DOOR_DELAY", "2s")
+
+	states, err := StatesFromEnv("DELAYEDSTATE_TEST")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if states["door"].Delay != 2*time.Second {
+		t.Fatalf("Expected door delay 2s, got %v", states["door"].Delay)
+	}
+}
+
+func TestStateVar(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	state := State{Delay: time.Second}
+	StateVar(fs, &state, "door", "door sensor")
+
+	err := fs.Parse([]string{"-door-delay=1d", "-door-delay-on-activation"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if state.Delay != 24*time.Hour {
+		t.Fatalf("Expected delay 24h, got %v", state.Delay)
+	}
+	if !state.DelayOnActivation {
+		t.Fatal("Expected DelayOnActivation to be set")
+	}
+}
+
+func TestStateVarDefault(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	state := State{Delay: time.Second}
+	StateVar(fs, &state, "door", "door sensor")
+
+	if got := fs.Lookup("door-delay").DefValue; got != "1s" {
+		t.Fatalf("Expected default '1s', got '%s'", got)
+	}
+}
+
+func TestStatesFromSettings(t *testing.T) {
+	settings := map[string]interface{}{
+		"door": map[string]interface{}{
+			"delay": "5m",
+		},
+		"button": map[string]interface{}{
+			"delay":             0.5,
+			"delayonactivation": true,
+		},
+		"light": map[string]interface{}{
+			"Delay":  2 * time.Second,
+			"active": "true",
+			"tags":   []interface{}{"zone1"},
+		},
+	}
+
+	states, err := StatesFromSettings(settings)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if states["door"].Delay != 5*time.Minute {
+		t.Fatalf("Expected door delay 5m, got %v", states["door"].Delay)
+	}
+	if states["button"].Delay != 500*time.Millisecond || !states["button"].DelayOnActivation {
+		t.Fatalf("Expected button to delay activation by 500ms, got %+v", states["button"])
+	}
+	if states["light"].Delay != 2*time.Second || !states["light"].IsActive || len(states["light"].Tags) != 1 {
+		t.Fatalf("Expected light to be active with 2s delay, got %+v", states["light"])
+	}
+}
+
+func TestStatesFromSettingsInvalid(t *testing.T) {
+	_, err := StatesFromSettings(map[string]interface{}{"door": "5m"})
+	if err == nil {
+		t.Fatal("Expected error for non-map state setting")
+	}
+
+	_, err = StatesFromSettings(map[string]interface{}{
+		"door": map[string]interface{}{"delay": []int{1}},
+	})
+	if err == nil {
+		t.Fatal("Expected error for unsupported delay type")
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("keep", State{Delay: time.Second})
+	sc.AddState("update", State{Delay: time.Second})
+	sc.AddState("remove", State{Delay: time.Second})
+	sc.SetState("keep", true)
+	sc.SetState("keep", false) // pending deactivation
+	sc.SetState("update", true)
+
+	sc.Reconcile(map[string]State{
+		"keep":   {Delay: time.Second},
+		"update": {Delay: time.Minute},
+		"add":    {Delay: time.Second},
+	})
+
+	if sc.HasState("remove") {
+		t.Fatal("Expected 'remove' to be removed")
+	}
+	if !sc.HasState("add") {
+		t.Fatal("Expected 'add' to be added")
+	}
+
+	state, _ := sc.GetState("update")
+	if state.Delay != time.Minute {
+		t.Fatalf("Expected 'update' delay to be 1m, got %v", state.Delay)
+	}
+	if !state.IsActive {
+		t.Fatal("Expected 'update' to keep its active value")
+	}
+
+	pending := sc.PendingStates()
+	if len(pending) != 1 || pending[0] != "keep" {
+		t.Fatalf("Expected unchanged 'keep' to keep its pending timer, got %v", pending)
+	}
+}
+
+func TestReconcileKeepsCodeSettings(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("update", State{Delay: time.Second, PendingPolicy: PendingExtendBy, Extension: time.Second, StaleAfter: time.Hour})
+
+	if err := sc.Reconcile(map[string]State{"update": {Delay: time.Minute}}); err != nil {
+		t.Fatal(err)
+	}
+	state, _ := sc.GetState("update")
+	if state.Delay != time.Minute || state.PendingPolicy != PendingExtendBy || state.Extension != time.Second ||
+		state.StaleAfter != time.Hour {
+		t.Fatalf("Expected the settings not in the configuration to be kept, got %+v", state)
+	}
+}
+
+func TestReconcileInvalidStates(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("update", State{Delay: time.Second})
+
+	err := sc.Reconcile(map[string]State{
+		"add":    {Delay: -5 * time.Minute},
+		"update": {Delay: -5 * time.Minute},
+		"valid":  {Delay: time.Second},
+	})
+	if !errors.Is(err, ErrInvalidState) || !strings.Contains(err.Error(), "add") || !strings.Contains(err.Error(), "update") {
+		t.Fatalf("Expected the errors of both invalid states, got %v", err)
+	}
+	if sc.HasState("add") || !sc.HasState("valid") {
+		t.Fatal("Expected only the valid state to be added")
+	}
+	if state, _ := sc.GetState("update"); state.Delay != time.Second {
+		t.Fatalf("Expected the invalid update to be left out, got %v", state.Delay)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"time"
+)
+
+// detachedContext carries the values of its parent but none of its cancellation or deadline.
+// It is used for delayed transitions, which fire long after the causing call has returned.
+type detachedContext struct {
+	parent context.Context
+}
+
+func detachContext(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+type requestedByKey struct{}
+
+// WithRequestedBy returns a copy of ctx naming who requested a change, e.g. a user or service.
+// The name is reported as StateEvent.RequestedBy for changes caused by calls with this context,
+// including delayed transitions they scheduled.
+func WithRequestedBy(ctx context.Context, requester string) context.Context {
+	return context.WithValue(ctx, requestedByKey{}, requester)
+}
+
+// RequestedBy returns the requester set with WithRequestedBy, or "" if none is set.
+func RequestedBy(ctx context.Context) string {
+	requester, _ := ctx.Value(requestedByKey{}).(string)
+	return requester
+}
+
+type qualityKey struct{}
+
+// withQuality returns a copy of ctx carrying the quality of a request; see WithQuality.
+func withQuality(ctx context.Context, quality float64) context.Context {
+	return context.WithValue(ctx, qualityKey{}, quality)
+}
+
+// qualityFrom returns the quality carried by ctx, or 1 if none is set.
+func qualityFrom(ctx context.Context) float64 {
+	if quality, ok := ctx.Value(qualityKey{}).(float64); ok {
+		return quality
+	}
+	return 1
+}
+
+type priorityKey struct{}
+
+// withPriority returns a copy of ctx carrying the priority of a request; see WithPriority.
+func withPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// priorityFrom returns the priority carried by ctx, or PriorityNormal if none is set.
+func priorityFrom(ctx context.Context) Priority {
+	priority, _ := ctx.Value(priorityKey{}).(Priority)
+	return priority
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -7,16 +7,21 @@
 package delayedstate
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Sentinel errors for type-safe error checking via errors.Is.
 var (
-	ErrStateNotFound = errors.New("state not found")
-	ErrStateExists   = errors.New("state already exists")
+	ErrStateNotFound  = errors.New("state not found")
+	ErrStateExists    = errors.New("state already exists")
+	ErrNotPending     = errors.New("no pending transition")
+	ErrTooManyPending = errors.New("too many pending transitions")
+	ErrStateDisabled  = errors.New("state disabled")
 )
 
 const (
@@ -26,209 +31,668 @@ const (
 // StateChangeCallback is called when a state's IsActive value changes.
 type StateChangeCallback func(name string, active bool)
 
+// StateChangeContextCallback is like StateChangeCallback, but also receives the context of the
+// call that caused the change, so request-scoped values (trace IDs, source) reach the hook.
+type StateChangeContextCallback func(ctx context.Context, name string, active bool)
+
+// StateFactory creates the configuration for a state that does not exist yet.
+// It is always called outside of the controller lock, so it may block (e.g. on a database lookup).
+type StateFactory func(ctx context.Context, name string) (State, error)
+
 // State holds the configuration and current status of a single managed state.
 type State struct {
 	IsActive          bool
 	DelayOnActivation bool          // If true, activation is delayed; otherwise deactivation is delayed.
 	Delay             time.Duration // Configurable delay time for the state transition.
+	Tags              []string      // Optional labels, e.g. for filtering subscriptions.
+
+	// Unknown marks the value as not known, e.g. for a sensor that has not reported since
+	// startup. IsActive then holds the initial or last known value. Cleared by SetState,
+	// ForceState or Reset; see IsUnknown and SetUnknown.
+	Unknown bool
+	// StaleAfter, if positive, marks the value unknown if the state is not set again within
+	// this duration of the last SetState, ForceState or Reset.
+	StaleAfter time.Duration
+
+	// PendingPolicy decides what a repeated request for a pending delayed transition does,
+	// e.g. a second SetState(false) while a deactivation is pending. By default it is ignored.
+	PendingPolicy PendingPolicy
+	Extension     time.Duration // Deadline extension per repeated request for PendingExtendBy.
+
+	// Clock decides whether delays are measured as durations on the monotonic clock or as
+	// deadlines on the wall clock, which matters when the wall clock is changed.
+	Clock Clock
 }
 
 // StateController manages multiple states and their transitions.
+//
+// The states index is copy-on-write: writers serialize on mu, copy the current map, modify the
+// copy and publish it atomically, so lookups never block, even during heavy AddState/RemoveState
+// churn. The trade-off is an O(n) copy per add or remove. Each state carries its own mutex for
+// its value and timer, so operations on different states never contend with each other.
+// Whenever both are needed, mu is acquired before a state's mutex.
 type StateController struct {
-	mu     sync.RWMutex
-	states map[string]*delayedState
+	// seq numbers recorded changes in transition order. Accessed atomically; kept first for
+	// 64-bit alignment on 32-bit platforms.
+	seq uint64
+
+	// indexGen counts published indexes; see sortedNames. Accessed atomically.
+	indexGen uint64
+
+	mu     sync.Mutex
+	states map[string]*delayedState // Latest published index; only accessed with mu held.
+	index  atomic.Value             // map[string]*delayedState; immutable once published.
+
+	// denseNames and denseIDs map the IDs assigned by Register to state names; only accessed
+	// with mu held. dense is the *denseIndex republished with every index.
+	denseNames []string
+	denseIDs   map[string]StateID
+	dense      atomic.Value
+
+	// sorted caches the sorted state names of index generation sortedGen for List.
+	sortedMu  sync.Mutex
+	sorted    []string
+	sortedGen uint64
+
+	// creating tracks in-flight lazy creations so that concurrent callers share one factory call.
+	creatingMu sync.Mutex
+	creating   map[string]*creation
+
+	// Subscriptions; consumerCount mirrors len(consumers) for a lock-free fast path.
+	subsMu        sync.RWMutex
+	consumers     map[*consumer]struct{}
+	durable       map[string]*consumer
+	consumerCount int32
+
+	// latency records how late timers fire; see FiringLatency.
+	latency latencyHistogram
+
+	// holds records lock hold and callback times; see WithStallDetection.
+	holds holdStats
+
+	// routines counts the controller's goroutines; see PendingGoroutines.
+	routines routines
+
+	// idempotency remembers the keys of WithIdempotencyKey requests.
+	idempotency idempotencyKeys
+
+	// shadow processes SetState inputs instead of this controller in dry-run mode; nil otherwise.
+	shadow *StateController
+
+	// clock replaces time.Now for scheduling and recording changes in scenarios; nil otherwise.
+	// Transitions are then scheduled in ticked and only fire when the scenario advances the clock.
+	clock func() time.Time
+
+	// ticked holds the states scheduled with WithTickInterval or a clock, possibly including some that are
+	// no longer pending. Locked after a state's mutex.
+	tickMu sync.Mutex
+	ticked map[*delayedState]string
+
+	// batches holds the timers of coalesced deadlines by Unix nanoseconds; see
+	// WithTimerCoalescing. Locked after a state's mutex.
+	batchMu sync.Mutex
+	batches map[int64]*timerBatch
+
+	// suppressions holds the running suppressions by tag; see Suppress. suppressCount mirrors
+	// len(suppressions) for a lock-free fast path. Locked after a state's mutex.
+	suppressMu    sync.Mutex
+	suppressions  map[string]*suppression
+	suppressCount int32
+
+	// inGrace is 1 during the boot grace period of WithBootGrace, which graceTimer ends.
+	// Accessed atomically.
+	inGrace    int32
+	graceTimer *trackedTimer
+
+	// stop ends the background checks of WithTickInterval and WithWallClockDeadlines; nil if
+	// neither is enabled.
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// closed is closed by the first Close, after the WithOnClose hooks ran.
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	created time.Time // When the controller was created; see Healthy.
+
+	// pendingCount is the number of pending transitions, capped by WithMaxPending. Accessed atomically.
+	pendingCount int32
+
+	// bypassAll is 1 while SetBypassAll is in effect. Accessed atomically.
+	bypassAll int32
+
+	// draining is 1 once Drain was called. Accessed atomically.
+	draining int32
+
+	// dispatchers deliver onStateChange asynchronously; empty unless WithAsyncCallbacks is set.
+	dispatchers []*dispatcher
 
 	// Options
-	onStateNotExist func(name string) (State, error)
-	onStateChange   StateChangeCallback
+	name            string
+	onStateNotExist StateFactory
+	onStateChange   StateEventCallback
+	asyncQueueSize  int
+	asyncOverflow   OverflowPolicy
+	asyncWorkers    int
+	suppressNoops   bool
+	chaos           *chaos // Nil without WithChaos.
+	invariantChecks bool
+
+	keepPendingOnOpposite bool
+	onBypassChange        func(bypass bool)
+	wallCheckInterval     time.Duration
+	jumpThreshold         time.Duration
+	bootGrace             time.Duration
+	suppressPolicy        SuppressPolicy
+	onClockJump           func(ClockJump)
+	tickInterval          time.Duration
+	sampleInterval        time.Duration
+	coalesceGranularity   time.Duration
+	maxPending            int
+	stallThreshold        time.Duration
+	onStall               func(Stall)
+	onStart               []func(*StateController)
+	onClose               []func(*StateController)
+	idempotencyWindow     time.Duration
+	authorizer            Authorizer
+	dryRunReport          StateEventCallback
+	escalations           map[string][]Escalation
+	seriesRetention       time.Duration
+	seriesMax             int
+	seriesEnabled         bool
+	onEscalation          func(EscalationEvent)
+
+	// The first initial state of WithInitializeStates that is invalid, and its error; see InitError.
+	invalidState    string
+	invalidStateErr error
 }
 
 // delayedState handles the state, timer, and delay for an individual state.
 type delayedState struct {
+	mu sync.Mutex
 	State
-	delayedTimer *time.Timer
+	delayedTimer  *trackedTimer   // Nil with WithTickInterval or WithTimerCoalescing, which fire transitions otherwise.
+	deadline      time.Time       // When the pending timer fires.
+	wallDeadline  time.Time       // The deadline by the wall clock; see Clock.
+	pendingTarget bool            // Value the pending timer transitions to.
+	pendingCtx    context.Context // Context passed to callbacks when the pending timer fires.
+	deferredCtx   context.Context // Context of an opposing request deferred until the timer fires.
+	scheduledAt   time.Time       // When the pending transition was scheduled.
+
+	delaysDisabled bool // Set by SetDelaysEnabled; transitions are applied immediately.
+	disabled       bool // Set by DisableState; the value is frozen.
+
+	quality    float64       // Quality of the request that set the current value; see WithQuality.
+	staleTimer *trackedTimer // Watchdog for State.StaleAfter.
+	staleGen   uint64        // Identifies staleTimer; see timerGen.
+	requested  bool          // Value of the latest request (SetState, Reset, UpdateState), which IsActive follows.
+	version    uint64        // Number of accepted writes; see SetStateVersioned.
+	timerGen   uint64        // Identifies the current timer, so a stale timer that fired late is ignored.
+	removed    bool          // Set once the state is removed from the index; guards against late timers.
+
+	held      *heldRequest // Latest request held back by WithBootGrace, Suppress or Override, if any.
+	overrides []*override  // Running overrides set with OverrideFrom, in the order they were set.
+	sampled   *heldRequest // Latest request not yet taken by WithInputSampling, if any.
+
+	escalationTimers []*trackedTimer // Timers of the escalation rules for the current value.
+	escalationGen    uint64          // Identifies escalationTimers; see timerGen.
+	series           []Sample        // Recorded values, oldest first; see WithSeries.
+
+	leaseOwner   string    // Holder of the lease set with Acquire, if any.
+	leaseExpires time.Time // When the lease ends; zero if it lasts until Release.
+
+	// outbox holds changes recorded under mu that are not yet delivered to onStateChange.
+	// Only one goroutine at a time (the one that set flushing) delivers them, in order.
+	outbox   []callbackEvent
+	flushing bool
+
+	pendingCount *int32 // The controller's count of pending transitions.
+
+	changed    chan struct{} // Closed on the next change of IsActive or removal; nil if nobody awaits.
+	lastChange time.Time     // Time of the last change of IsActive.
+	changes    uint64        // Number of changes of IsActive.
+}
+
+// Name returns the name set with WithName, or "" if none is set.
+func (sc *StateController) Name() string {
+	return sc.name
+}
+
+// stateError wraps err with the state name and, if set, the controller name.
+func (sc *StateController) stateError(name string, err error) error {
+	if sc.name != "" {
+		return fmt.Errorf("%s: "+stateErrorFormat, sc.name, name, err)
+	}
+	return fmt.Errorf(stateErrorFormat, name, err)
+}
+
+func (sc *StateController) newDelayedState(state State) *delayedState {
+	return &delayedState{State: state, requested: state.IsActive, quality: 1, pendingCount: &sc.pendingCount}
+}
+
+// startTracking starts the escalation rules and the series of a new state, added at now.
+// Must be called before the state is published.
+func (sc *StateController) startTracking(name string, state *delayedState, now time.Time) {
+	if len(sc.escalations) > 0 {
+		sc.armEscalations(name, state, now)
+	}
+	if sc.seriesEnabled {
+		sc.addSample(state, now)
+	}
 }
 
-// NewStateController initializes a new StateController.
+// creation is a single in-flight lazy creation of a state.
+type creation struct {
+	done     chan struct{}
+	err      error
+	canceled bool // The factory failed as the context of its caller was done.
+}
+
+// New is like NewStateController, but returns an error if an initial state of
+// WithInitializeStates is invalid, e.g. has a negative Delay. The controller is closed again then.
+func New(opts ...Option) (*StateController, error) {
+	sc := NewStateController(opts...)
+	if err := sc.InitError(); err != nil {
+		sc.Close()
+		return nil, err
+	}
+	return sc, nil
+}
+
+// NewStateController initializes a new StateController. Invalid initial states of
+// WithInitializeStates are left out; InitError returns the error of the first one, and New
+// fails instead.
 func NewStateController(opts ...Option) *StateController {
 	sc := StateController{
-		states: make(map[string]*delayedState),
+		states:       make(map[string]*delayedState),
+		creating:     make(map[string]*creation),
+		consumers:    make(map[*consumer]struct{}),
+		durable:      make(map[string]*consumer),
+		suppressions: make(map[string]*suppression),
+		closed:       make(chan struct{}),
+		created:      time.Now(),
+
+		idempotencyWindow: defaultIdempotencyWindow,
 	}
 
 	sc.addOptions(opts...)
+	sc.publish(sc.states)
+	sc.idempotency.now = sc.now
+
+	if sc.dryRunReport != nil {
+		sc.shadow = sc.newShadow(sc.dryRunReport)
+	}
+
+	if sc.stallThreshold > 0 && sc.onStateChange != nil {
+		sc.onStateChange = sc.timeCallback(sc.onStateChange)
+	}
+
+	if sc.asyncQueueSize > 0 && sc.onStateChange != nil {
+		workers := sc.asyncWorkers
+		if workers < 1 {
+			workers = 1
+		}
+		for i := 0; i < workers; i++ {
+			sc.dispatchers = append(sc.dispatchers, newDispatcher(sc.onStateChange, sc.asyncQueueSize, sc.asyncOverflow, &sc.routines))
+		}
+	}
+
+	if sc.tickInterval > 0 || sc.wallCheckInterval > 0 || sc.jumpThreshold > 0 || sc.sampleInterval > 0 {
+		sc.stop = make(chan struct{})
+	}
+	if sc.tickInterval > 0 || sc.clock != nil {
+		sc.ticked = make(map[*delayedState]string)
+	}
+	if sc.coalesceGranularity > 0 && sc.ticked == nil {
+		sc.batches = make(map[int64]*timerBatch)
+	}
+	if sc.tickInterval > 0 {
+		sc.routines.goTracked(func() { sc.runTicker(sc.tickInterval, sc.stop) })
+	}
+	if interval := sc.wallWatchInterval(); interval > 0 {
+		sc.routines.goTracked(func() { sc.watchWallClock(interval, sc.stop) })
+	}
+	if sc.sampleInterval > 0 {
+		sc.routines.goTracked(func() { sc.runSampler(sc.sampleInterval, sc.stop) })
+	}
+
+	now := time.Now()
+	for name, state := range sc.states {
+		sc.startTracking(name, state, now)
+	}
+
+	if sc.bootGrace > 0 {
+		sc.inGrace = 1
+		sc.graceTimer = sc.afterFunc(sc.bootGrace, sc.EndBootGrace)
+	}
+
+	for _, hook := range sc.onStart {
+		hook(&sc)
+	}
 
 	return &sc
 }
 
 // AddState adds a new state to the StateController.
-// Returns an error if the state already exists.
+// Returns an error if the state already exists or its configuration is invalid.
 func (sc *StateController) AddState(name string, state State) error {
-	sc.mu.Lock()
-	defer sc.mu.Unlock()
+	defer sc.unlockController(sc.lockController())
 
 	_, exists := sc.states[name]
 	if exists {
-		return fmt.Errorf(stateErrorFormat, name, ErrStateExists)
+		return sc.stateError(name, ErrStateExists)
+	}
+	if err := validateState(state); err != nil {
+		return sc.stateError(name, err)
 	}
 
-	sc.states[name] = &delayedState{State: state}
+	next := sc.cloneIndex()
+	next[name] = sc.newDelayedState(state)
+	sc.startTracking(name, next[name], time.Now())
+	sc.publish(next)
 
+	if sc.shadow != nil {
+		sc.shadow.AddState(name, state)
+	}
 	return nil
 }
 
 // UpdateState updates the configuration of an existing state.
 // Any pending timer is cancelled. If the IsActive value changes, onStateChange is fired.
-// Returns an error if the state does not exist.
+// Returns an error if the state does not exist or the configuration is invalid.
 func (sc *StateController) UpdateState(name string, state State) error {
-	sc.mu.Lock()
-
-	existing, exists := sc.states[name]
-	if !exists {
-		sc.mu.Unlock()
-		return fmt.Errorf(stateErrorFormat, name, ErrStateNotFound)
+	if err := validateState(state); err != nil {
+		return sc.stateError(name, err)
 	}
 
-	if existing.delayedTimer != nil {
-		existing.delayedTimer.Stop()
-		existing.delayedTimer = nil
+	existing := sc.lockState(name)
+	if existing == nil {
+		return sc.stateError(name, ErrStateNotFound)
 	}
 
+	existing.stopTimer()
+
 	wasActive := existing.IsActive
 	existing.State = state
-	changed := wasActive != state.IsActive
-	cb := sc.onStateChange
-	sc.mu.Unlock()
-
-	if changed && cb != nil {
-		cb(name, state.IsActive)
+	existing.requested = state.IsActive
+	existing.version++
+	if wasActive != state.IsActive {
+		sc.emit(existing, context.Background(), name, state.IsActive)
 	}
+	sc.checkInvariants(name, existing)
+	existing.mu.Unlock()
+	sc.flush(existing)
 
+	if sc.shadow != nil {
+		sc.shadow.UpdateState(name, state)
+	}
 	return nil
 }
 
-// RemoveState removes a state from the StateController.
+// RemoveState removes a state from the StateController and reports whether it existed.
+// Any pending delayed transition is dropped without being applied.
 // If the state was active, onStateChange is fired with active=false.
-func (sc *StateController) RemoveState(name string) {
-	sc.mu.Lock()
+func (sc *StateController) RemoveState(name string) bool {
+	return sc.removeState(name, false)
+}
+
+// RemoveStateFlush removes a state from the StateController like RemoveState, but first applies
+// any pending delayed transition and fires onStateChange for it, so no transition is lost.
+// Reports whether the state existed.
+func (sc *StateController) RemoveStateFlush(name string) bool {
+	return sc.removeState(name, true)
+}
+
+func (sc *StateController) removeState(name string, flush bool) bool {
+	locked := sc.lockController()
 
 	state, exists := sc.states[name]
 	if !exists {
-		sc.mu.Unlock()
-		return
+		sc.unlockController(locked)
+		return false
 	}
 
-	if state.delayedTimer != nil {
-		state.delayedTimer.Stop()
-		state.delayedTimer = nil
+	state.mu.Lock()
+	next := sc.cloneIndex()
+	delete(next, name)
+	sc.publish(next)
+	sc.unlockController(locked)
+
+	if state.pending() {
+		ev := StateEvent{Name: name, Active: state.pendingTarget, Cause: CauseTimer, ScheduledAt: state.scheduledAt, Deadline: state.deadline}
+		timerCtx := state.pendingCtx
+		state.stopTimer()
+		if flush && state.IsActive != ev.Active {
+			state.IsActive = ev.Active
+			sc.record(state, timerCtx, ev)
+		}
 	}
 
-	wasActive := state.IsActive
-	delete(sc.states, name)
-	cb := sc.onStateChange
-	sc.mu.Unlock()
+	state.teardown()
+	if state.IsActive {
+		sc.emit(state, context.Background(), name, false)
+	}
+	sc.checkInvariants(name, state)
+	state.mu.Unlock()
+	sc.flush(state)
 
-	if wasActive && cb != nil {
-		cb(name, false)
+	if sc.shadow != nil {
+		sc.shadow.removeState(name, flush)
 	}
+	return true
 }
 
 // SetState sets the state for a given state name.
-// SetState will create the state if it does not exist and the onStateNotExist callback is provided.
-// Returns an error if the state does not exist and the onStateNotExist callback is not provided.
-func (sc *StateController) SetState(name string, active bool) error {
-	sc.mu.RLock()
-	_, exists := sc.states[name]
-	notExistCb := sc.onStateNotExist
-	sc.mu.RUnlock()
+// SetState will create the state if it does not exist and a StateFactory is available, either
+// per call via WithStateFactory or controller-wide via the onStateNotExist callback.
+// Returns an error if the state does not exist and no StateFactory is available.
+func (sc *StateController) SetState(name string, active bool, opts ...SetOption) error {
+	return sc.SetStateCtx(context.Background(), name, active, opts...)
+}
 
-	if !exists {
-		if notExistCb == nil {
-			return fmt.Errorf(stateErrorFormat, name, ErrStateNotFound)
+// SetStateCtx is like SetState, but carries ctx into the StateFactory and the onStateChange
+// callback. If ctx is done before the transition is applied, ctx.Err() is returned and the
+// state is left untouched.
+func (sc *StateController) SetStateCtx(ctx context.Context, name string, active bool, opts ...SetOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if sc.Draining() {
+		return sc.stateError(name, ErrDraining)
+	}
+
+	if err := sc.Authorize(ctx, OpSet, name); err != nil {
+		return err
+	}
+
+	o := newSetOptions(opts...)
+	if o.idempotencyKey == "" {
+		return sc.setState(ctx, name, active, o)
+	}
+	first, err := sc.idempotency.claim(ctx, o.idempotencyKey)
+	if !first {
+		return err
+	}
+	err = sc.setState(ctx, name, active, o)
+	sc.idempotency.complete(o.idempotencyKey, sc.idempotencyWindow, err)
+	return err
+}
+
+// setState applies a SetStateCtx request with the options o.
+func (sc *StateController) setState(ctx context.Context, name string, active bool, o setOptions) error {
+	ctx = o.context(ctx)
+
+	factory := sc.onStateNotExist
+	if o.factory != nil {
+		factory = o.factory
+	}
+
+	if !sc.HasState(name) {
+		if factory == nil {
+			return sc.stateError(name, ErrStateNotFound)
 		}
 
-		// Call the callback outside of any lock to prevent deadlocks.
-		createdState, err := notExistCb(name)
-		if err != nil {
+		if err := sc.createState(ctx, name, factory); err != nil {
 			return err
 		}
 
-		sc.mu.Lock()
-		// Re-check: another goroutine may have added it concurrently.
-		if _, exists = sc.states[name]; !exists {
-			sc.states[name] = &delayedState{State: createdState}
+		// The factory may have blocked; don't apply a transition the caller gave up on.
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		sc.mu.Unlock()
 	}
 
-	sc.mu.Lock()
+	if sc.shadow != nil {
+		return sc.shadow.setState(ctx, name, active, o)
+	}
 
-	state, exists := sc.states[name]
-	if !exists {
-		sc.mu.Unlock()
-		return fmt.Errorf(stateErrorFormat, name, ErrStateNotFound)
+	state := sc.lockState(name)
+	if state == nil {
+		return sc.stateError(name, ErrStateNotFound)
 	}
+	err := sc.request(ctx, name, state, active)
+	state.mu.Unlock()
+	sc.flush(state)
 
-	var changed bool
-	if !state.DelayOnActivation {
-		changed = sc.handleState(name, state, active)
-	} else {
-		changed = sc.handleDelayedActivation(name, state, active)
+	if err != nil {
+		return sc.stateError(name, err)
 	}
+	return nil
+}
 
-	cb := sc.onStateChange
-	sc.mu.Unlock()
+// request applies a SetState request for active to state, or, with WithInputSampling, keeps it
+// for the next sample. Returns ErrStateDisabled if the state is disabled, ErrLeaseHeld if it is
+// leased to another requester, or the error of applying the request, which leaves the state
+// untouched. Must be called with the state's mutex held.
+func (sc *StateController) request(ctx context.Context, name string, state *delayedState, active bool) error {
+	if state.disabled {
+		return ErrStateDisabled
+	}
+	if holder := state.leaseHolder(sc.now()); holder != "" && holder != RequestedBy(ctx) {
+		return fmt.Errorf("%w %q", ErrLeaseHeld, holder)
+	}
+	if sc.sampleInterval > 0 {
+		state.sampled = &heldRequest{ctx: detachContext(ctx), active: active}
+		return nil
+	}
+	return sc.accept(ctx, name, state, active)
+}
 
-	if changed && cb != nil {
-		cb(name, active)
+// accept applies a request that passed the checks of request, unless it is held back; see hold.
+// Returns the error of applying the request, which leaves the state untouched. Must be called
+// with the state's mutex held.
+func (sc *StateController) accept(ctx context.Context, name string, state *delayedState, active bool) error {
+	if sc.hold(ctx, name, state, active) {
+		return nil
+	}
+	if sc.suppressNoops && state.requested == active && !state.Unknown {
+		return nil
 	}
 
-	return nil
+	previous := state.requested
+	state.requested = active
+	var err error
+	if state.Unknown {
+		err = sc.applyFromUnknown(ctx, name, state, active)
+	} else {
+		err = sc.applyRequest(ctx, name, state, active)
+	}
+	if err != nil {
+		state.requested = previous
+	} else {
+		state.version++
+		if !state.Unknown && state.IsActive == active {
+			// Also refreshes the quality if the value did not change.
+			state.quality = qualityFrom(ctx)
+		}
+		sc.startStaleTimer(name, state)
+	}
+	sc.checkInvariants(name, state)
+	return err
 }
 
 // Reset cancels any pending timer and immediately deactivates the state.
 // Returns an error if the state does not exist.
 func (sc *StateController) Reset(name string) error {
-	sc.mu.Lock()
-
-	state, exists := sc.states[name]
-	if !exists {
-		sc.mu.Unlock()
-		return fmt.Errorf(stateErrorFormat, name, ErrStateNotFound)
+	if sc.Draining() {
+		return sc.stateError(name, ErrDraining)
 	}
-
-	if state.delayedTimer != nil {
-		state.delayedTimer.Stop()
-		state.delayedTimer = nil
+	if err := sc.Authorize(context.Background(), OpForce, name); err != nil {
+		return err
+	}
+	if sc.shadow != nil {
+		return sc.shadow.Reset(name)
 	}
+	state := sc.lockState(name)
+	if state == nil {
+		return sc.stateError(name, ErrStateNotFound)
+	}
+	if state.disabled {
+		state.mu.Unlock()
+		return sc.stateError(name, ErrStateDisabled)
+	}
+
+	state.stopTimer()
+	state.requested = false
+	state.version++
+	sc.setValue(context.Background(), name, state, false, CauseExplicit)
+	sc.startStaleTimer(name, state)
+	sc.checkInvariants(name, state)
+	state.mu.Unlock()
+	sc.flush(state)
 
-	changed := state.IsActive
-	state.IsActive = false
-	cb := sc.onStateChange
-	sc.mu.Unlock()
+	return nil
+}
 
-	if changed && cb != nil {
-		cb(name, false)
+// GetOrCreate returns a handle to the named state, creating it first if it does not exist.
+// The factory is called outside of the controller lock and only when the state is missing;
+// a nil factory creates a zero-value State. onStateChange is not fired for the created state.
+// If the State returned by factory is invalid, the state is not created and GetOrCreate returns
+// a nil handle and an error wrapping ErrInvalidState:
+//
+//	h, err := sc.GetOrCreate("door", func() State { return State{Delay: time.Minute} })
+//	if err != nil {
+//		return err
+//	}
+//	h.SetState(true)
+func (sc *StateController) GetOrCreate(name string, factory func() State) (*StateHandle, error) {
+	if !sc.HasState(name) {
+		err := sc.createState(context.Background(), name, func(context.Context, string) (State, error) {
+			var state State
+			if factory != nil {
+				state = factory()
+			}
+			return state, nil
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return nil
+	return &StateHandle{sc: sc, name: name}, nil
+}
+
+// InitError returns the error of the first initial state of WithInitializeStates that was left
+// out as invalid, by name, or nil if all of them were added.
+func (sc *StateController) InitError() error {
+	if sc.invalidStateErr == nil {
+		return nil
+	}
+	return sc.stateError(sc.invalidState, sc.invalidStateErr)
 }
 
 // HasState reports whether a state with the given name exists.
 func (sc *StateController) HasState(name string) bool {
-	sc.mu.RLock()
-	defer sc.mu.RUnlock()
-
-	_, exists := sc.states[name]
+	_, exists := sc.loadIndex()[name]
 	return exists
 }
 
 // StateNames returns a slice of all registered state names.
 func (sc *StateController) StateNames() []string {
-	sc.mu.RLock()
-	defer sc.mu.RUnlock()
+	states := sc.loadIndex()
 
-	names := make([]string, 0, len(sc.states))
-	for name := range sc.states {
+	names := make([]string, 0, len(states))
+	for name := range states {
 		names = append(names, name)
 	}
 	return names
@@ -236,88 +700,433 @@ func (sc *StateController) StateNames() []string {
 
 // Len returns the number of registered states.
 func (sc *StateController) Len() int {
-	sc.mu.RLock()
-	defer sc.mu.RUnlock()
-
-	return len(sc.states)
+	return len(sc.loadIndex())
 }
 
 // IsActive returns the current active status for a given state name.
 func (sc *StateController) IsActive(stateName string) bool {
-	sc.mu.RLock()
-	defer sc.mu.RUnlock()
-
-	state, exists := sc.states[stateName]
-	if !exists {
+	state := sc.lockState(stateName)
+	if state == nil {
 		return false
 	}
+	defer state.mu.Unlock()
+
 	return state.IsActive
 }
 
+// IsUnknown reports whether the value of the named state is unknown, i.e. it was added with
+// State.Unknown and not set since. IsActive then reports the initial value, which should not be
+// mistaken for a measured one. Returns false for a missing state.
+func (sc *StateController) IsUnknown(stateName string) bool {
+	state := sc.lockState(stateName)
+	if state == nil {
+		return false
+	}
+	defer state.mu.Unlock()
+
+	return state.Unknown
+}
+
+// IsPending reports whether a delayed transition of the named state is pending and, if so, the
+// value it moves towards. Both are false for a missing state.
+func (sc *StateController) IsPending(stateName string) (pending bool, towards bool) {
+	state := sc.lockState(stateName)
+	if state == nil {
+		return false, false
+	}
+	defer state.mu.Unlock()
+
+	if !state.pending() {
+		return false, false
+	}
+	return true, state.pendingTarget
+}
+
+// IsRequested returns the value last requested for a given state name by SetState, ForceState,
+// Reset or UpdateState, or its initial IsActive value. It differs from IsActive while a delayed
+// transition is pending, e.g. IsActive true and IsRequested false for a deactivation in progress.
+func (sc *StateController) IsRequested(stateName string) bool {
+	state := sc.lockState(stateName)
+	if state == nil {
+		return false
+	}
+	defer state.mu.Unlock()
+
+	return state.requested
+}
+
 // GetState returns the current state configuration for a given state name.
 func (sc *StateController) GetState(stateName string) (State, error) {
-	sc.mu.RLock()
-	defer sc.mu.RUnlock()
-
-	state, exists := sc.states[stateName]
-	if !exists {
-		return State{}, fmt.Errorf(stateErrorFormat, stateName, ErrStateNotFound)
+	state := sc.lockState(stateName)
+	if state == nil {
+		return State{}, sc.stateError(stateName, ErrStateNotFound)
 	}
+	defer state.mu.Unlock()
+
 	return state.State, nil
 }
 
+// ForceState sets the named state to the given value immediately, regardless of its configured
+// delays, and cancels any pending transition, e.g. for emergency-stop paths. If the value
+// changes, onStateChange is fired with CauseForced.
+func (sc *StateController) ForceState(name string, active bool) error {
+	if sc.Draining() {
+		return sc.stateError(name, ErrDraining)
+	}
+	if err := sc.Authorize(context.Background(), OpForce, name); err != nil {
+		return err
+	}
+	if sc.shadow != nil {
+		return sc.shadow.ForceState(name, active)
+	}
+	state := sc.lockState(name)
+	if state == nil {
+		return sc.stateError(name, ErrStateNotFound)
+	}
+	if state.disabled {
+		state.mu.Unlock()
+		return sc.stateError(name, ErrStateDisabled)
+	}
+
+	state.stopTimer()
+	state.requested = active
+	state.version++
+	sc.setValue(context.Background(), name, state, active, CauseForced)
+	sc.startStaleTimer(name, state)
+	sc.checkInvariants(name, state)
+	state.mu.Unlock()
+	sc.flush(state)
+
+	return nil
+}
+
+// DisableState takes the named state out of service, e.g. a faulty sensor, without losing its
+// configuration: any pending transition is cancelled and the value is frozen until EnableState.
+// Meanwhile SetState, ForceState and Reset fail with ErrStateDisabled; configuration changes
+// and queries keep working.
+func (sc *StateController) DisableState(name string) error {
+	if err := sc.Authorize(context.Background(), OpForce, name); err != nil {
+		return err
+	}
+	state := sc.lockState(name)
+	if state == nil {
+		return sc.stateError(name, ErrStateNotFound)
+	}
+	state.stopTimer()
+	state.stopStaleTimer()
+	state.requested = state.IsActive
+	state.disabled = true
+	sc.checkInvariants(name, state)
+	state.mu.Unlock()
+
+	if sc.shadow != nil {
+		_ = sc.shadow.DisableState(name)
+	}
+	return nil
+}
+
+// EnableState puts a state disabled with DisableState back into service, keeping its value.
+func (sc *StateController) EnableState(name string) error {
+	if err := sc.Authorize(context.Background(), OpForce, name); err != nil {
+		return err
+	}
+	state := sc.lockState(name)
+	if state == nil {
+		return sc.stateError(name, ErrStateNotFound)
+	}
+	state.disabled = false
+	sc.checkInvariants(name, state)
+	state.mu.Unlock()
+
+	if sc.shadow != nil {
+		_ = sc.shadow.EnableState(name)
+	}
+	return nil
+}
+
+// SetDelaysEnabled turns the configured delays of the named state on or off at runtime, e.g. for
+// a commissioning mode, without changing its State. While delays are disabled, every SetState
+// takes effect immediately; a transition pending at the time delays are disabled is applied
+// right away.
+func (sc *StateController) SetDelaysEnabled(name string, enabled bool) error {
+	if err := sc.Authorize(context.Background(), OpForce, name); err != nil {
+		return err
+	}
+	state := sc.lockState(name)
+	if state == nil {
+		return sc.stateError(name, ErrStateNotFound)
+	}
+
+	state.delaysDisabled = !enabled
+	if !enabled && state.pending() {
+		sc.completePending(name, state)
+	}
+	sc.checkInvariants(name, state)
+	state.mu.Unlock()
+	sc.flush(state)
+
+	if sc.shadow != nil {
+		_ = sc.shadow.SetDelaysEnabled(name, enabled)
+	}
+	return nil
+}
+
+// SetBypassAll makes every transition of every state immediate while bypass is true, e.g. for
+// disaster recovery or debugging. Transitions pending when the bypass is turned on are applied
+// right away. The callback set with WithOnBypassChange is fired when the mode changes.
+func (sc *StateController) SetBypassAll(bypass bool) {
+	var value int32
+	if bypass {
+		value = 1
+	}
+	if atomic.SwapInt32(&sc.bypassAll, value) == value {
+		return
+	}
+	if sc.shadow != nil {
+		sc.shadow.SetBypassAll(bypass)
+	}
+
+	if bypass {
+		for name, state := range sc.loadIndex() {
+			state.mu.Lock()
+			if !state.removed && state.pending() {
+				sc.completePending(name, state)
+			}
+			sc.checkInvariants(name, state)
+			state.mu.Unlock()
+			sc.flush(state)
+		}
+	}
+
+	if sc.onBypassChange != nil {
+		sc.onBypassChange(bypass)
+	}
+}
+
+// BypassAll reports whether SetBypassAll is in effect.
+func (sc *StateController) BypassAll() bool {
+	return atomic.LoadInt32(&sc.bypassAll) == 1
+}
+
+// ExtendPending pushes out the deadline of the named state's pending delayed transition by extra,
+// e.g. to grant a grace period before a delayed shutdown. The transition keeps its target and the
+// context it was requested with. Returns ErrNotPending if no transition is pending, and
+// ErrInvalidDelay if extra is not positive. In dry-run mode, the would-be transition is extended.
+func (sc *StateController) ExtendPending(name string, extra time.Duration) error {
+	if extra <= 0 {
+		return sc.stateError(name, fmt.Errorf("%w: extension %v is not positive", ErrInvalidDelay, extra))
+	}
+	if err := sc.Authorize(context.Background(), OpForce, name); err != nil {
+		return err
+	}
+	if sc.shadow != nil {
+		return sc.shadow.ExtendPending(name, extra)
+	}
+	state := sc.lockState(name)
+	if state == nil {
+		return sc.stateError(name, ErrStateNotFound)
+	}
+	defer state.mu.Unlock()
+
+	if !state.pending() {
+		return sc.stateError(name, ErrNotPending)
+	}
+	sc.extendTimer(name, state, extra)
+	sc.checkInvariants(name, state)
+	return nil
+}
+
 // Clear removes all states, cancelling any pending timers.
 // onStateChange is fired for every state that was active at the time of removal.
 func (sc *StateController) Clear() {
-	sc.mu.Lock()
+	sc.RemoveWhere(func(string, State) bool { return true })
+}
 
-	var activeNames []string
+// RemoveWhere removes every state for which pred returns true, cancelling any pending timers,
+// and returns the number of removed states. The predicate is evaluated under the controller
+// lock and must not call back into the controller.
+// onStateChange is fired for every removed state that was active at the time of removal.
+func (sc *StateController) RemoveWhere(pred func(name string, state State) bool) int {
+	locked := sc.lockController()
+
+	var removedStates []*delayedState
+	var removedNames []string
+	removed := 0
+	next := sc.cloneIndex()
 	for name, state := range sc.states {
-		if state.delayedTimer != nil {
-			state.delayedTimer.Stop()
-			state.delayedTimer = nil
+		state.mu.Lock()
+		if !pred(name, state.State) {
+			state.mu.Unlock()
+			continue
 		}
+		state.teardown()
 		if state.IsActive {
-			activeNames = append(activeNames, name)
+			sc.emit(state, context.Background(), name, false)
 		}
+		sc.checkInvariants(name, state)
+		state.mu.Unlock()
+		removedStates = append(removedStates, state)
+		removedNames = append(removedNames, name)
+		delete(next, name)
+		removed++
+	}
+	if removed > 0 {
+		sc.publish(next)
 	}
-	sc.states = make(map[string]*delayedState)
-	cb := sc.onStateChange
-	sc.mu.Unlock()
+	sc.unlockController(locked)
 
-	if cb != nil {
-		for _, name := range activeNames {
-			cb(name, false)
+	for _, state := range removedStates {
+		sc.flush(state)
+	}
+
+	if sc.shadow != nil && removed > 0 {
+		removedSet := make(map[string]bool, len(removedNames))
+		for _, name := range removedNames {
+			removedSet[name] = true
 		}
+		sc.shadow.RemoveWhere(func(name string, state State) bool { return removedSet[name] })
 	}
+	return removed
 }
 
 // ActiveStates returns a slice of the names of all currently active states.
 func (sc *StateController) ActiveStates() []string {
-	sc.mu.RLock()
-	defer sc.mu.RUnlock()
+	return sc.namesWhere(func(state *delayedState) bool {
+		return state.IsActive
+	})
+}
 
-	names := make([]string, 0, len(sc.states))
-	for name, state := range sc.states {
-		if state.IsActive {
+// PendingStates returns a slice of the names of all states that have a pending delayed transition.
+func (sc *StateController) PendingStates() []string {
+	return sc.namesWhere(func(state *delayedState) bool {
+		return state.pending()
+	})
+}
+
+// namesWhere returns the names of all states for which pred returns true.
+// pred is called with the state's mutex held.
+func (sc *StateController) namesWhere(pred func(state *delayedState) bool) []string {
+	states := sc.loadIndex()
+
+	names := make([]string, 0, len(states))
+	for name, state := range states {
+		state.mu.Lock()
+		ok := pred(state)
+		state.mu.Unlock()
+		if ok {
 			names = append(names, name)
 		}
 	}
 	return names
 }
 
-// PendingStates returns a slice of the names of all states that have a pending delayed transition.
-func (sc *StateController) PendingStates() []string {
-	sc.mu.RLock()
-	defer sc.mu.RUnlock()
+// lockState returns the named state with its mutex held, or nil if it does not exist.
+// The caller must unlock the returned state's mutex.
+func (sc *StateController) lockState(name string) *delayedState {
+	for {
+		state, exists := sc.loadIndex()[name]
+		if !exists {
+			return nil
+		}
 
-	names := make([]string, 0, len(sc.states))
-	for name, state := range sc.states {
-		if state.delayedTimer != nil {
-			names = append(names, name)
+		state.mu.Lock()
+		if !state.removed {
+			return state
 		}
+		// Removed between lookup and locking; the name may have been re-added since.
+		state.mu.Unlock()
+	}
+}
+
+// createState calls factory and adds the resulting state unless it already exists.
+// The factory is invoked without holding the controller lock; concurrent calls for the
+// same name share a single factory invocation and its error. A call waiting for another returns
+// when its own ctx is done, and invokes the factory itself if the other call's context was done.
+func (sc *StateController) createState(ctx context.Context, name string, factory StateFactory) error {
+	sc.creatingMu.Lock()
+	for {
+		c, ok := sc.creating[name]
+		if !ok {
+			break
+		}
+		sc.creatingMu.Unlock()
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if !c.canceled {
+			return c.err
+		}
+		sc.creatingMu.Lock()
+	}
+	c := &creation{done: make(chan struct{})}
+	sc.creating[name] = c
+	sc.creatingMu.Unlock()
+
+	defer func() {
+		sc.creatingMu.Lock()
+		delete(sc.creating, name)
+		sc.creatingMu.Unlock()
+		close(c.done)
+	}()
+
+	// Re-check: the state may have been added since the caller looked it up.
+	if sc.HasState(name) {
+		return nil
+	}
+
+	createdState, err := factory(ctx, name)
+	if err == nil {
+		if err = validateState(createdState); err != nil {
+			err = sc.stateError(name, err)
+		}
+	}
+	if err != nil {
+		c.err = err
+		c.canceled = ctx.Err() != nil
+		return err
+	}
+
+	locked := sc.lockController()
+	// Re-check: another goroutine may have added it via AddState concurrently.
+	if _, exists := sc.states[name]; !exists {
+		next := sc.cloneIndex()
+		next[name] = sc.newDelayedState(createdState)
+		sc.startTracking(name, next[name], time.Now())
+		sc.publish(next)
+		if sc.shadow != nil {
+			sc.shadow.AddState(name, createdState)
+		}
+	}
+	sc.unlockController(locked)
+
+	return nil
+}
+
+// loadIndex returns the current states index. The returned map must not be modified.
+func (sc *StateController) loadIndex() map[string]*delayedState {
+	states, _ := sc.index.Load().(map[string]*delayedState)
+	return states
+}
+
+// cloneIndex returns a modifiable copy of the current states index. Must be called with mu held.
+func (sc *StateController) cloneIndex() map[string]*delayedState {
+	next := make(map[string]*delayedState, len(sc.states)+1)
+	for name, state := range sc.states {
+		next[name] = state
+	}
+	return next
+}
+
+// publish makes states the current index. Must be called with mu held, or during construction.
+func (sc *StateController) publish(states map[string]*delayedState) {
+	sc.states = states
+	sc.index.Store(states)
+	atomic.AddUint64(&sc.indexGen, 1)
+	if sc.denseNames != nil {
+		sc.publishDense(states)
 	}
-	return names
 }
 
 func (sc *StateController) addOptions(opts ...Option) {
@@ -326,75 +1135,219 @@ func (sc *StateController) addOptions(opts ...Option) {
 	}
 }
 
+// applyRequest moves state towards active according to its configuration and records the
+// change, if any. Returns ErrTooManyPending if the request needs a delayed transition beyond the
+// WithMaxPending cap; the state is left untouched then. Must be called with the state's mutex held.
+func (sc *StateController) applyRequest(ctx context.Context, name string, state *delayedState, active bool) error {
+	var changed bool
+	var err error
+	if state.delaysDisabled || atomic.LoadInt32(&sc.bypassAll) == 1 {
+		state.stopTimer()
+		changed = state.IsActive != active
+		state.IsActive = active
+	} else if !state.DelayOnActivation {
+		changed, err = sc.handleState(ctx, name, state, active)
+	} else {
+		changed, err = sc.handleDelayedActivation(ctx, name, state, active)
+	}
+	if changed {
+		sc.emit(state, ctx, name, active)
+	}
+	return err
+}
+
 // handleState handles delayed deactivation (default mode).
 // Note: If a delayed transition is already pending, repeated calls with the same
-// value are ignored (non-retriggerable). The timer is not restarted.
-func (sc *StateController) handleState(name string, state *delayedState, active bool) bool {
+// value are handled according to the state's PendingPolicy.
+// The values of ctx are carried into the onStateChange callback of the delayed transition.
+func (sc *StateController) handleState(ctx context.Context, name string, state *delayedState, active bool) (bool, error) {
 	if active {
-		if state.delayedTimer != nil {
-			state.delayedTimer.Stop()
-			state.delayedTimer = nil
+		if state.pending() && sc.keepPendingOnOpposite {
+			// Applied once the pending deactivation has fired.
+			state.deferredCtx = detachContext(ctx)
+			return false, nil
 		}
+		state.stopTimer()
 		if !state.IsActive {
 			state.IsActive = true
-			return true
+			return true, nil
 		}
 	} else {
-		if state.IsActive && state.delayedTimer == nil {
-			state.delayedTimer = time.AfterFunc(state.Delay, func() {
-				sc.mu.Lock()
-				if state.delayedTimer == nil {
-					sc.mu.Unlock()
-					return
-				}
-				if _, exists := sc.states[name]; !exists {
-					sc.mu.Unlock()
-					return
-				}
-				state.IsActive = false
-				state.delayedTimer = nil
-				cb := sc.onStateChange
-				sc.mu.Unlock()
-				if cb != nil {
-					cb(name, false)
-				}
-			})
+		if state.IsActive {
+			if state.pending() {
+				sc.reassert(ctx, name, state)
+			} else if !sc.reservePending() {
+				return false, ErrTooManyPending
+			} else {
+				sc.startTimer(ctx, name, state, false, state.Delay)
+			}
 		}
 	}
-	return false
+	return false, nil
 }
 
-func (sc *StateController) handleDelayedActivation(name string, state *delayedState, active bool) bool {
+func (sc *StateController) handleDelayedActivation(ctx context.Context, name string, state *delayedState, active bool) (bool, error) {
 	if active {
-		if !state.IsActive && state.delayedTimer == nil {
-			state.delayedTimer = time.AfterFunc(state.Delay, func() {
-				sc.mu.Lock()
-				if state.delayedTimer == nil {
-					sc.mu.Unlock()
-					return
-				}
-				if _, exists := sc.states[name]; !exists {
-					sc.mu.Unlock()
-					return
-				}
-				state.IsActive = true
-				state.delayedTimer = nil
-				cb := sc.onStateChange
-				sc.mu.Unlock()
-				if cb != nil {
-					cb(name, true)
-				}
-			})
+		if !state.IsActive {
+			if state.pending() {
+				sc.reassert(ctx, name, state)
+			} else if !sc.reservePending() {
+				return false, ErrTooManyPending
+			} else {
+				sc.startTimer(ctx, name, state, true, state.Delay)
+			}
 		}
 	} else {
-		if state.delayedTimer != nil {
-			state.delayedTimer.Stop()
-			state.delayedTimer = nil
+		if state.pending() && sc.keepPendingOnOpposite {
+			// Applied once the pending activation has fired.
+			state.deferredCtx = detachContext(ctx)
+			return false, nil
 		}
+		state.stopTimer()
 		if state.IsActive {
 			state.IsActive = false
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// reservePending counts a new pending transition, unless that would exceed the WithMaxPending cap.
+func (sc *StateController) reservePending() bool {
+	for {
+		n := atomic.LoadInt32(&sc.pendingCount)
+		if sc.maxPending > 0 && int(n) >= sc.maxPending {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&sc.pendingCount, n, n+1) {
 			return true
 		}
 	}
-	return false
+}
+
+// startTimer schedules the delayed transition of state towards target after delay, replacing the
+// pending one, if any. Must be called with the state's mutex held and, unless a transition is
+// pending already, after reservePending.
+func (sc *StateController) startTimer(ctx context.Context, name string, state *delayedState, target bool, delay time.Duration) {
+	state.cancelTimer()
+	state.timerGen++
+	gen := state.timerGen
+	state.scheduledAt = sc.now()
+	state.deadline = state.scheduledAt.Add(delay)
+	state.wallDeadline = state.deadline.Round(0)
+	state.pendingTarget = target
+	state.pendingCtx = detachContext(ctx)
+	if sc.ticked != nil {
+		sc.tickMu.Lock()
+		sc.ticked[state] = name
+		sc.tickMu.Unlock()
+		return
+	}
+	if sc.batches != nil {
+		sc.joinBatch(name, state)
+		return
+	}
+	sc.armTimer(name, state, gen, delay)
+}
+
+// armTimer starts the timer firing the pending transition of state, identified by gen, after d.
+// Must be called with the state's mutex held.
+func (sc *StateController) armTimer(name string, state *delayedState, gen uint64, d time.Duration) {
+	state.delayedTimer = sc.afterFunc(d, func() {
+		state.mu.Lock()
+		if state.removed || !state.pending() || state.timerGen != gen {
+			state.mu.Unlock()
+			return
+		}
+		if now := time.Now(); !sc.due(state, now) {
+			// The wall clock was set back; wait until it reaches the deadline.
+			sc.armTimer(name, state, gen, sc.untilDue(state, now))
+			state.mu.Unlock()
+			return
+		}
+		sc.fire(name, state)
+		sc.checkInvariants(name, state)
+		state.mu.Unlock()
+		sc.flush(state)
+	})
+}
+
+// afterFunc starts a counted timer calling f after d, delayed further by the jitter of
+// WithChaos, if any.
+func (sc *StateController) afterFunc(d time.Duration, f func()) *trackedTimer {
+	return sc.routines.afterFunc(d+sc.chaos.jitter(), f)
+}
+
+// now returns the current time of the controller's clock.
+func (sc *StateController) now() time.Time {
+	if sc.clock != nil {
+		return sc.clock()
+	}
+	return time.Now()
+}
+
+// fire applies the pending transition at its deadline. Must be called with the state's mutex held
+// and a timer pending.
+func (sc *StateController) fire(name string, state *delayedState) {
+	sc.latency.observe(sc.now().Sub(state.deadline))
+	sc.completePending(name, state)
+}
+
+// completePending applies the pending transition now and then any request deferred while it was
+// pending. Must be called with the state's mutex held and a timer pending.
+func (sc *StateController) completePending(name string, state *delayedState) {
+	ev := StateEvent{Name: name, Active: state.pendingTarget, Cause: CauseTimer, ScheduledAt: state.scheduledAt, Deadline: state.deadline}
+	timerCtx := state.pendingCtx
+	if state.Unknown {
+		ev.Old, ev.WasUnknown = state.IsActive, true
+		state.Unknown = false
+	}
+	state.stopTimer()
+	state.IsActive = ev.Active
+	sc.record(state, timerCtx, ev)
+	if state.requested != ev.Active {
+		// An opposing request arrived while pending and was deferred (WithCancelOnOpposite(false)).
+		// If it needs a delayed transition beyond the WithMaxPending cap, it is dropped.
+		if err := sc.applyRequest(state.deferredCtx, name, state, state.requested); err != nil {
+			state.requested = ev.Active
+		}
+	}
+}
+
+// stopTimer cancels the pending transition, if any. Must be called with the state's mutex held.
+func (s *delayedState) stopTimer() {
+	if s.pending() {
+		atomic.AddInt32(s.pendingCount, -1)
+	}
+	s.cancelTimer()
+	s.deadline = time.Time{}
+	s.wallDeadline = time.Time{}
+	s.pendingCtx = nil
+}
+
+// teardown marks a state removed from the index, cancels its pending transition, stops its
+// staleness watchdog, overrides and escalation rules, and wakes up Await calls waiting on it.
+// Must be called with the state's mutex held.
+func (s *delayedState) teardown() {
+	s.stopTimer()
+	s.stopStaleTimer()
+	s.stopOverrides()
+	s.stopEscalations()
+	s.removed = true
+	s.notify()
+}
+
+// cancelTimer stops the timer, if any, without ending the pending transition. Must be called with
+// the state's mutex held.
+func (s *delayedState) cancelTimer() {
+	if s.delayedTimer != nil {
+		s.delayedTimer.Stop()
+		s.delayedTimer = nil
+	}
+}
+
+// pending reports whether a delayed transition is pending. Must be called with the state's
+// mutex held.
+func (s *delayedState) pending() bool {
+	return !s.deadline.IsZero()
 }
@@ -7,8 +7,12 @@
 package delayedstate
 
 import (
+	"context"
 	"errors"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -832,3 +836,714 @@ func TestPendingStatesReturnsEmptySlice(t *testing.T) {
 		t.Fatalf("Expected 0 pending states, got %d", len(pending))
 	}
 }
+
+func TestOnStateNotExistSingleFlight(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	release := make(chan struct{})
+
+	sc := NewStateController(WithOnStateNotExist(func(name string) (State, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		return State{Delay: time.Second}, nil
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sc.SetState("state1", true); err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("Expected factory to be called once, got %d", calls)
+	}
+	if !sc.IsActive("state1") {
+		t.Fatal("Expected state1 to be active")
+	}
+}
+
+func TestOnStateNotExistSingleFlightWaiterContext(t *testing.T) {
+	release := make(chan struct{})
+	sc := NewStateController(WithOnStateNotExistContext(func(ctx context.Context, name string) (State, error) {
+		select {
+		case <-release:
+			return State{}, nil
+		case <-ctx.Done():
+			return State{}, ctx.Err()
+		}
+	}))
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() { leader <- sc.SetStateCtx(leaderCtx, "state1", true) }()
+	time.Sleep(10 * time.Millisecond)
+
+	// A waiter gives up with its own context.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := sc.SetStateCtx(ctx, "state1", true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the waiter's DeadlineExceeded, got %v", err)
+	}
+
+	// A waiter does not inherit the cancellation of the leader, but invokes the factory itself.
+	waiter := make(chan error, 1)
+	go func() { waiter <- sc.SetState("state1", true) }()
+	time.Sleep(10 * time.Millisecond)
+	cancelLeader()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the leader's Canceled, got %v", err)
+	}
+	close(release)
+	if err := <-waiter; err != nil {
+		t.Fatalf("Expected the waiter to create the state, got %v", err)
+	}
+	if !sc.IsActive("state1") {
+		t.Fatal("Expected state1 to be active")
+	}
+}
+
+func TestOnStateNotExistSlowFactoryDoesNotBlockOtherStates(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	sc := NewStateController(WithOnStateNotExist(func(name string) (State, error) {
+		<-release
+		return State{}, nil
+	}))
+	sc.AddState("state2", State{Delay: time.Second})
+
+	go sc.SetState("state1", true)
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		sc.SetState("state2", true)
+		sc.AddState("state3", State{})
+		sc.RemoveState("state3")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Slow factory blocked operations on other states")
+	}
+}
+
+func TestRemoveWhere(t *testing.T) {
+	var removedActive []string
+	sc := NewStateController(WithOnStateChange(func(name string, active bool) {
+		if !active {
+			removedActive = append(removedActive, name)
+		}
+	}))
+	sc.AddState("door/1", State{Delay: time.Second})
+	sc.AddState("door/2", State{Delay: time.Second})
+	sc.AddState("window/1", State{Delay: time.Second})
+	sc.SetState("door/1", true)
+	sc.SetState("door/2", true)
+	sc.SetState("door/2", false) // pending deactivation
+
+	n := sc.RemoveWhere(func(name string, state State) bool {
+		return strings.HasPrefix(name, "door/")
+	})
+
+	if n != 2 {
+		t.Fatalf("Expected 2 removed states, got %d", n)
+	}
+	if sc.HasState("door/1") || sc.HasState("door/2") {
+		t.Fatal("Expected door states to be removed")
+	}
+	if !sc.HasState("window/1") {
+		t.Fatal("Expected window/1 to remain")
+	}
+	if len(removedActive) != 2 {
+		t.Fatalf("Expected 2 deactivation callbacks, got %v", removedActive)
+	}
+	if len(sc.PendingStates()) != 0 {
+		t.Fatal("Expected pending timers to be cancelled")
+	}
+}
+
+func TestRemoveStateReportsRemoval(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: time.Second})
+
+	if !sc.RemoveState("state1") {
+		t.Fatal("Expected RemoveState to report removal of existing state")
+	}
+	if sc.RemoveState("state1") {
+		t.Fatal("Expected RemoveState to report false for missing state")
+	}
+}
+
+func TestRemoveStateFlushAppliesPendingDeactivation(t *testing.T) {
+	var events []bool
+	sc := NewStateController(WithOnStateChange(func(name string, active bool) {
+		events = append(events, active)
+	}))
+	sc.AddState("state1", State{Delay: time.Second})
+	sc.SetState("state1", true)
+	sc.SetState("state1", false) // pending deactivation
+	events = nil
+
+	if !sc.RemoveStateFlush("state1") {
+		t.Fatal("Expected RemoveStateFlush to report removal")
+	}
+
+	if len(events) != 1 || events[0] {
+		t.Fatalf("Expected a single deactivation callback, got %v", events)
+	}
+	if sc.HasState("state1") {
+		t.Fatal("Expected state1 to be removed")
+	}
+}
+
+func TestRemoveStateFlushAppliesPendingActivation(t *testing.T) {
+	var events []bool
+	sc := NewStateController(WithOnStateChange(func(name string, active bool) {
+		events = append(events, active)
+	}))
+	sc.AddState("state1", State{Delay: time.Second, DelayOnActivation: true})
+	sc.SetState("state1", true) // pending activation
+
+	sc.RemoveStateFlush("state1")
+
+	if len(events) != 2 || !events[0] || events[1] {
+		t.Fatalf("Expected activation then removal callbacks, got %v", events)
+	}
+}
+
+func TestRemoveStateDropsPendingTransition(t *testing.T) {
+	callCount := 0
+	sc := NewStateController(WithOnStateChange(func(name string, active bool) {
+		callCount++
+	}))
+	sc.AddState("state1", State{Delay: time.Second, DelayOnActivation: true})
+	sc.SetState("state1", true) // pending activation
+
+	sc.RemoveState("state1")
+
+	if callCount != 0 {
+		t.Fatalf("Expected pending activation to be dropped silently, got %d calls", callCount)
+	}
+}
+
+type testCtxKey struct{}
+
+func TestSetStateCtxCarriesValuesIntoCallbacks(t *testing.T) {
+	var factoryValue, changeValue interface{}
+	sc := NewStateController(
+		WithOnStateNotExistContext(func(ctx context.Context, name string) (State, error) {
+			factoryValue = ctx.Value(testCtxKey{})
+			return State{Delay: time.Second}, nil
+		}),
+		WithOnStateChangeContext(func(ctx context.Context, name string, active bool) {
+			changeValue = ctx.Value(testCtxKey{})
+		}),
+	)
+
+	ctx := context.WithValue(context.Background(), testCtxKey{}, "trace-1")
+	if err := sc.SetStateCtx(ctx, "state1", true); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if factoryValue != "trace-1" {
+		t.Fatalf("Expected factory to receive context value, got %v", factoryValue)
+	}
+	if changeValue != "trace-1" {
+		t.Fatalf("Expected onStateChange to receive context value, got %v", changeValue)
+	}
+}
+
+func TestSetStateCtxCancelled(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: time.Second})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := sc.SetStateCtx(ctx, "state1", true)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if sc.IsActive("state1") {
+		t.Fatal("Expected state1 to remain inactive")
+	}
+}
+
+func TestSetStateCtxCancelledDuringFactory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sc := NewStateController(WithOnStateNotExistContext(func(ctx context.Context, name string) (State, error) {
+		cancel()
+		return State{}, nil
+	}))
+
+	err := sc.SetStateCtx(ctx, "state1", true)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if sc.IsActive("state1") {
+		t.Fatal("Expected state1 not to be activated after cancellation")
+	}
+}
+
+func TestSetStateCtxValuesReachDelayedCallback(t *testing.T) {
+	done := make(chan context.Context, 1)
+	sc := NewStateController(WithOnStateChangeContext(func(ctx context.Context, name string, active bool) {
+		if active {
+			done <- ctx
+		}
+	}))
+	sc.AddState("state1", State{Delay: 10 * time.Millisecond, DelayOnActivation: true})
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), testCtxKey{}, "trace-2"))
+	sc.SetStateCtx(ctx, "state1", true)
+	cancel() // the caller's cancellation must not affect the delayed transition
+
+	select {
+	case got := <-done:
+		if got.Value(testCtxKey{}) != "trace-2" {
+			t.Fatalf("Expected delayed callback to receive context value, got %v", got.Value(testCtxKey{}))
+		}
+		if got.Err() != nil {
+			t.Fatalf("Expected delayed callback context not to be cancelled, got %v", got.Err())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected delayed activation callback")
+	}
+}
+
+func TestSetStateDoesNotBlockOnLockedState(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: time.Second})
+	sc.AddState("state2", State{Delay: time.Second})
+
+	// Hold the lock of state1 as a long-running operation on it would.
+	locked := sc.lockState("state1")
+	defer locked.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		sc.SetState("state2", true)
+		sc.IsActive("state2")
+		sc.AddState("state3", State{})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Operations on state2 were blocked by state1")
+	}
+}
+
+func TestStaleTimerIsIgnored(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: 20 * time.Millisecond})
+	sc.SetState("state1", true)
+
+	// Restart the deactivation timer many times; only the latest one may apply.
+	for i := 0; i < 50; i++ {
+		sc.SetState("state1", false)
+		sc.SetState("state1", true)
+	}
+	sc.SetState("state1", true)
+
+	time.Sleep(50 * time.Millisecond)
+
+	if !sc.IsActive("state1") {
+		t.Fatal("Expected cancelled timers not to deactivate state1")
+	}
+}
+
+const benchStates = 1024
+
+func newBenchController(b *testing.B) (*StateController, []string) {
+	b.Helper()
+	sc := NewStateController()
+	names := make([]string, benchStates)
+	for i := range names {
+		names[i] = "state" + strconv.Itoa(i)
+		sc.AddState(names[i], State{Delay: time.Hour})
+	}
+	return sc, names
+}
+
+// BenchmarkSetStateParallelDistinct measures contention when goroutines work on different states.
+func BenchmarkSetStateParallelDistinct(b *testing.B) {
+	sc, names := newBenchController(b)
+	var next int64
+	var mu sync.Mutex
+
+	b.RunParallel(func(pb *testing.PB) {
+		mu.Lock()
+		name := names[next%benchStates]
+		next++
+		mu.Unlock()
+
+		active := false
+		for pb.Next() {
+			active = !active
+			sc.SetState(name, active)
+		}
+	})
+}
+
+// BenchmarkSetStateParallelShared measures contention when all goroutines work on one state.
+func BenchmarkSetStateParallelShared(b *testing.B) {
+	sc, names := newBenchController(b)
+
+	b.RunParallel(func(pb *testing.PB) {
+		active := false
+		for pb.Next() {
+			active = !active
+			sc.SetState(names[0], active)
+		}
+	})
+}
+
+// BenchmarkIsActiveParallelWithChurn measures reads while other goroutines add and remove states.
+func BenchmarkIsActiveParallelWithChurn(b *testing.B) {
+	sc, names := newBenchController(b)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			name := "churn" + strconv.Itoa(i%benchStates)
+			sc.AddState(name, State{})
+			sc.RemoveState(name)
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			sc.IsActive(names[i%benchStates])
+			i++
+		}
+	})
+}
+
+func TestReadsDoNotBlockOnIndexWriters(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{IsActive: true})
+
+	// Hold the index lock as a concurrent AddState/RemoveState would.
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		sc.HasState("state1")
+		sc.IsActive("state1")
+		sc.GetState("state1")
+		sc.StateNames()
+		sc.ActiveStates()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Reads were blocked by the index lock")
+	}
+}
+
+func TestNoRestartByDefault(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: 40 * time.Millisecond})
+	sc.SetState("state1", true)
+	sc.SetState("state1", false)
+
+	time.Sleep(20 * time.Millisecond)
+	sc.SetState("state1", false)
+	time.Sleep(30 * time.Millisecond)
+
+	if sc.IsActive("state1") {
+		t.Fatal("Expected the first deactivation timer to win")
+	}
+}
+
+func TestExtendPending(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: 30 * time.Millisecond})
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false)
+	time.Sleep(10 * time.Millisecond)
+	if err := sc.ExtendPending("state1", 40*time.Millisecond); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	time.Sleep(40 * time.Millisecond) // Past the original deadline.
+	if !sc.IsActive("state1") {
+		t.Fatal("Expected deactivation to be postponed")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if sc.IsActive("state1") {
+		t.Fatal("Expected deactivation after the extended deadline")
+	}
+}
+
+func TestExtendPendingErrors(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: time.Second})
+
+	if err := sc.ExtendPending("state1", time.Second); !errors.Is(err, ErrNotPending) {
+		t.Fatalf("Expected ErrNotPending, got %v", err)
+	}
+	if err := sc.ExtendPending("missing", time.Second); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound, got %v", err)
+	}
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false)
+	for _, extra := range []time.Duration{0, -time.Minute} {
+		if err := sc.ExtendPending("state1", extra); !errors.Is(err, ErrInvalidDelay) {
+			t.Fatalf("Expected ErrInvalidDelay for %v, got %v", extra, err)
+		}
+	}
+	if pending, _ := sc.IsPending("state1"); !pending {
+		t.Fatal("Expected a rejected extension to leave the transition pending")
+	}
+}
+
+func TestForceStateBypassesDelay(t *testing.T) {
+	var changes []bool
+	sc := NewStateController(WithOnStateChange(func(name string, active bool) { changes = append(changes, active) }))
+	sc.AddState("state1", State{Delay: 20 * time.Millisecond})
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false) // Pending deactivation.
+	if err := sc.ForceState("state1", false); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if sc.IsActive("state1") {
+		t.Fatal("Expected state to be deactivated immediately")
+	}
+
+	sc.ForceState("state1", true)
+	time.Sleep(40 * time.Millisecond)
+	if !sc.IsActive("state1") {
+		t.Fatal("Expected pending deactivation to be cancelled")
+	}
+	if len(changes) != 3 {
+		t.Fatalf("Expected 3 changes, got %v", changes)
+	}
+
+	if err := sc.ForceState("missing", true); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound, got %v", err)
+	}
+}
+
+func TestSetDelaysEnabled(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: time.Second})
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false) // Pending deactivation.
+	if err := sc.SetDelaysEnabled("state1", false); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if sc.IsActive("state1") {
+		t.Fatal("Expected pending deactivation to be applied when delays are disabled")
+	}
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false)
+	if sc.IsActive("state1") || len(sc.PendingStates()) != 0 {
+		t.Fatal("Expected immediate deactivation while delays are disabled")
+	}
+
+	sc.SetDelaysEnabled("state1", true)
+	sc.SetState("state1", true)
+	sc.SetState("state1", false)
+	if !sc.IsActive("state1") {
+		t.Fatal("Expected delayed deactivation after delays are enabled again")
+	}
+
+	if err := sc.SetDelaysEnabled("missing", false); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound, got %v", err)
+	}
+}
+
+func TestSetBypassAll(t *testing.T) {
+	var modes []bool
+	sc := NewStateController(WithOnBypassChange(func(bypass bool) { modes = append(modes, bypass) }))
+	sc.AddState("state1", State{Delay: time.Second})
+	sc.AddState("state2", State{Delay: time.Second, DelayOnActivation: true})
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false) // Pending deactivation.
+	sc.SetBypassAll(true)
+	sc.SetBypassAll(true) // No change.
+	if !sc.BypassAll() {
+		t.Fatal("Expected bypass to be in effect")
+	}
+	if sc.IsActive("state1") {
+		t.Fatal("Expected pending deactivation to be applied when bypass is turned on")
+	}
+
+	sc.SetState("state2", true)
+	if !sc.IsActive("state2") {
+		t.Fatal("Expected immediate activation during bypass")
+	}
+
+	sc.SetBypassAll(false)
+	sc.SetState("state2", false)
+	sc.SetState("state2", true)
+	if sc.IsActive("state2") {
+		t.Fatal("Expected delayed activation after bypass is turned off")
+	}
+
+	if len(modes) != 2 || !modes[0] || modes[1] {
+		t.Fatalf("Expected bypass callbacks [true false], got %v", modes)
+	}
+}
+
+func TestMaxPending(t *testing.T) {
+	sc := NewStateController(WithMaxPending(1))
+	sc.AddState("state1", State{Delay: 20 * time.Millisecond})
+	sc.AddState("state2", State{Delay: 20 * time.Millisecond, DelayOnActivation: true})
+
+	sc.SetState("state1", true)
+	if err := sc.SetState("state1", false); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := sc.SetState("state1", false); err != nil {
+		t.Fatalf("Expected repeated request to be accepted, got %v", err)
+	}
+	if err := sc.SetState("state2", true); !errors.Is(err, ErrTooManyPending) {
+		t.Fatalf("Expected ErrTooManyPending, got %v", err)
+	}
+	if err := sc.SetState("state2", false); err != nil {
+		t.Fatalf("Expected immediate transition to be accepted, got %v", err)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if err := sc.SetState("state2", true); err != nil {
+		t.Fatalf("Expected slot to be free after firing, got %v", err)
+	}
+	sc.RemoveState("state2")
+	if got := atomic.LoadInt32(&sc.pendingCount); got != 0 {
+		t.Fatalf("Expected no pending transitions, got %d", got)
+	}
+}
+
+func TestIsRequested(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: 20 * time.Millisecond})
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false)
+	if !sc.IsActive("state1") || sc.IsRequested("state1") {
+		t.Fatal("Expected deactivation in progress: active, but not requested")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if sc.IsActive("state1") || sc.IsRequested("state1") {
+		t.Fatal("Expected effective and requested value to agree after the delay")
+	}
+	if sc.IsRequested("missing") {
+		t.Fatal("Expected false for a missing state")
+	}
+}
+
+func TestIsPending(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: time.Second})
+	sc.AddState("state2", State{Delay: time.Second, DelayOnActivation: true})
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false)
+	sc.SetState("state2", true)
+
+	if pending, towards := sc.IsPending("state1"); !pending || towards {
+		t.Fatalf("Expected pending deactivation, got %v, %v", pending, towards)
+	}
+	if pending, towards := sc.IsPending("state2"); !pending || !towards {
+		t.Fatalf("Expected pending activation, got %v, %v", pending, towards)
+	}
+
+	sc.SetState("state1", true)
+	if pending, _ := sc.IsPending("state1"); pending {
+		t.Fatal("Expected no pending transition after cancellation")
+	}
+	if pending, _ := sc.IsPending("missing"); pending {
+		t.Fatal("Expected no pending transition for a missing state")
+	}
+}
+
+func TestDisableState(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: 20 * time.Millisecond})
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false) // Pending deactivation.
+	if err := sc.DisableState("state1"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, err := range []error{sc.SetState("state1", false), sc.ForceState("state1", false), sc.Reset("state1")} {
+		if !errors.Is(err, ErrStateDisabled) {
+			t.Fatalf("Expected ErrStateDisabled, got %v", err)
+		}
+	}
+	time.Sleep(40 * time.Millisecond)
+	if !sc.IsActive("state1") {
+		t.Fatal("Expected value to stay frozen while disabled")
+	}
+	if info, _ := sc.Info("state1"); !info.Disabled {
+		t.Fatal("Expected Info to report the state as disabled")
+	}
+
+	sc.EnableState("state1")
+	if err := sc.Reset("state1"); err != nil {
+		t.Fatalf("Expected no error after EnableState, got %v", err)
+	}
+	if sc.IsActive("state1") {
+		t.Fatal("Expected Reset to apply after EnableState")
+	}
+
+	if err := sc.DisableState("missing"); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound, got %v", err)
+	}
+}
+
+func TestUnknownInitialValue(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("sensor", State{Unknown: true})
+	sc.AddState("known", State{})
+
+	if !sc.IsUnknown("sensor") || sc.IsUnknown("known") || sc.IsUnknown("missing") {
+		t.Fatal("Expected only sensor to be unknown")
+	}
+
+	// Setting the initial value makes it known without a change.
+	sc.SetState("sensor", false)
+	if sc.IsUnknown("sensor") || sc.IsActive("sensor") {
+		t.Fatal("Expected sensor to be known and inactive")
+	}
+	if state, _ := sc.GetState("sensor"); state.Unknown {
+		t.Fatal("Expected GetState to report the value as known")
+	}
+}
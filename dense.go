@@ -0,0 +1,126 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"fmt"
+)
+
+// StateID is a small integer addressing a state registered with Register. Lookups by ID index a
+// slice instead of hashing the name into a map, for predictable latency in real-time code.
+type StateID int32
+
+// denseIndex maps the registered IDs to their names and states; immutable once published.
+type denseIndex struct {
+	names  []string
+	states []*delayedState // Nil for names that currently have no state.
+}
+
+// Register returns the ID of the named state, which must exist, assigning the next free ID on
+// the first call for the name. An ID stays bound to its name: if the state is removed, the ID
+// addresses a missing state until a state of the same name is added again.
+// Returns ErrStateNotFound if the state does not exist.
+func (sc *StateController) Register(name string) (StateID, error) {
+	locked := sc.lockController()
+	defer sc.unlockController(locked)
+
+	if _, exists := sc.states[name]; !exists {
+		return 0, sc.stateError(name, ErrStateNotFound)
+	}
+	if id, ok := sc.denseIDs[name]; ok {
+		return id, nil
+	}
+	if sc.denseIDs == nil {
+		sc.denseIDs = make(map[string]StateID)
+	}
+	id := StateID(len(sc.denseNames))
+	sc.denseIDs[name] = id
+	sc.denseNames = append(sc.denseNames, name)
+	sc.publishDense(sc.states)
+	return id, nil
+}
+
+// IsActiveID returns whether the state with the given ID is currently active, like IsActive.
+// Returns false for an unknown ID or a missing state.
+func (sc *StateController) IsActiveID(id StateID) bool {
+	state := sc.lockStateID(id)
+	if state == nil {
+		return false
+	}
+	defer state.mu.Unlock()
+
+	return state.IsActive
+}
+
+// SetStateID sets the state with the given ID, like SetState. Only calls with WithIdempotencyKey
+// or WithDryRun, and calls for a missing state, which may be created by a StateFactory, look the
+// state up by name. Returns ErrStateNotFound for an unknown ID.
+func (sc *StateController) SetStateID(id StateID, active bool, opts ...SetOption) error {
+	dense := sc.loadDense()
+	if dense == nil || id < 0 || int(id) >= len(dense.names) {
+		return fmt.Errorf("state ID %d: %w", id, ErrStateNotFound)
+	}
+	name := dense.names[id]
+	o := newSetOptions(opts...)
+	if o.idempotencyKey != "" || sc.shadow != nil {
+		return sc.SetState(name, active, opts...)
+	}
+	if sc.Draining() {
+		return sc.stateError(name, ErrDraining)
+	}
+	ctx := context.Background()
+	if err := sc.Authorize(ctx, OpSet, name); err != nil {
+		return err
+	}
+
+	state := sc.lockStateID(id)
+	if state == nil {
+		return sc.setState(ctx, name, active, o)
+	}
+	err := sc.request(o.context(ctx), name, state, active)
+	state.mu.Unlock()
+	sc.flush(state)
+
+	if err != nil {
+		return sc.stateError(name, err)
+	}
+	return nil
+}
+
+// lockStateID returns the state with the given ID with its mutex locked, or nil if there is none.
+func (sc *StateController) lockStateID(id StateID) *delayedState {
+	dense := sc.loadDense()
+	if dense == nil || id < 0 || int(id) >= len(dense.states) {
+		return nil
+	}
+	state := dense.states[id]
+	if state == nil {
+		return nil
+	}
+	state.mu.Lock()
+	if state.removed {
+		// Removed, and possibly re-added under a new index not yet loaded; look it up by name.
+		state.mu.Unlock()
+		return sc.lockState(dense.names[id])
+	}
+	return state
+}
+
+func (sc *StateController) loadDense() *denseIndex {
+	dense, _ := sc.dense.Load().(*denseIndex)
+	return dense
+}
+
+// publishDense binds the registered IDs to the states of index states. Must be called with mu held.
+func (sc *StateController) publishDense(states map[string]*delayedState) {
+	dense := &denseIndex{names: sc.denseNames, states: make([]*delayedState, len(sc.denseNames))}
+	for id, name := range sc.denseNames {
+		dense.states[id] = states[name]
+	}
+	sc.dense.Store(dense)
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	sc := NewStateController(WithInitializeStates(map[string]State{"a": {}, "b": {IsActive: true}}))
+
+	a, err := sc.Register("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := sc.Register("b")
+	if again, _ := sc.Register("a"); a != 0 || b != 1 || again != a {
+		t.Fatalf("Expected IDs 0 and 1, stable per name, got %d, %d and %d", a, b, again)
+	}
+	if _, err := sc.Register("missing"); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound, got %v", err)
+	}
+
+	if sc.IsActiveID(a) || !sc.IsActiveID(b) {
+		t.Fatal("Expected IsActiveID to report the values of a and b")
+	}
+	if err := sc.SetStateID(a, true); err != nil || !sc.IsActiveID(a) || !sc.IsActive("a") {
+		t.Fatalf("Expected a to be activated by ID, got %v", err)
+	}
+	if err := sc.SetStateID(7, true); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound for an unknown ID, got %v", err)
+	}
+	if sc.IsActiveID(-1) || sc.IsActiveID(7) {
+		t.Fatal("Expected unknown IDs to be inactive")
+	}
+
+	// The ID stays bound to the name across removal and re-adding.
+	sc.RemoveState("b")
+	if sc.IsActiveID(b) {
+		t.Fatal("Expected a removed state to be inactive")
+	}
+	sc.AddState("b", State{IsActive: true})
+	if !sc.IsActiveID(b) {
+		t.Fatal("Expected the ID to address the re-added state")
+	}
+
+	// A missing state is created by the factory, as with SetState.
+	sc.RemoveState("a")
+	factory := WithStateFactory(func(ctx context.Context, name string) (State, error) { return State{}, nil })
+	if err := sc.SetStateID(a, true, factory); err != nil || !sc.IsActiveID(a) {
+		t.Fatalf("Expected the factory to create a, got %v", err)
+	}
+}
+
+// BenchmarkIsActiveID measures reads by ID, to compare with BenchmarkIsActive.
+func BenchmarkIsActiveID(b *testing.B) {
+	sc, names := newBenchController(b)
+	id, _ := sc.Register(names[0])
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sc.IsActiveID(id)
+	}
+}
+
+// BenchmarkIsActive measures reads by name.
+func BenchmarkIsActive(b *testing.B) {
+	sc, names := newBenchController(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sc.IsActive(names[0])
+	}
+}
+
+// BenchmarkSetStateID measures requests by ID, to compare with BenchmarkSetState.
+func BenchmarkSetStateID(b *testing.B) {
+	sc, names := newBenchController(b)
+	id, _ := sc.Register(names[0])
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sc.SetStateID(id, i%2 == 0)
+	}
+}
+
+// BenchmarkSetState measures requests by name.
+func BenchmarkSetState(b *testing.B) {
+	sc, names := newBenchController(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sc.SetState(names[0], i%2 == 0)
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy decides what happens when the async callback queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes the notifying call wait until the queue has room.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest queued callback to make room for the new one.
+	OverflowDropOldest
+	// OverflowDropNewest discards the new callback.
+	OverflowDropNewest
+)
+
+// String returns the name of the policy.
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropNewest:
+		return "drop-newest"
+	default:
+		return "unknown"
+	}
+}
+
+// callbackEvent is a single recorded change, queued for onStateChange and subscribers.
+type callbackEvent struct {
+	ctx   context.Context
+	event StateEvent
+}
+
+// maxPooledOutbox is the capacity beyond which an outbox is left to the garbage collector rather
+// than pooled, so one burst of changes does not pin memory.
+const maxPooledOutbox = 64
+
+// outboxPool recycles the outboxes of states. Every change of a state fills an outbox that is
+// discarded once delivered, which adds up in controllers churning through many states.
+var outboxPool = sync.Pool{
+	New: func() interface{} {
+		outbox := make([]callbackEvent, 0, 4)
+		return &outbox
+	},
+}
+
+// releaseOutbox clears the delivered events, so their contexts are not kept alive, and returns
+// the outbox to outboxPool.
+func releaseOutbox(events []callbackEvent) {
+	if cap(events) > maxPooledOutbox {
+		return
+	}
+	for i := range events {
+		events[i] = callbackEvent{}
+	}
+	events = events[:0]
+	outboxPool.Put(&events)
+}
+
+// dispatcher delivers onStateChange callbacks from a background goroutine through a
+// bounded queue, so a slow consumer neither stalls callers nor grows memory without bound.
+// With WithCallbackWorkers, each worker is a dispatcher of its own.
+type dispatcher struct {
+	dropped uint64 // Accessed atomically; kept first for 64-bit alignment on 32-bit platforms.
+
+	cb     StateEventCallback
+	policy OverflowPolicy
+	size   int
+
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	queue    []callbackEvent
+	closed   bool
+	done     chan struct{}
+}
+
+func newDispatcher(cb StateEventCallback, size int, policy OverflowPolicy, r *routines) *dispatcher {
+	if size < 1 {
+		size = 1
+	}
+	d := &dispatcher{
+		cb:     cb,
+		policy: policy,
+		size:   size,
+		queue:  make([]callbackEvent, 0, size),
+		done:   make(chan struct{}),
+	}
+	d.notEmpty = sync.NewCond(&d.mu)
+	d.notFull = sync.NewCond(&d.mu)
+
+	r.goTracked(d.run)
+
+	return d
+}
+
+// enqueue queues ev according to the overflow policy. Low-priority callbacks are dropped before
+// others, and critical ones are never dropped or blocked, but queued ahead of all other states'
+// callbacks. Reports false if the dispatcher is closed and ev must be delivered by the caller instead.
+func (d *dispatcher) enqueue(ev callbackEvent) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	priority := ev.event.Priority
+	for !d.closed && len(d.queue) >= d.size && priority != PriorityCritical {
+		switch d.policy {
+		case OverflowDropOldest:
+			i := d.victim()
+			atomic.AddUint64(&d.dropped, 1)
+			if i < 0 {
+				return true
+			}
+			d.queue = append(d.queue[:i], d.queue[i+1:]...)
+		case OverflowDropNewest:
+			i := d.victim()
+			atomic.AddUint64(&d.dropped, 1)
+			if priority == PriorityLow || i < 0 || d.queue[i].event.Priority != PriorityLow {
+				return true
+			}
+			d.queue = append(d.queue[:i], d.queue[i+1:]...)
+		default:
+			d.notFull.Wait()
+		}
+	}
+	if d.closed {
+		return false
+	}
+
+	if priority == PriorityCritical {
+		// Behind earlier critical callbacks and the state's own, to keep the transition order.
+		pos := 0
+		for i, queued := range d.queue {
+			if queued.event.Priority == PriorityCritical || queued.event.Name == ev.event.Name {
+				pos = i + 1
+			}
+		}
+		d.queue = append(d.queue, callbackEvent{})
+		copy(d.queue[pos+1:], d.queue[pos:])
+		d.queue[pos] = ev
+	} else {
+		d.queue = append(d.queue, ev)
+	}
+	d.notEmpty.Signal()
+	return true
+}
+
+// victim returns the index of the queued callback to drop on overflow: the oldest low-priority
+// one, else the oldest normal one, or -1 if all are critical. Must be called with d.mu held.
+func (d *dispatcher) victim() int {
+	normal := -1
+	for i, queued := range d.queue {
+		switch queued.event.Priority {
+		case PriorityLow:
+			return i
+		case PriorityNormal:
+			if normal < 0 {
+				normal = i
+			}
+		}
+	}
+	return normal
+}
+
+func (d *dispatcher) run() {
+	defer close(d.done)
+
+	for {
+		d.mu.Lock()
+		for len(d.queue) == 0 && !d.closed {
+			d.notEmpty.Wait()
+		}
+		if len(d.queue) == 0 {
+			d.mu.Unlock()
+			return
+		}
+		ev := d.queue[0]
+		d.queue = d.queue[1:]
+		d.notFull.Signal()
+		d.mu.Unlock()
+
+		d.cb(ev.ctx, ev.event)
+	}
+}
+
+// close stops accepting callbacks and waits until all queued callbacks are delivered.
+func (d *dispatcher) close() {
+	d.mu.Lock()
+	d.closed = true
+	d.notEmpty.Broadcast()
+	d.notFull.Broadcast()
+	d.mu.Unlock()
+
+	<-d.done
+}
+
+// emit records a change of state for delivery to onStateChange and subscribers by a later flush.
+// Recording under the state's mutex fixes the delivery order to the transition order.
+// Must be called with the state's mutex held.
+func (sc *StateController) emit(state *delayedState, ctx context.Context, name string, active bool) {
+	sc.record(state, ctx, StateEvent{Name: name, Active: active, Cause: CauseExplicit})
+}
+
+// record counts the change in the state's statistics, then completes ev with the fields common
+// to all causes and appends it to the state's outbox.
+// Must be called with the state's mutex held.
+func (sc *StateController) record(state *delayedState, ctx context.Context, ev StateEvent) {
+	now := sc.now()
+	ev.Quality = qualityFrom(ctx)
+	ev.Priority = priorityFrom(ctx)
+	if !ev.Unknown {
+		state.quality = ev.Quality
+	}
+	state.lastChange = now
+	state.changes++
+	state.notify()
+	if len(sc.escalations) > 0 {
+		sc.armEscalations(ev.Name, state, now)
+	}
+	if sc.seriesEnabled {
+		sc.addSample(state, now)
+	}
+
+	if sc.onStateChange == nil && atomic.LoadInt32(&sc.consumerCount) == 0 {
+		return
+	}
+	ev.Controller = sc.name
+	ev.Seq = atomic.AddUint64(&sc.seq, 1)
+	if !ev.Unknown && !ev.WasUnknown {
+		ev.Old = !ev.Active
+	}
+	ev.RequestedBy = RequestedBy(ctx)
+	ev.Time = now
+	ev.Tags = state.Tags
+	if state.outbox == nil {
+		state.outbox = *outboxPool.Get().(*[]callbackEvent)
+	}
+	state.outbox = append(state.outbox, callbackEvent{ctx: ctx, event: ev})
+}
+
+// flush delivers the changes recorded for state, in order. If another goroutine is already
+// flushing the state, that goroutine delivers them instead, so a callback that changes its own
+// state is delivered after the callback returns rather than nested inside it.
+// Must be called without holding any controller or state lock.
+func (sc *StateController) flush(state *delayedState) {
+	state.mu.Lock()
+	if state.flushing {
+		state.mu.Unlock()
+		return
+	}
+	state.flushing = true
+	for len(state.outbox) > 0 {
+		events := state.outbox
+		state.outbox = nil
+		state.mu.Unlock()
+
+		for _, ev := range events {
+			sc.deliver(ev)
+		}
+		releaseOutbox(events)
+
+		state.mu.Lock()
+	}
+	state.flushing = false
+	state.mu.Unlock()
+}
+
+// deliver hands ev to the dispatcher responsible for its state, or calls onStateChange
+// directly if callbacks are synchronous or the dispatchers are closed. With WithChaos, ev may be
+// delayed or dropped first.
+func (sc *StateController) deliver(ev callbackEvent) {
+	delay, drop := sc.chaos.delivery()
+	if drop {
+		return
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	sc.broadcast(ev)
+
+	if sc.onStateChange == nil {
+		return
+	}
+	if len(sc.dispatchers) > 0 && sc.dispatchers[sc.workerFor(ev.event.Name)].enqueue(ev) {
+		return
+	}
+
+	sc.onStateChange(ev.ctx, ev.event)
+}
+
+// workerFor returns the index of the dispatcher handling the named state. All events of one
+// state go to the same dispatcher, which preserves their order.
+func (sc *StateController) workerFor(name string) int {
+	if len(sc.dispatchers) < 2 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32() % uint32(len(sc.dispatchers)))
+}
+
+// DroppedCallbacks returns the number of onStateChange callbacks discarded by the overflow
+// policy of WithAsyncCallbacks.
+func (sc *StateController) DroppedCallbacks() uint64 {
+	var dropped uint64
+	for _, d := range sc.dispatchers {
+		dropped += atomic.LoadUint64(&d.dropped)
+	}
+	return dropped
+}
+
+// Close releases the controller's background resources: pending delayed transitions are
+// cancelled without being applied, the background checks of WithTickInterval and
+// WithWallClockDeadlines are stopped, the async callback workers, if any, are stopped after
+// delivering all queued callbacks, and all subscriptions are closed. Close then waits until
+// every goroutine of the controller has finished, including timer callbacks in flight, so it
+// must not be called from an onStateChange callback. Finally, the WithOnClose hooks run, on the
+// first call only. Callbacks for changes made after Close are delivered synchronously.
+// Close is safe to call more than once.
+func (sc *StateController) Close() {
+	sc.stopBackground()
+	if sc.shadow != nil {
+		sc.shadow.Close()
+	}
+
+	for _, state := range sc.loadIndex() {
+		state.mu.Lock()
+		state.stopTimer()
+		state.stopStaleTimer()
+		state.stopOverrides()
+		state.stopEscalations()
+		state.mu.Unlock()
+	}
+	sc.stopBatches()
+	if sc.graceTimer != nil {
+		sc.graceTimer.Stop()
+	}
+	sc.stopSuppressions()
+
+	for _, d := range sc.dispatchers {
+		d.close()
+	}
+
+	sc.closeSubscriptions()
+	sc.routines.wait()
+
+	sc.closeOnce.Do(func() {
+		for i := len(sc.onClose) - 1; i >= 0; i-- {
+			sc.onClose[i](sc)
+		}
+		close(sc.closed)
+	})
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAsyncCallbacksDeliveredInOrder(t *testing.T) {
+	var mu sync.Mutex
+	var events []bool
+
+	sc := NewStateController(
+		WithOnStateChange(func(name string, active bool) {
+			mu.Lock()
+			events = append(events, active)
+			mu.Unlock()
+		}),
+		WithAsyncCallbacks(16, OverflowBlock),
+	)
+	sc.AddState("state1", State{Delay: time.Second, DelayOnActivation: true})
+
+	for i := 0; i < 5; i++ {
+		sc.UpdateState("state1", State{IsActive: true, Delay: time.Second, DelayOnActivation: true})
+		sc.SetState("state1", false)
+	}
+	sc.Close()
+
+	if len(events) != 10 {
+		t.Fatalf("Expected 10 callbacks, got %d", len(events))
+	}
+	for i, active := range events {
+		if active != (i%2 == 0) {
+			t.Fatalf("Expected alternating callbacks, got %v", events)
+		}
+	}
+}
+
+func TestAsyncCallbacksDoNotBlockCaller(t *testing.T) {
+	release := make(chan struct{})
+	sc := NewStateController(
+		WithOnStateChange(func(name string, active bool) {
+			<-release
+		}),
+		WithAsyncCallbacks(4, OverflowBlock),
+	)
+	sc.AddState("state1", State{Delay: time.Second})
+
+	done := make(chan struct{})
+	go func() {
+		sc.SetState("state1", true)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected SetState not to wait for the async callback")
+	}
+
+	close(release)
+	sc.Close()
+}
+
+func TestAsyncCallbacksOverflowDropNewest(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var names []string
+
+	sc := NewStateController(
+		WithOnStateChange(func(name string, active bool) {
+			<-release
+			mu.Lock()
+			names = append(names, name)
+			mu.Unlock()
+		}),
+		WithAsyncCallbacks(1, OverflowDropNewest),
+	)
+	sc.AddState("a", State{})
+	sc.AddState("b", State{})
+	sc.AddState("c", State{})
+
+	sc.SetState("a", true) // picked up by the worker, which blocks
+	waitForQueueLen(t, sc, 0)
+	sc.SetState("b", true) // queued
+	sc.SetState("c", true) // dropped
+
+	close(release)
+	sc.Close()
+
+	if sc.DroppedCallbacks() != 1 {
+		t.Fatalf("Expected 1 dropped callback, got %d", sc.DroppedCallbacks())
+	}
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Fatalf("Expected callbacks for a and b, got %v", names)
+	}
+}
+
+func TestAsyncCallbacksOverflowDropOldest(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var names []string
+
+	sc := NewStateController(
+		WithOnStateChange(func(name string, active bool) {
+			<-release
+			mu.Lock()
+			names = append(names, name)
+			mu.Unlock()
+		}),
+		WithAsyncCallbacks(1, OverflowDropOldest),
+	)
+	sc.AddState("a", State{})
+	sc.AddState("b", State{})
+	sc.AddState("c", State{})
+
+	sc.SetState("a", true) // picked up by the worker, which blocks
+	waitForQueueLen(t, sc, 0)
+	sc.SetState("b", true) // queued, then dropped
+	sc.SetState("c", true) // queued
+
+	close(release)
+	sc.Close()
+
+	if sc.DroppedCallbacks() != 1 {
+		t.Fatalf("Expected 1 dropped callback, got %d", sc.DroppedCallbacks())
+	}
+	if len(names) != 2 || names[0] != "a" || names[1] != "c" {
+		t.Fatalf("Expected callbacks for a and c, got %v", names)
+	}
+}
+
+func TestCloseCancelsPendingTimers(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: 10 * time.Millisecond, DelayOnActivation: true})
+	sc.SetState("state1", true)
+
+	sc.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	if sc.IsActive("state1") {
+		t.Fatal("Expected pending activation to be cancelled by Close")
+	}
+	if len(sc.PendingStates()) != 0 {
+		t.Fatal("Expected no pending states after Close")
+	}
+}
+
+func TestCallbacksAfterCloseAreSynchronous(t *testing.T) {
+	called := false
+	sc := NewStateController(
+		WithOnStateChange(func(name string, active bool) {
+			called = true
+		}),
+		WithAsyncCallbacks(4, OverflowBlock),
+	)
+	sc.AddState("state1", State{})
+	sc.Close()
+
+	sc.SetState("state1", true)
+
+	if !called {
+		t.Fatal("Expected callback to be delivered synchronously after Close")
+	}
+}
+
+// waitForQueueLen waits until the async callback queue holds n entries.
+func waitForQueueLen(t *testing.T, sc *StateController, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		d := sc.dispatchers[0]
+		d.mu.Lock()
+		l := len(d.queue)
+		d.mu.Unlock()
+		if l == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for queue length %d", n)
+}
+
+func TestCallbackWorkersPreservePerStateOrder(t *testing.T) {
+	var mu sync.Mutex
+	events := make(map[string][]bool)
+
+	sc := NewStateController(
+		WithOnStateChange(func(name string, active bool) {
+			mu.Lock()
+			events[name] = append(events[name], active)
+			mu.Unlock()
+		}),
+		WithAsyncCallbacks(8, OverflowBlock),
+		WithCallbackWorkers(4),
+	)
+
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	var wg sync.WaitGroup
+	for _, name := range names {
+		sc.AddState(name, State{Delay: time.Hour, DelayOnActivation: true})
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				sc.UpdateState(name, State{IsActive: true, Delay: time.Hour, DelayOnActivation: true})
+				sc.SetState(name, false)
+			}
+		}(name)
+	}
+	wg.Wait()
+	sc.Close()
+
+	for _, name := range names {
+		got := events[name]
+		if len(got) != 200 {
+			t.Fatalf("Expected 200 callbacks for %s, got %d", name, len(got))
+		}
+		for i, active := range got {
+			if active != (i%2 == 0) {
+				t.Fatalf("Expected alternating callbacks for %s, got out-of-order value at %d", name, i)
+			}
+		}
+	}
+}
+
+func TestCallbackWorkersDeliverStatesConcurrently(t *testing.T) {
+	release := make(chan struct{})
+	delivered := make(chan string, 2)
+
+	sc := NewStateController(
+		WithOnStateChange(func(name string, active bool) {
+			if name == "blocked" {
+				<-release
+			}
+			delivered <- name
+		}),
+		WithAsyncCallbacks(4, OverflowBlock),
+		WithCallbackWorkers(2),
+	)
+	defer sc.Close()
+	defer close(release)
+
+	// Find a second name that is handled by the other worker.
+	other := ""
+	for i := 0; other == ""; i++ {
+		name := "state" + string(rune('a'+i))
+		if sc.workerFor(name) != sc.workerFor("blocked") {
+			other = name
+		}
+	}
+
+	sc.AddState("blocked", State{})
+	sc.AddState(other, State{})
+	sc.SetState("blocked", true)
+	sc.SetState(other, true)
+
+	select {
+	case name := <-delivered:
+		if name != other {
+			t.Fatalf("Expected %s to be delivered first, got %s", other, name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a blocked callback not to delay other workers")
+	}
+}
+
+func TestCallbackChangingOwnStateIsDeliveredAfterReturn(t *testing.T) {
+	var events []bool
+	var sc *StateController
+	sc = NewStateController(WithOnStateChange(func(name string, active bool) {
+		events = append(events, active)
+		if active {
+			sc.SetState(name, false)
+			if len(events) != 1 {
+				t.Error("Expected nested change not to be delivered inside the callback")
+			}
+		}
+	}))
+	sc.AddState("state1", State{DelayOnActivation: true, Delay: time.Hour})
+	sc.UpdateState("state1", State{IsActive: true, DelayOnActivation: true, Delay: time.Hour})
+
+	if len(events) != 2 || !events[0] || events[1] {
+		t.Fatalf("Expected activation then deactivation, got %v", events)
+	}
+}
+
+func TestReleaseOutboxClearsEvents(t *testing.T) {
+	events := []callbackEvent{{ctx: context.Background(), event: StateEvent{Name: "state1", Tags: []string{"a"}}}}
+	releaseOutbox(events)
+
+	if ev := events[0]; ev.ctx != nil || ev.event.Name != "" || ev.event.Tags != nil {
+		t.Fatalf("Expected a released event to be cleared, got %+v", ev)
+	}
+}
+
+// BenchmarkSetStateWithCallback measures changes delivered to a synchronous callback, whose
+// outboxes are pooled.
+func BenchmarkSetStateWithCallback(b *testing.B) {
+	sc := NewStateController(WithOnStateChange(func(name string, active bool) {}))
+	sc.AddState("state1", State{})
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		sc.SetState("state1", i%2 == 0)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDraining is returned by SetState, ForceState, Reset and SetUnknown once Drain was called.
+var ErrDraining = errors.New("controller draining")
+
+// Drain shuts the controller down gracefully: from the first call on, SetState, ForceState, Reset
+// and SetUnknown fail with ErrDraining, requests not yet sampled by WithInputSampling are applied,
+// and pending delayed transitions keep firing at their deadlines. Drain waits for them until ctx
+// is done; the transitions still pending then are applied at once, like RemoveStateFlush does,
+// and ctx.Err() is returned. Returns nil if all transitions fired on their own. Requests held
+// back by boot grace, Suppress or overrides are not pending transitions and are not applied.
+// Call Close afterwards to release background resources.
+func (sc *StateController) Drain(ctx context.Context) error {
+	atomic.StoreInt32(&sc.draining, 1)
+	if sc.sampleInterval > 0 {
+		sc.sampleInputs()
+	}
+
+	for _, state := range sc.loadIndex() {
+		if err := sc.awaitSettled(ctx, state); err != nil {
+			sc.flushPending()
+			return err
+		}
+	}
+	return nil
+}
+
+// Draining reports whether Drain was called.
+func (sc *StateController) Draining() bool {
+	return atomic.LoadInt32(&sc.draining) == 1
+}
+
+// awaitSettled blocks until state has no pending transition or ctx is done.
+func (sc *StateController) awaitSettled(ctx context.Context, state *delayedState) error {
+	for {
+		state.mu.Lock()
+		if state.removed || !state.pending() {
+			state.mu.Unlock()
+			return nil
+		}
+		if state.changed == nil {
+			state.changed = make(chan struct{})
+		}
+		changed := state.changed
+		// Also re-check at the deadline, in case the transition ended without a change. The
+		// deadline is on the controller's clock, e.g. the fake clock of an Engine.
+		timer := time.NewTimer(state.deadline.Sub(sc.now()))
+		state.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// flushPending applies every pending transition at once.
+func (sc *StateController) flushPending() {
+	for name, state := range sc.loadIndex() {
+		state.mu.Lock()
+		// A deferred opposing request may start another transition; apply it as well.
+		for !state.removed && state.pending() {
+			sc.completePending(name, state)
+		}
+		sc.checkInvariants(name, state)
+		state.mu.Unlock()
+		sc.flush(state)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDrainWaitsForPendingTransitions(t *testing.T) {
+	events := make(chan StateEvent, 4)
+	sc := NewStateController(WithOnStateEvent(func(ctx context.Context, ev StateEvent) { events <- ev }))
+	sc.AddState("state1", State{Delay: 20 * time.Millisecond, IsActive: true})
+	sc.SetState("state1", false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sc.Drain(ctx); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if sc.IsActive("state1") {
+		t.Fatal("Expected the pending deactivation to have fired")
+	}
+	if ev := <-events; ev.Cause != CauseTimer || ev.Latency() < 0 {
+		t.Fatalf("Expected the transition to fire at its deadline, got %+v", ev)
+	}
+
+	if err := sc.SetState("state1", true); !errors.Is(err, ErrDraining) {
+		t.Fatalf("Expected ErrDraining, got %v", err)
+	}
+	if err := sc.ForceState("state1", true); !errors.Is(err, ErrDraining) {
+		t.Fatalf("Expected ErrDraining, got %v", err)
+	}
+	if !sc.Draining() {
+		t.Fatal("Expected the controller to report draining")
+	}
+}
+
+func TestDrainFlushesAtDeadline(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("fast", State{Delay: 5 * time.Millisecond, IsActive: true})
+	sc.AddState("slow", State{Delay: time.Hour, IsActive: true})
+	sc.SetState("fast", false)
+	sc.SetState("slow", false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := sc.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected DeadlineExceeded, got %v", err)
+	}
+	if sc.IsActive("fast") || sc.IsActive("slow") {
+		t.Fatal("Expected all pending transitions to be applied")
+	}
+	if len(sc.PendingStates()) != 0 {
+		t.Fatalf("Expected no pending states, got %v", sc.PendingStates())
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+// newShadow creates the controller that processes the inputs of a controller in dry-run mode,
+// with the same behaviour options, clock and initial states as sc. The runtime state that decides
+// whether a request is accepted, e.g. disabled states, leases and suppressions, is mirrored by
+// the methods changing it, so the shadow accepts and rejects the same requests as sc would.
+func (sc *StateController) newShadow(report StateEventCallback) *StateController {
+	shadow := NewStateController(
+		WithName(sc.name),
+		WithOnStateEvent(report),
+		WithSuppressNoops(sc.suppressNoops),
+		WithCancelOnOpposite(!sc.keepPendingOnOpposite),
+		WithMaxPending(sc.maxPending),
+		WithTickInterval(sc.tickInterval),
+		WithInputSampling(sc.sampleInterval),
+		WithTimerCoalescing(sc.coalesceGranularity),
+		WithWallClockDeadlines(sc.wallCheckInterval),
+		WithBootGrace(sc.bootGrace),
+		WithSuppressPolicy(sc.suppressPolicy),
+		WithIdempotencyWindow(sc.idempotencyWindow),
+		withClock(sc.clock),
+	)
+	for name, state := range sc.states {
+		shadow.states[name] = shadow.newDelayedState(state.State)
+	}
+	shadow.publish(shadow.states)
+	return shadow
+}
+
+// withShadowVersion returns info with the version of the state in the dry-run shadow, which
+// counts the writes in dry-run mode, so that versions read from this controller can be passed to
+// SetStateVersioned. Returns info unchanged outside of dry-run mode.
+func (sc *StateController) withShadowVersion(info StateInfo) StateInfo {
+	if sc.shadow == nil {
+		return info
+	}
+	if state := sc.shadow.lockState(info.Name); state != nil {
+		info.Version = state.version
+		state.mu.Unlock()
+	}
+	return info
+}
+
+// DryRun reports whether the controller runs in dry-run mode; see WithDryRun.
+func (sc *StateController) DryRun() bool {
+	return sc.shadow != nil
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithDryRun(t *testing.T) {
+	events := make(chan StateEvent, 4)
+	liveChanges := 0
+	sc := NewStateController(
+		WithDryRun(func(ctx context.Context, ev StateEvent) { events <- ev }),
+		WithOnStateChange(func(name string, active bool) { liveChanges++ }),
+		WithInitializeStates(map[string]State{"initial": {}}),
+		WithOnStateNotExist(func(name string) (State, error) { return State{}, nil }),
+	)
+	defer sc.Close()
+	sc.AddState("state1", State{Delay: 10 * time.Millisecond})
+
+	if !sc.DryRun() {
+		t.Fatal("Expected dry-run mode")
+	}
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false)
+	if ev := <-events; ev.Name != "state1" || !ev.Active {
+		t.Fatalf("Expected the would-be activation, got %+v", ev)
+	}
+	select {
+	case ev := <-events:
+		if ev.Active || ev.Cause != CauseTimer {
+			t.Fatalf("Expected the would-be delayed deactivation, got %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the would-be delayed deactivation")
+	}
+
+	// Initial and lazily created states are processed as well.
+	sc.SetState("initial", true)
+	sc.SetState("created", true)
+	if ev := <-events; ev.Name != "initial" {
+		t.Fatalf("Expected a would-be change of initial, got %+v", ev)
+	}
+	if ev := <-events; ev.Name != "created" {
+		t.Fatalf("Expected a would-be change of created, got %+v", ev)
+	}
+
+	if sc.IsActive("state1") || sc.IsActive("initial") || sc.IsActive("created") || liveChanges != 0 {
+		t.Fatal("Expected effective values never to change")
+	}
+
+	// Removed states are removed from the shadow too.
+	sc.RemoveState("state1")
+	if sc.shadow.HasState("state1") {
+		t.Fatal("Expected state1 to be removed from the shadow")
+	}
+}
+
+func TestDryRunMirrorsRuntimeState(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		events := make(chan StateEvent, 4)
+		opts := []Option{WithInitializeStates(map[string]State{"disabled": {}, "leased": {}, "suppressed": {Tags: []string{"maint"}}})}
+		if dryRun {
+			opts = append(opts, WithDryRun(func(ctx context.Context, ev StateEvent) { events <- ev }))
+		} else {
+			opts = append(opts, WithOnStateEvent(func(ctx context.Context, ev StateEvent) { events <- ev }))
+		}
+		sc := NewStateController(opts...)
+
+		sc.DisableState("disabled")
+		sc.Acquire("leased", "owner", 0)
+		sc.Suppress("maint", time.Now().Add(time.Hour))
+
+		if err := sc.SetState("disabled", true); !errors.Is(err, ErrStateDisabled) {
+			t.Errorf("dry run %v: Expected ErrStateDisabled, got %v", dryRun, err)
+		}
+		if err := sc.ForceState("disabled", true); !errors.Is(err, ErrStateDisabled) {
+			t.Errorf("dry run %v: Expected ErrStateDisabled from ForceState, got %v", dryRun, err)
+		}
+		if err := sc.SetState("leased", true); !errors.Is(err, ErrLeaseHeld) {
+			t.Errorf("dry run %v: Expected ErrLeaseHeld, got %v", dryRun, err)
+		}
+		if err := sc.SetState("suppressed", true); err != nil {
+			t.Errorf("dry run %v: Expected the suppressed request to be accepted, got %v", dryRun, err)
+		}
+		if ev := <-events; ev.Name != "suppressed" || !ev.Suppressed {
+			t.Errorf("dry run %v: Expected a suppressed event, got %+v", dryRun, ev)
+		}
+
+		sc.EnableState("disabled")
+		sc.Release("leased", "owner")
+		if err := sc.SetState("disabled", true); err != nil {
+			t.Errorf("dry run %v: Expected the enabled state to be set, got %v", dryRun, err)
+		}
+		if err := sc.SetState("leased", true); err != nil {
+			t.Errorf("dry run %v: Expected the released state to be set, got %v", dryRun, err)
+		}
+		if ev := <-events; ev.Name != "disabled" || !ev.Active {
+			t.Errorf("dry run %v: Expected disabled to be activated, got %+v", dryRun, ev)
+		}
+		if ev := <-events; ev.Name != "leased" || !ev.Active {
+			t.Errorf("dry run %v: Expected leased to be activated, got %+v", dryRun, ev)
+		}
+		sc.Close()
+	}
+}
+
+func TestDryRunSetStateVersioned(t *testing.T) {
+	sc := NewStateController(
+		WithDryRun(func(ctx context.Context, ev StateEvent) {}),
+		WithInitializeStates(map[string]State{"state1": {}}),
+		WithIdempotencyWindow(time.Hour),
+	)
+	defer sc.Close()
+
+	// Read-modify-write cycles see the versions of the shadow, which processes the writes.
+	for i := 0; i < 3; i++ {
+		info, _ := sc.Info("state1")
+		if info.Version != uint64(i) {
+			t.Fatalf("Expected version %d, got %d", i, info.Version)
+		}
+		if err := sc.SetStateVersioned("state1", i%2 == 0, info.Version); err != nil {
+			t.Fatalf("Expected write %d to succeed, got %v", i, err)
+		}
+	}
+	if err := sc.SetStateVersioned("state1", true, 0); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("Expected ErrVersionConflict, got %v", err)
+	}
+
+	if sc.shadow.idempotencyWindow != time.Hour {
+		t.Fatalf("Expected the shadow to use the idempotency window, got %v", sc.shadow.idempotencyWindow)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// ErrInvalidDelay is returned by ParseDelay for strings that are not a valid delay, and by
+// ExtendPending and OverrideFrom for a duration that is not positive.
+var ErrInvalidDelay = errors.New("invalid delay")
+
+// ParseDelay parses a delay in one of the following formats:
+//   - a Go duration, e.g. "1h30m" or "500ms"
+//   - a Go duration with a leading day component, e.g. "2d" or "1d12h"
+//   - an ISO-8601 duration, e.g. "PT5M", "P1DT2H" or "P2W"
+//
+// ISO-8601 years and months are rejected, since their length is not fixed.
+func ParseDelay(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("%w: empty string", ErrInvalidDelay)
+	}
+
+	var (
+		d   time.Duration
+		err error
+	)
+	if s[0] == 'P' || s[0] == 'p' {
+		d, err = parseISO8601Duration(s[1:])
+	} else {
+		d, err = parseDayDuration(s)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w %q: %v", ErrInvalidDelay, s, err)
+	}
+	return d, nil
+}
+
+// parseDayDuration parses a Go duration that may start with a number of days.
+func parseDayDuration(s string) (time.Duration, error) {
+	sign := time.Duration(1)
+	rest := s
+	if strings.HasPrefix(rest, "-") {
+		sign, rest = -1, rest[1:]
+	}
+
+	i := strings.IndexByte(rest, 'd')
+	if i < 0 {
+		return time.ParseDuration(s)
+	}
+
+	days, err := strconv.ParseFloat(rest[:i], 64)
+	if err != nil || days < 0 {
+		return 0, fmt.Errorf("invalid day component %q", rest[:i+1])
+	}
+	d := time.Duration(days * float64(day))
+
+	if rest = rest[i+1:]; rest != "" {
+		r, err := time.ParseDuration(rest)
+		if err != nil {
+			return 0, err
+		}
+		if r < 0 {
+			return 0, errors.New("negative component after days")
+		}
+		d += r
+	}
+
+	return sign * d, nil
+}
+
+// parseISO8601Duration parses the part of an ISO-8601 duration after the leading "P".
+func parseISO8601Duration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, errors.New("missing components")
+	}
+
+	var d time.Duration
+	inTime := false
+	components := 0
+	for len(s) > 0 {
+		if s[0] == 'T' || s[0] == 't' {
+			if inTime {
+				return 0, errors.New("duplicate time designator")
+			}
+			inTime = true
+			s = s[1:]
+			continue
+		}
+
+		i := strings.IndexFunc(s, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.' && r != ','
+		})
+		if i <= 0 {
+			return 0, fmt.Errorf("expected number at %q", s)
+		}
+		v, err := strconv.ParseFloat(strings.Replace(s[:i], ",", ".", 1), 64)
+		if err != nil {
+			return 0, err
+		}
+
+		var unit time.Duration
+		switch designator := s[i] | 0x20; {
+		case !inTime && designator == 'w':
+			unit = 7 * day
+		case !inTime && designator == 'd':
+			unit = day
+		case inTime && designator == 'h':
+			unit = time.Hour
+		case inTime && designator == 'm':
+			unit = time.Minute
+		case inTime && designator == 's':
+			unit = time.Second
+		case !inTime && (designator == 'y' || designator == 'm'):
+			return 0, errors.New("years and months are not supported")
+		default:
+			return 0, fmt.Errorf("unexpected designator %q", s[i])
+		}
+
+		d += time.Duration(v * float64(unit))
+		components++
+		s = s[i+1:]
+	}
+
+	if components == 0 {
+		return 0, errors.New("missing components")
+	}
+	return d, nil
+}
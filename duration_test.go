@@ -0,0 +1,53 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseDelay(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"500ms", 500 * time.Millisecond},
+		{"1h30m", 90 * time.Minute},
+		{"2d", 48 * time.Hour},
+		{"1d12h", 36 * time.Hour},
+		{"0.5d", 12 * time.Hour},
+		{"-1d", -24 * time.Hour},
+		{"PT5M", 5 * time.Minute},
+		{"PT1.5S", 1500 * time.Millisecond},
+		{"PT0,5S", 500 * time.Millisecond},
+		{"P1DT2H", 26 * time.Hour},
+		{"P2W", 14 * 24 * time.Hour},
+		{"pt10s", 10 * time.Second},
+		{" 5m ", 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDelay(tt.in)
+		if err != nil {
+			t.Fatalf("ParseDelay(%q): expected no error, got %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Fatalf("ParseDelay(%q): expected %v, got %v", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestParseDelayInvalid(t *testing.T) {
+	for _, in := range []string{"", "soon", "P", "PT", "P1Y", "P1M", "PT1D", "P1H", "1d-5m", "xd", "P1DTT1H"} {
+		_, err := ParseDelay(in)
+		if !errors.Is(err, ErrInvalidDelay) {
+			t.Fatalf("ParseDelay(%q): expected ErrInvalidDelay, got %v", in, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Input is a request for a state fed to an Engine, as made by SetState.
+type Input struct {
+	Name   string
+	Active bool
+}
+
+// Engine runs the transition logic of a controller step by step on a clock given by the caller,
+// without timers or goroutines, so it can be fuzzed and property-tested, e.g. that the value of a
+// state eventually equals the last requested one. Delayed transitions fire only when a step
+// advances the clock past their deadline. Like a Scenario, it does not cover StaleAfter,
+// overrides, suppressions and escalations, which are timed on the real clock.
+type Engine struct {
+	sc    *StateController
+	clock *scenarioClock
+
+	mu     sync.Mutex
+	events []StateEvent
+}
+
+// NewEngine returns an engine with the given states whose clock starts at start. opts configure
+// the underlying controller, e.g. WithCancelOnOpposite; WithTickInterval, WithWallClockDeadlines
+// and WithAsyncCallbacks must not be given.
+func NewEngine(states map[string]State, start time.Time, opts ...Option) *Engine {
+	e := &Engine{clock: &scenarioClock{now: start}}
+	opts = append(opts[:len(opts):len(opts)],
+		WithInitializeStates(states),
+		withClock(e.clock.Now),
+		withEventLog(func(ev StateEvent) {
+			e.mu.Lock()
+			e.events = append(e.events, ev)
+			e.mu.Unlock()
+		}),
+	)
+	e.sc = NewStateController(opts...)
+	return e
+}
+
+// Step advances the clock to now, firing the transitions due on the way in deadline order, then
+// applies input, and returns all changes made, ordered by time and, at equal times, by state
+// name. The error is that of SetState for input. A now before the time of the previous step
+// is taken as that time.
+func (e *Engine) Step(input Input, now time.Time) ([]StateEvent, error) {
+	e.advance(now)
+	err := e.sc.SetState(input.Name, input.Active)
+	return e.takeEvents(), err
+}
+
+// Advance advances the clock to now like Step, without input, and returns the changes made.
+func (e *Engine) Advance(now time.Time) []StateEvent {
+	e.advance(now)
+	return e.takeEvents()
+}
+
+func (e *Engine) advance(now time.Time) {
+	if now.Before(e.clock.Now()) {
+		now = e.clock.Now()
+	}
+	e.sc.advanceTo(e.clock, now)
+}
+
+// takeEvents returns the changes recorded since the last call, in a deterministic order.
+func (e *Engine) takeEvents() []StateEvent {
+	e.mu.Lock()
+	events := e.events
+	e.events = nil
+	e.mu.Unlock()
+
+	// Transitions sharing a deadline fire in no particular order; the stable sort keeps the
+	// order of the changes of each state.
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].Time.Equal(events[j].Time) {
+			return events[i].Time.Before(events[j].Time)
+		}
+		return events[i].Name < events[j].Name
+	})
+	return events
+}
+
+// Now returns the time of the engine's clock.
+func (e *Engine) Now() time.Time {
+	return e.clock.Now()
+}
+
+// NextDeadline returns the earliest deadline of the pending transitions, if any, e.g. to advance
+// the engine exactly to it.
+func (e *Engine) NextDeadline() (time.Time, bool) {
+	return e.sc.nextDeadline()
+}
+
+// Info returns a snapshot of the named state; see StateController.Info.
+func (e *Engine) Info(name string) (StateInfo, error) {
+	return e.sc.Info(name)
+}
+
+// Close releases the resources of the underlying controller.
+func (e *Engine) Close() {
+	e.sc.Close()
+}
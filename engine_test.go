@@ -0,0 +1,74 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEngine(t *testing.T) {
+	start := time.Unix(1000, 0)
+	e := NewEngine(map[string]State{"light": {Delay: 30 * time.Second}, "door": {}}, start)
+	defer e.Close()
+
+	events, err := e.Step(Input{Name: "light", Active: true}, start)
+	if err != nil || len(events) != 1 || events[0].Name != "light" || !events[0].Active {
+		t.Fatalf("Expected the activation to apply at once, got %+v (%v)", events, err)
+	}
+	e.Step(Input{Name: "light", Active: false}, start.Add(10*time.Second))
+	if next, ok := e.NextDeadline(); !ok || !next.Equal(start.Add(40*time.Second)) {
+		t.Fatalf("Expected the deactivation to be due at 40s, got %v", next)
+	}
+
+	events, _ = e.Step(Input{Name: "door", Active: true}, start.Add(time.Minute))
+	if len(events) != 2 || events[0].Name != "light" || events[0].Cause != CauseTimer || events[1].Name != "door" {
+		t.Fatalf("Expected the due transition before the input, got %+v", events)
+	}
+	if !events[0].Time.Equal(start.Add(40 * time.Second)) {
+		t.Fatalf("Expected the transition to fire at its deadline, got %v", events[0].Time)
+	}
+	if _, err := e.Step(Input{Name: "missing"}, start); err == nil {
+		t.Fatal("Expected an error for a missing state")
+	}
+	if !e.Now().Equal(start.Add(time.Minute)) {
+		t.Fatal("Expected the clock not to move back")
+	}
+}
+
+// FuzzEngine checks that the value of a state eventually equals the last requested value, for
+// any sequence of requests and configuration.
+func FuzzEngine(f *testing.F) {
+	f.Add([]byte{1, 0, 1, 1, 0}, uint16(30), false, uint8(0))
+	f.Add([]byte{0, 255, 1, 3}, uint16(5), true, uint8(2))
+	f.Fuzz(func(t *testing.T, inputs []byte, delay uint16, onActivation bool, policy uint8) {
+		state := State{
+			Delay:             time.Duration(delay) * time.Second,
+			DelayOnActivation: onActivation,
+			PendingPolicy:     PendingPolicy(policy % 4),
+			Extension:         time.Second,
+		}
+		start := time.Unix(0, 0)
+		e := NewEngine(map[string]State{"s": state}, start, WithInvariantChecks(true))
+		defer e.Close()
+
+		now := start
+		last := false
+		for _, b := range inputs {
+			now = now.Add(time.Duration(b>>1) * time.Second)
+			last = b&1 == 1
+			if _, err := e.Step(Input{Name: "s", Active: last}, now); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Extensions are bounded by the number of requests, so this is past every deadline.
+		e.Advance(now.Add(state.Delay + time.Duration(len(inputs)+1)*time.Second))
+		if info, _ := e.Info("s"); info.State.IsActive != last || info.Pending {
+			t.Fatalf("Expected the state to settle at %v, got %+v", last, info)
+		}
+	})
+}
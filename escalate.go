@@ -0,0 +1,68 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"time"
+)
+
+// Escalation is a rule raising an alert when a state keeps a value for too long, e.g. a page if
+// "pump-failure" stays active for more than 10 minutes; see WithEscalations.
+type Escalation struct {
+	Name     string        // Name of the state watched.
+	Active   bool          // Value that escalates.
+	After    time.Duration // How long the state must keep the value.
+	Severity string        // Passed on in the EscalationEvent, e.g. "warning" or "page".
+}
+
+// EscalationEvent reports an Escalation whose state kept its value for the rule's duration.
+type EscalationEvent struct {
+	Controller string     // Name of the controller set with WithName, if any.
+	Rule       Escalation // The rule that escalated.
+	Since      time.Time  // Since when the state has the value.
+	Time       time.Time  // When the rule escalated.
+}
+
+// armEscalations starts the timers of the escalation rules for the current value of state,
+// replacing those for its previous value. since is when the state took the value.
+// Must be called with the state's mutex held, or before the state is published.
+func (sc *StateController) armEscalations(name string, state *delayedState, since time.Time) {
+	state.stopEscalations()
+	if state.removed || state.Unknown {
+		return
+	}
+	gen := state.escalationGen
+	for _, rule := range sc.escalations[name] {
+		if rule.Active != state.IsActive {
+			continue
+		}
+		rule := rule
+		state.escalationTimers = append(state.escalationTimers, sc.afterFunc(rule.After, func() {
+			sc.fireEscalation(state, gen, rule, since)
+		}))
+	}
+}
+
+// fireEscalation reports rule unless state changed since its timer was started.
+func (sc *StateController) fireEscalation(state *delayedState, gen uint64, rule Escalation, since time.Time) {
+	state.mu.Lock()
+	current := !state.removed && state.escalationGen == gen
+	state.mu.Unlock()
+	if current {
+		sc.onEscalation(EscalationEvent{Controller: sc.name, Rule: rule, Since: since, Time: sc.now()})
+	}
+}
+
+// stopEscalations stops the escalation timers of state, including any that already fired but
+// did not report yet. Must be called with the state's mutex held.
+func (s *delayedState) stopEscalations() {
+	s.escalationGen++
+	for _, t := range s.escalationTimers {
+		t.Stop()
+	}
+	s.escalationTimers = s.escalationTimers[:0]
+}
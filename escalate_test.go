@@ -0,0 +1,69 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithEscalations(t *testing.T) {
+	events := make(chan EscalationEvent, 10)
+	sc := NewStateController(
+		WithInitializeStates(map[string]State{"pump-failure": {}, "door": {IsActive: true}}),
+		WithEscalations(func(ev EscalationEvent) { events <- ev },
+			Escalation{Name: "pump-failure", Active: true, After: 20 * time.Millisecond, Severity: "page"},
+			Escalation{Name: "pump-failure", Active: true, After: time.Hour, Severity: "call"},
+			Escalation{Name: "door", Active: true, After: 10 * time.Millisecond, Severity: "warning"},
+		),
+	)
+	defer sc.Close()
+
+	select {
+	case ev := <-events:
+		if ev.Rule.Name != "door" || ev.Rule.Severity != "warning" {
+			t.Fatalf("Expected the door to escalate first, got %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a state starting at the value to escalate")
+	}
+
+	sc.ForceState("pump-failure", true)
+	sc.ForceState("pump-failure", false) // Recovered before the rule's duration.
+	select {
+	case ev := <-events:
+		t.Fatalf("Expected no escalation after recovery, got %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	start := time.Now()
+	sc.ForceState("pump-failure", true)
+	select {
+	case ev := <-events:
+		if ev.Rule.Severity != "page" || ev.Time.Sub(ev.Since) < 20*time.Millisecond || ev.Since.Before(start) {
+			t.Fatalf("Unexpected escalation %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the failure to escalate")
+	}
+}
+
+func TestEscalationsRemoveWhere(t *testing.T) {
+	sc := NewStateController(WithEscalations(func(ev EscalationEvent) {},
+		Escalation{Name: "pump-running", Active: false, After: time.Hour},
+	))
+	defer sc.Close()
+	sc.AddState("pump-running", State{})
+	state := sc.loadIndex()["pump-running"]
+
+	sc.Clear()
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if len(state.escalationTimers) != 0 {
+		t.Fatal("Expected the escalation timers of a cleared state to be stopped")
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Cause tells what made a state change.
+type Cause int
+
+const (
+	// CauseExplicit is a change applied directly by a call such as SetState, Reset, UpdateState
+	// or RemoveState.
+	CauseExplicit Cause = iota
+	// CauseTimer is a delayed transition applied when its delay elapsed, or early, e.g. by
+	// RemoveStateFlush or SetBypassAll.
+	CauseTimer
+	// CauseForced is a change made by ForceState.
+	CauseForced
+	// CauseStale is a value that became unknown because the state was not set within
+	// State.StaleAfter.
+	CauseStale
+	// CauseOverride is a change made by Override or OverrideFrom, or by one of their overrides
+	// ending while others still run.
+	CauseOverride
+)
+
+func (c Cause) String() string {
+	switch c {
+	case CauseExplicit:
+		return "explicit"
+	case CauseTimer:
+		return "timer"
+	case CauseForced:
+		return "forced"
+	case CauseStale:
+		return "stale"
+	case CauseOverride:
+		return "override"
+	default:
+		return fmt.Sprintf("Cause(%d)", int(c))
+	}
+}
+
+// StateEvent describes a single change of a state's IsActive value. It is delivered to
+// WithOnStateEvent callbacks and to subscriptions.
+type StateEvent struct {
+	Controller  string    // Name of the controller set with WithName, if any.
+	Seq         uint64    // Controller-wide sequence number, increasing in transition order.
+	Name        string    // Name of the state.
+	Old         bool      // IsActive value before the change.
+	Active      bool      // New IsActive value.
+	Unknown     bool      // The value became unknown; Active is the last known value then.
+	WasUnknown  bool      // The value was unknown before the change; Old is the last known value then.
+	Suppressed  bool      // A request held back by Suppress; the value did not actually change.
+	Cause       Cause     // What made the change.
+	RequestedBy string    // Requester set on the causing call's context with WithRequestedBy, if any.
+	Quality     float64   // Quality given with WithQuality to the causing call; 1 if none was given.
+	Priority    Priority  // Priority given with WithPriority to the causing call.
+	ScheduledAt time.Time // When a delayed transition was scheduled; zero unless Cause is CauseTimer.
+	Deadline    time.Time // When a delayed transition was due; zero unless Cause is CauseTimer.
+	Time        time.Time // Time of the change.
+	Tags        []string  // Tags of the state at the time of the change; must not be modified.
+}
+
+// Latency returns how late a delayed transition was applied relative to its deadline, or zero
+// if the change was not delayed or applied early.
+func (ev StateEvent) Latency() time.Duration {
+	if ev.Deadline.IsZero() || ev.Time.Before(ev.Deadline) {
+		return 0
+	}
+	return ev.Time.Sub(ev.Deadline)
+}
+
+// StateEventCallback is called with the full description of each change and the context of the
+// call that caused it.
+type StateEventCallback func(ctx context.Context, ev StateEvent)
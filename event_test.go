@@ -0,0 +1,99 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestOnStateEvent(t *testing.T) {
+	events := make(chan StateEvent, 4)
+	sc := NewStateController(WithOnStateEvent(func(ctx context.Context, ev StateEvent) {
+		events <- ev
+	}))
+	sc.AddState("state1", State{Delay: 20 * time.Millisecond})
+
+	ctx := WithRequestedBy(context.Background(), "operator")
+	before := time.Now()
+	sc.SetStateCtx(ctx, "state1", true)
+	sc.SetStateCtx(ctx, "state1", false)
+
+	ev := <-events
+	if ev.Cause != CauseExplicit || ev.Old || !ev.Active || ev.RequestedBy != "operator" || !ev.ScheduledAt.IsZero() {
+		t.Fatalf("Expected explicit activation by operator, got %+v", ev)
+	}
+
+	select {
+	case ev = <-events:
+	case <-time.After(time.Second):
+		t.Fatal("Expected delayed deactivation")
+	}
+	if ev.Cause != CauseTimer || !ev.Old || ev.Active || ev.RequestedBy != "operator" {
+		t.Fatalf("Expected timer deactivation requested by operator, got %+v", ev)
+	}
+	if ev.ScheduledAt.Before(before) || ev.Time.Sub(ev.ScheduledAt) < 20*time.Millisecond {
+		t.Fatalf("Expected ScheduledAt at least one delay before Time, got %v and %v", ev.ScheduledAt, ev.Time)
+	}
+}
+
+func TestCauseString(t *testing.T) {
+	tests := map[Cause]string{
+		CauseExplicit: "explicit",
+		CauseTimer:    "timer",
+		CauseForced:   "forced",
+		Cause(42):     "Cause(42)",
+	}
+	for cause, want := range tests {
+		if got := cause.String(); got != want {
+			t.Fatalf("Expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestFiringLatency(t *testing.T) {
+	events := make(chan StateEvent, 8)
+	sc := NewStateController(WithOnStateEvent(func(ctx context.Context, ev StateEvent) {
+		events <- ev
+	}))
+	sc.AddState("state1", State{Delay: 10 * time.Millisecond, DelayOnActivation: true})
+
+	sc.SetState("state1", true)
+	var ev StateEvent
+	select {
+	case ev = <-events:
+	case <-time.After(time.Second):
+		t.Fatal("Expected delayed activation")
+	}
+	if ev.Deadline.Sub(ev.ScheduledAt) != 10*time.Millisecond {
+		t.Fatalf("Expected deadline one delay after scheduling, got %v", ev.Deadline.Sub(ev.ScheduledAt))
+	}
+	if ev.Latency() != ev.Time.Sub(ev.Deadline) {
+		t.Fatalf("Expected latency %v, got %v", ev.Time.Sub(ev.Deadline), ev.Latency())
+	}
+
+	h := sc.FiringLatency()
+	if h.Count != 1 || len(h.Counts) != len(h.Bounds)+1 {
+		t.Fatalf("Expected one recorded firing, got %+v", h)
+	}
+	var total uint64
+	for _, n := range h.Counts {
+		total += n
+	}
+	if total != 1 || h.Max < 0 || h.Sum != h.Max {
+		t.Fatalf("Expected consistent histogram, got %+v", h)
+	}
+
+	// Early applications are not firings.
+	sc.SetState("state1", false)
+	sc.SetState("state1", true)
+	sc.RemoveStateFlush("state1")
+	if got := sc.FiringLatency().Count; got != 1 {
+		t.Fatalf("Expected early application not to be recorded, got %d firings", got)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidExpr is returned for malformed state expressions.
+var ErrInvalidExpr = errors.New("invalid expression")
+
+// expr is a parsed boolean expression over state values; see AwaitExpr.
+type expr interface {
+	eval(values map[string]bool) bool
+}
+
+type (
+	stateRef string
+	notExpr  struct{ x expr }
+	andExpr  struct{ x, y expr }
+	orExpr   struct{ x, y expr }
+)
+
+func (e stateRef) eval(values map[string]bool) bool { return values[string(e)] }
+func (e notExpr) eval(values map[string]bool) bool  { return !e.x.eval(values) }
+func (e andExpr) eval(values map[string]bool) bool  { return e.x.eval(values) && e.y.eval(values) }
+func (e orExpr) eval(values map[string]bool) bool   { return e.x.eval(values) || e.y.eval(values) }
+
+// parseExpr parses an expression of state names combined with !, &&, || and parentheses, with
+// the usual precedence. It also returns the referenced state names, without duplicates.
+func parseExpr(s string) (expr, []string, error) {
+	p := exprParser{src: s, seen: make(map[string]bool)}
+	e, err := p.parseOr()
+	if err == nil {
+		p.skipSpace()
+		if p.pos < len(p.src) {
+			err = p.errorf("unexpected %q", p.src[p.pos:])
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return e, p.names, nil
+}
+
+type exprParser struct {
+	src   string
+	pos   int
+	names []string
+	seen  map[string]bool
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w %q at %d: %s", ErrInvalidExpr, p.src, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && strings.ContainsRune(" \t\r\n", rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// accept consumes tok if it comes next.
+func (p *exprParser) accept(tok string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.pos:], tok) {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+	x, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var y expr
+		if y, err = p.parseAnd(); err == nil {
+			x = orExpr{x, y}
+		}
+	}
+	return x, err
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+	x, err := p.parseUnary()
+	for err == nil && p.accept("&&") {
+		var y expr
+		if y, err = p.parseUnary(); err == nil {
+			x = andExpr{x, y}
+		}
+	}
+	return x, err
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	if p.accept("!") {
+		x, err := p.parseUnary()
+		return notExpr{x}, err
+	}
+	if p.accept("(") {
+		x, err := p.parseOr()
+		if err == nil && !p.accept(")") {
+			err = p.errorf("missing )")
+		}
+		return x, err
+	}
+	return p.parseName()
+}
+
+// parseName parses a state name: everything up to whitespace, an operator or a parenthesis.
+func (p *exprParser) parseName() (expr, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && !strings.ContainsRune(" \t\r\n!&|()", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	if p.pos == start {
+		return nil, p.errorf("expected state name")
+	}
+	name := p.src[start:p.pos]
+	if !p.seen[name] {
+		p.seen[name] = true
+		p.names = append(p.names, name)
+	}
+	return stateRef(name), nil
+}
+
+// AwaitExpr blocks until a boolean expression over state values becomes true, e.g.
+// "a && (b || !c)". State names are combined with ! (not), && (and), || (or) and parentheses;
+// a name is any run of characters other than whitespace, operators and parentheses. The
+// expression is evaluated initially and after each change of a referenced state, not by polling.
+// It is not considered true while a referenced value is unknown. Returns ErrInvalidExpr for
+// malformed expressions; other errors are reported as for Await.
+func (sc *StateController) AwaitExpr(ctx context.Context, expression string) error {
+	e, names, err := parseExpr(expression)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]bool, len(names))
+	return sc.awaitStates(ctx, names, func(active, known []bool) bool {
+		for i, name := range names {
+			if !known[i] {
+				return false
+			}
+			values[name] = active[i]
+		}
+		return e.eval(values)
+	})
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		expr   string
+		values map[string]bool
+		want   bool
+	}{
+		{"a", map[string]bool{"a": true}, true},
+		{"!a", map[string]bool{"a": true}, false},
+		{"a && b", map[string]bool{"a": true}, false},
+		{"a || b", map[string]bool{"b": true}, true},
+		{"a || b && c", map[string]bool{"a": true}, true},    // && binds tighter
+		{"(a || b) && c", map[string]bool{"a": true}, false}, // parentheses
+		{"a && (b || !c)", map[string]bool{"a": true}, true}, // !c with c inactive
+		{"door/front&&!alarm.armed", map[string]bool{"door/front": true}, true},
+		{"!!a", map[string]bool{"a": true}, true},
+	}
+
+	for _, tt := range tests {
+		e, _, err := parseExpr(tt.expr)
+		if err != nil {
+			t.Fatalf("%q: expected no error, got %v", tt.expr, err)
+		}
+		if got := e.eval(tt.values); got != tt.want {
+			t.Fatalf("%q with %v: expected %v, got %v", tt.expr, tt.values, tt.want, got)
+		}
+	}
+}
+
+func TestParseExprNames(t *testing.T) {
+	_, names, _ := parseExpr("a && (b || !a)")
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Fatalf("Expected names [a b], got %v", names)
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, s := range []string{"", "a &&", "(a", "a)", "a & b", "&& a", "a b"} {
+		if _, _, err := parseExpr(s); !errors.Is(err, ErrInvalidExpr) {
+			t.Fatalf("%q: expected ErrInvalidExpr, got %v", s, err)
+		}
+	}
+}
+
+func TestAwaitExpr(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("a", State{})
+	sc.AddState("b", State{})
+	sc.AddState("c", State{IsActive: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sc.AwaitExpr(ctx, "a && (b || !c)")
+	}()
+
+	sc.SetState("a", true)
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("Expected AwaitExpr to keep waiting, got %v", err)
+	default:
+	}
+
+	sc.Reset("c")
+	if err := <-done; err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if err := sc.AwaitExpr(ctx, "a &&"); !errors.Is(err, ErrInvalidExpr) {
+		t.Fatalf("Expected ErrInvalidExpr, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrGoldenMismatch is returned by MatchGolden if the output differs from the golden file.
+var ErrGoldenMismatch = errors.New("golden file mismatch")
+
+// MatchGolden compares got, e.g. a Scenario.Transcript, to the contents of the golden file at
+// path, so refactorings can be verified to preserve behaviour. If they differ, the returned error
+// wraps ErrGoldenMismatch and lists the differing lines, "-" for the golden file and "+" for got.
+// With update, the golden file is (re)written with got instead, typically behind an -update flag
+// of the test. Line endings are compared ignoring "\r", so golden files may be checked out with
+// CRLF line endings.
+//
+//	transcript, err := scenario.Transcript()
+//	...
+//	if err := delayedstate.MatchGolden("testdata/door.golden", transcript, *update); err != nil {
+//		t.Fatal(err)
+//	}
+func MatchGolden(path, got string, update bool) error {
+	if update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(path, []byte(got+"\n"), 0o644)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	want := strings.TrimSuffix(strings.ReplaceAll(string(data), "\r", ""), "\n")
+	got = strings.TrimSuffix(strings.ReplaceAll(got, "\r", ""), "\n")
+	if got == want {
+		return nil
+	}
+	return fmt.Errorf("%w: %s (-want +got):\n%s", ErrGoldenMismatch, path,
+		diffLines(strings.Split(want, "\n"), strings.Split(got, "\n")))
+}
+
+// diffLines returns the lines differing between want and got, based on their longest common
+// subsequence, each prefixed with its line number and "-" if only in want or "+" if only in got.
+func diffLines(want, got []string) string {
+	// common[i][j] is the length of the longest common subsequence of want[i:] and got[j:].
+	common := make([][]int, len(want)+1)
+	for i := range common {
+		common[i] = make([]int, len(got)+1)
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		for j := len(got) - 1; j >= 0; j-- {
+			switch {
+			case want[i] == got[j]:
+				common[i][j] = common[i+1][j+1] + 1
+			case common[i+1][j] >= common[i][j+1]:
+				common[i][j] = common[i+1][j]
+			default:
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	var b strings.Builder
+	i, j := 0, 0
+	for i < len(want) || j < len(got) {
+		switch {
+		case i < len(want) && j < len(got) && want[i] == got[j]:
+			i++
+			j++
+		case j == len(got) || (i < len(want) && common[i+1][j] >= common[i][j+1]):
+			fmt.Fprintf(&b, "  %d - %s\n", i+1, want[i])
+			i++
+		default:
+			fmt.Fprintf(&b, "  %d + %s\n", j+1, got[j])
+			j++
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
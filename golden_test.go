@@ -0,0 +1,65 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatchGolden(t *testing.T) {
+	s, err := ParseScenario("state light delay=30s\nat 0 set light=true\nat 10s set light=false\nat 1m set light=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcript, err := s.Transcript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "0s: light true (explicit)\n40s: light false (timer)\n1m0s: light true (explicit)"
+	if transcript != want {
+		t.Fatalf("Expected transcript\n%s\ngot\n%s", want, transcript)
+	}
+
+	path := filepath.Join(t.TempDir(), "testdata", "light.golden")
+	if err := MatchGolden(path, transcript, false); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected a missing golden file, got %v", err)
+	}
+	if err := MatchGolden(path, transcript, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := MatchGolden(path, transcript, false); err != nil {
+		t.Fatalf("Expected a match, got %v", err)
+	}
+
+	// CRLF line endings in the golden file are ignored.
+	if err := os.WriteFile(path, []byte(strings.ReplaceAll(transcript, "\n", "\r\n")+"\r\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := MatchGolden(path, transcript, false); err != nil {
+		t.Fatalf("Expected a match despite CRLF, got %v", err)
+	}
+
+	changed := strings.Replace(transcript, "40s", "41s", 1)
+	err = MatchGolden(path, changed, false)
+	if !errors.Is(err, ErrGoldenMismatch) {
+		t.Fatalf("Expected ErrGoldenMismatch, got %v", err)
+	}
+	if !strings.HasSuffix(err.Error(), "\n  2 - 40s: light false (timer)\n  2 + 41s: light false (timer)") {
+		t.Fatalf("Expected a diff of line 2, got:\n%v", err)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	got := diffLines([]string{"a", "b", "c"}, []string{"a", "c", "d"})
+	if want := "  2 - b\n  3 + d"; got != want {
+		t.Fatalf("Expected\n%s\ngot\n%s", want, got)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"sync"
+	"time"
+)
+
+// routines counts the goroutines a controller runs: background loops, async callback workers,
+// subscription deliveries and the callbacks of armed timers.
+type routines struct {
+	mu     sync.Mutex
+	idle   *sync.Cond // Signalled when n drops to zero or a timer is armed; nil until first used.
+	n      int
+	timers map[*trackedTimer]struct{} // Armed timers whose callbacks have not started.
+}
+
+// trackedTimer is a timer started by routines.afterFunc.
+type trackedTimer struct {
+	r *routines
+	t *time.Timer
+	f func()
+}
+
+// add counts a goroutine about to start or just started.
+func (r *routines) add() {
+	r.mu.Lock()
+	r.n++
+	r.mu.Unlock()
+}
+
+// done counts a goroutine as finished.
+func (r *routines) done() {
+	r.mu.Lock()
+	r.n--
+	if r.n == 0 && r.idle != nil {
+		r.idle.Broadcast()
+	}
+	r.mu.Unlock()
+}
+
+// goTracked runs f on a new counted goroutine.
+func (r *routines) goTracked(f func()) {
+	r.add()
+	go func() {
+		defer r.done()
+		f()
+	}()
+}
+
+// afterFunc is like time.AfterFunc, but counts the callback from the moment the timer is armed
+// rather than when it starts, so that wait cannot return while the timer has fired but its
+// callback has not started yet.
+func (r *routines) afterFunc(d time.Duration, f func()) *trackedTimer {
+	t := &trackedTimer{r: r, f: f}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.n++
+	if r.timers == nil {
+		r.timers = make(map[*trackedTimer]struct{})
+	}
+	r.timers[t] = struct{}{}
+	t.t = time.AfterFunc(d, t.fire)
+	if r.idle != nil {
+		r.idle.Broadcast() // A timer armed during wait must be stopped too.
+	}
+	return t
+}
+
+func (t *trackedTimer) fire() {
+	t.r.mu.Lock()
+	delete(t.r.timers, t)
+	t.r.mu.Unlock()
+	defer t.r.done()
+	t.f()
+}
+
+// Stop stops the timer like time.Timer.Stop; if its callback has not started, it is no longer
+// counted.
+func (t *trackedTimer) Stop() bool {
+	r := t.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stopTimer(t)
+}
+
+// stopTimer stops t and stops counting its callback, unless it already fired. Must be called with
+// r.mu held.
+func (r *routines) stopTimer(t *trackedTimer) bool {
+	if !t.t.Stop() {
+		return false
+	}
+	delete(r.timers, t)
+	r.n--
+	if r.n == 0 && r.idle != nil {
+		r.idle.Broadcast()
+	}
+	return true
+}
+
+// wait stops the armed timers, including those armed meanwhile, and blocks until no counted
+// goroutine is running.
+func (r *routines) wait() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.idle == nil {
+		r.idle = sync.NewCond(&r.mu)
+	}
+	for {
+		for t := range r.timers {
+			r.stopTimer(t)
+		}
+		if r.n == 0 {
+			return
+		}
+		r.idle.Wait()
+	}
+}
+
+// count returns the number of counted goroutines running, leaving out armed timers that have not
+// fired.
+func (r *routines) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.n - len(r.timers)
+}
+
+// PendingGoroutines returns the number of goroutines the controller currently runs: the loops of
+// WithTickInterval and WithWallClockDeadlines, async callback workers, subscription deliveries,
+// and timers firing a transition or staleness at this moment. Close waits for all of them to
+// finish, so leak checks such as goleak pass in tests of code using the controller.
+func (sc *StateController) PendingGoroutines() int {
+	return sc.routines.count()
+}
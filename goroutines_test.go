@@ -0,0 +1,120 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPendingGoroutines(t *testing.T) {
+	sc := NewStateController(
+		WithTickInterval(time.Hour),
+		WithAsyncCallbacks(4, OverflowBlock),
+		WithCallbackWorkers(2),
+		WithOnStateChange(func(name string, active bool) {}),
+	)
+	sc.Subscribe(8)
+
+	// Ticker, two workers and the subscription.
+	if got := sc.PendingGoroutines(); got != 4 {
+		t.Fatalf("Expected 4 goroutines, got %d", got)
+	}
+	sc.Close()
+	if got := sc.PendingGoroutines(); got != 0 {
+		t.Fatalf("Expected no goroutines after Close, got %d", got)
+	}
+}
+
+func TestCloseWaitsForTimerCallbacks(t *testing.T) {
+	release := make(chan struct{})
+	sc := NewStateController(WithOnStateChange(func(name string, active bool) {
+		if !active {
+			<-release
+		}
+	}))
+	sc.AddState("state1", State{Delay: time.Millisecond})
+	sc.SetState("state1", true)
+	sc.SetState("state1", false)
+
+	deadline := time.Now().Add(time.Second)
+	for sc.PendingGoroutines() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the timer callback to be counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		sc.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Expected Close to wait for the running callback")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Close to return once the callback finished")
+	}
+	if got := sc.PendingGoroutines(); got != 0 {
+		t.Fatalf("Expected no goroutines after Close, got %d", got)
+	}
+}
+
+func TestRoutinesAfterFunc(t *testing.T) {
+	var r routines
+	fired := make(chan struct{})
+	r.afterFunc(time.Millisecond, func() { close(fired) })
+	stopped := r.afterFunc(time.Hour, func() {})
+	r.afterFunc(time.Hour, func() {})
+
+	// Armed timers are counted from the start, but not reported as running.
+	r.mu.Lock()
+	n := r.n
+	r.mu.Unlock()
+	if n != 3 || r.count() != 0 {
+		t.Fatalf("Expected 3 armed timers and no running goroutine, got %d and %d", n, r.count())
+	}
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal("Expected only the first Stop to stop the timer")
+	}
+	<-fired
+
+	// wait stops the timer still armed instead of waiting for it.
+	done := make(chan struct{})
+	go func() {
+		r.wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected wait to stop the armed timer")
+	}
+}
+
+func TestCloseStopsCoalesceFlush(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{})
+	sub := sc.Subscribe(8, WithCoalesce(time.Hour))
+	defer sub.Close()
+	sc.ForceState("state1", true)
+
+	sc.Close()
+	c := sub.consumer
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.flushTimer != nil {
+		t.Fatal("Expected Close to stop the coalescing flush")
+	}
+}
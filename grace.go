@@ -0,0 +1,76 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// heldRequest is the latest request for a state held back during the boot grace period, a
+// suppression or an override, to be applied when it ends.
+type heldRequest struct {
+	ctx    context.Context
+	active bool
+}
+
+// InBootGrace reports whether the boot grace period of WithBootGrace is still running.
+func (sc *StateController) InBootGrace() bool {
+	return atomic.LoadInt32(&sc.inGrace) == 1
+}
+
+// EndBootGrace ends the boot grace period of WithBootGrace early, e.g. once all sensors
+// reconnected, and applies the buffered requests. Has no effect if the period already ended.
+func (sc *StateController) EndBootGrace() {
+	if sc.shadow != nil {
+		sc.shadow.EndBootGrace()
+	}
+	if !atomic.CompareAndSwapInt32(&sc.inGrace, 1, 0) {
+		return
+	}
+	sc.releaseHeld(func(state *delayedState) bool { return true })
+}
+
+// releaseHeld applies the held requests of the states matching match. A request held back again,
+// e.g. by a suppression still running, stays held; one that fails now, e.g. as the state was
+// disabled meanwhile, is dropped.
+func (sc *StateController) releaseHeld(match func(state *delayedState) bool) {
+	for name, state := range sc.loadIndex() {
+		state.mu.Lock()
+		held := state.held
+		if held != nil && !state.removed && match(state) {
+			state.held = nil
+			_ = sc.request(held.ctx, name, state, held.active)
+		}
+		state.mu.Unlock()
+		sc.flush(state)
+	}
+}
+
+// hold holds back the request for state during the boot grace period, an override of the state
+// or a suppression of one of its tags, and reports whether it did. Otherwise, it drops a held
+// request not yet applied, which the new request supersedes. Must be called with the state's
+// mutex held.
+func (sc *StateController) hold(ctx context.Context, name string, state *delayedState, active bool) bool {
+	switch {
+	case sc.InBootGrace():
+		state.held = &heldRequest{ctx: detachContext(ctx), active: active}
+		return true
+	case len(state.overrides) != 0:
+		state.held = &heldRequest{ctx: detachContext(ctx), active: active}
+		return true
+	case sc.suppressed(state):
+		sc.recordSuppressed(ctx, name, state, active)
+		if sc.suppressPolicy == SuppressBuffer {
+			state.held = &heldRequest{ctx: detachContext(ctx), active: active}
+		}
+		return true
+	default:
+		state.held = nil
+		return false
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWithBootGrace(t *testing.T) {
+	var changes []string
+	sc := NewStateController(
+		WithBootGrace(time.Hour),
+		WithInitializeStates(map[string]State{"door": {}, "light": {IsActive: true}, "fan": {}}),
+		WithOnStateChange(func(name string, active bool) { changes = append(changes, name) }),
+	)
+	defer sc.Close()
+
+	if !sc.InBootGrace() {
+		t.Fatal("Expected the boot grace period to run")
+	}
+	if err := sc.SetState("door", true); err != nil {
+		t.Fatal(err)
+	}
+	sc.SetState("light", false)
+	sc.SetState("light", true) // The latest request wins.
+	if accepted, err := sc.TrySetState("fan", true); !accepted || err != nil {
+		t.Fatalf("Expected TrySetState to be buffered, got %v, %v", accepted, err)
+	}
+	if sc.IsActive("door") || !sc.IsActive("light") || sc.IsActive("fan") || len(changes) != 0 {
+		t.Fatal("Expected states to keep their initial values during the grace period")
+	}
+
+	sc.EndBootGrace()
+	if sc.InBootGrace() || !sc.IsActive("door") || !sc.IsActive("light") || !sc.IsActive("fan") {
+		t.Fatal("Expected the buffered requests to be applied")
+	}
+	if len(changes) != 2 {
+		t.Fatalf("Expected changes of door and fan only, got %v", changes)
+	}
+}
+
+func TestWithBootGraceExpires(t *testing.T) {
+	sc := NewStateController(WithBootGrace(10 * time.Millisecond))
+	defer sc.Close()
+	sc.AddState("door", State{})
+	sc.SetState("door", true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sc.Await(ctx, "door", true); err != nil {
+		t.Fatal("Expected the buffered request to be applied when the period ends")
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// grafanaRange is the time range of a Grafana JSON datasource request.
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type grafanaSearch struct {
+	Target string `json:"target"`
+}
+
+type grafanaQuery struct {
+	Range   grafanaRange `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+// grafanaSeries is a time series answered to a query; points are [value, Unix milliseconds],
+// with a null value while the state is unknown.
+type grafanaSeries struct {
+	Target     string           `json:"target"`
+	Datapoints [][2]interface{} `json:"datapoints"`
+}
+
+type grafanaAnnotationQuery struct {
+	Range      grafanaRange    `json:"range"`
+	Annotation json.RawMessage `json:"annotation"`
+}
+
+type grafanaAnnotation struct {
+	Annotation json.RawMessage `json:"annotation"`
+	Time       int64           `json:"time"`
+	Title      string          `json:"title"`
+	Text       string          `json:"text,omitempty"`
+	Tags       []string        `json:"tags,omitempty"`
+}
+
+// GrafanaHandler returns an http.Handler implementing the Grafana JSON datasource API, so states
+// can be charted next to other dashboards; mount it with http.StripPrefix at the datasource URL.
+// POST /search lists the state names containing the target, POST /query answers each target
+// with the values of that state as 1 or 0 in the requested range, and POST /annotations marks
+// each change of the state named by the annotation query, or of all states if it is empty.
+// The values come from Series, so the controller must record them with WithSeries. With
+// WithAuthorizer, searches and annotations of all states leave out the states the caller may not
+// read (OpRead), and queries naming such a state are answered with 403.
+func (sc *StateController) GrafanaHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusOK) // Connection test of the datasource.
+	})
+	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		var req grafanaSearch
+		if decodeGrafana(w, r, &req) {
+			writeJSON(w, sc.Find(func(name string, _ StateInfo) bool {
+				return strings.Contains(name, req.Target) && sc.mayRead(r.Context(), name)
+			}))
+		}
+	})
+	mux.HandleFunc("/query", func(w http.ResponseWriter, r *http.Request) {
+		var req grafanaQuery
+		if decodeGrafana(w, r, &req) {
+			respondGrafana(w)(sc.grafanaQuery(r.Context(), req))
+		}
+	})
+	mux.HandleFunc("/annotations", func(w http.ResponseWriter, r *http.Request) {
+		var req grafanaAnnotationQuery
+		if decodeGrafana(w, r, &req) {
+			respondGrafana(w)(sc.grafanaAnnotations(r.Context(), req))
+		}
+	})
+	return mux
+}
+
+// decodeGrafana decodes the JSON body of a POST request into req, and reports whether it
+// succeeded; otherwise it answers the request with an error.
+func decodeGrafana(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// respondGrafana returns a function answering with a response, or with an error; see httpError.
+func respondGrafana(w http.ResponseWriter) func(resp interface{}, err error) {
+	return func(resp interface{}, err error) {
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		writeJSON(w, resp)
+	}
+}
+
+func (sc *StateController) grafanaQuery(ctx context.Context, req grafanaQuery) (interface{}, error) {
+	series := make([]grafanaSeries, 0, len(req.Targets))
+	for _, target := range req.Targets {
+		if target.Target == "" {
+			continue
+		}
+		if err := sc.authorizeHTTP(ctx, OpRead, target.Target); err != nil {
+			return nil, err
+		}
+		samples, err := sc.Series(target.Target, req.Range.From, req.Range.To)
+		if err != nil {
+			return nil, err
+		}
+		s := grafanaSeries{Target: target.Target, Datapoints: make([][2]interface{}, 0, len(samples)+1)}
+		for _, sample := range samples {
+			s.Datapoints = append(s.Datapoints, grafanaPoint(sample, sample.Time))
+		}
+		// Extend the last value to the end of the range, or now, so the graph does not stop at
+		// the last change.
+		end := sc.now()
+		if !req.Range.To.IsZero() && req.Range.To.Before(end) {
+			end = req.Range.To
+		}
+		if n := len(samples); n > 0 && samples[n-1].Time.Before(end) {
+			s.Datapoints = append(s.Datapoints, grafanaPoint(samples[n-1], end))
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
+
+func grafanaPoint(sample Sample, t time.Time) [2]interface{} {
+	var value interface{}
+	switch {
+	case sample.Unknown:
+	case sample.Active:
+		value = 1
+	default:
+		value = 0
+	}
+	return [2]interface{}{value, t.UnixMilli()}
+}
+
+func (sc *StateController) grafanaAnnotations(ctx context.Context, req grafanaAnnotationQuery) (interface{}, error) {
+	var query struct {
+		Query string `json:"query"`
+	}
+	if len(req.Annotation) > 0 {
+		if err := json.Unmarshal(req.Annotation, &query); err != nil {
+			return nil, err
+		}
+	}
+	names := []string{query.Query}
+	if query.Query == "" {
+		names = sc.Find(func(name string, _ StateInfo) bool { return sc.mayRead(ctx, name) })
+	} else if err := sc.authorizeHTTP(ctx, OpRead, query.Query); err != nil {
+		return nil, err
+	}
+
+	annotations := []grafanaAnnotation{}
+	for _, name := range names {
+		samples, err := sc.Series(name, req.Range.From, req.Range.To)
+		if err != nil {
+			if query.Query == "" {
+				continue // Removed meanwhile.
+			}
+			return nil, err
+		}
+		var tags []string
+		if state, err := sc.GetState(name); err == nil {
+			tags = state.Tags
+		}
+		for i := 1; i < len(samples); i++ {
+			prev, sample := samples[i-1], samples[i]
+			if prev.Active == sample.Active && prev.Unknown == sample.Unknown {
+				continue
+			}
+			annotations = append(annotations, grafanaAnnotation{
+				Annotation: req.Annotation,
+				Time:       sample.Time.UnixMilli(),
+				Title:      name + " " + sampleValue(sample),
+				Text:       sampleValue(prev) + " -> " + sampleValue(sample),
+				Tags:       tags,
+			})
+		}
+	}
+	sort.SliceStable(annotations, func(i, j int) bool { return annotations[i].Time < annotations[j].Time })
+	return annotations, nil
+}
+
+// sampleValue describes the value of sample.
+func sampleValue(sample Sample) string {
+	switch {
+	case sample.Unknown:
+		return "unknown"
+	case sample.Active:
+		return "active"
+	default:
+		return "inactive"
+	}
+}
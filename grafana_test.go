@@ -0,0 +1,122 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGrafanaHandler(t *testing.T) {
+	sc := NewStateController(WithSeries(time.Hour, 0))
+	defer sc.Close()
+	sc.AddState("door/front", State{Tags: []string{"zone1"}})
+	sc.AddState("light", State{})
+	sc.ForceState("door/front", true)
+	sc.ForceState("door/front", false)
+
+	srv := httptest.NewServer(http.StripPrefix("/grafana", sc.GrafanaHandler()))
+	defer srv.Close()
+	post := func(path, body string, resp interface{}) int {
+		res, err := http.Post(srv.URL+"/grafana"+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res.StatusCode
+	}
+
+	if res, err := http.Get(srv.URL + "/grafana/"); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the connection test to succeed, got %v", err)
+	}
+
+	var names []string
+	if post("/search", `{"target": "door"}`, &names); len(names) != 1 || names[0] != "door/front" {
+		t.Fatalf("Expected door/front, got %v", names)
+	}
+
+	var series []struct {
+		Target     string
+		Datapoints [][2]float64
+	}
+	from := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	to := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	query := `{"range": {"from": "` + from + `", "to": "` + to + `"}, "targets": [{"target": "door/front"}]}`
+	if post("/query", query, &series); len(series) != 1 || series[0].Target != "door/front" {
+		t.Fatalf("Expected the series of door/front, got %+v", series)
+	}
+	var values []float64
+	for _, p := range series[0].Datapoints {
+		values = append(values, p[0])
+	}
+	if len(values) != 4 || values[0] != 0 || values[1] != 1 || values[2] != 0 || values[3] != 0 {
+		t.Fatalf("Expected 0, 1, 0 extended to now, got %v", values)
+	}
+	if code := post("/query", `{"targets": [{"target": "missing"}]}`, &series); code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a missing state, got %d", code)
+	}
+
+	var annotations []grafanaAnnotation
+	post("/annotations", `{"range": {"from": "`+from+`", "to": "`+to+`"}, "annotation": {"name": "changes", "query": ""}}`, &annotations)
+	if len(annotations) != 2 || annotations[0].Title != "door/front active" || annotations[1].Text != "active -> inactive" ||
+		len(annotations[0].Tags) != 1 || !strings.Contains(string(annotations[0].Annotation), "changes") {
+		t.Fatalf("Expected two annotations for door/front, got %+v", annotations)
+	}
+}
+
+func TestGrafanaHandlerAuthorize(t *testing.T) {
+	sc := NewStateController(WithSeries(time.Hour, 0), WithAuthorizer(func(ctx context.Context, op Operation, name string) error {
+		if name == "secret" {
+			return errors.New("forbidden")
+		}
+		return nil
+	}))
+	defer sc.Close()
+	sc.AddState("door", State{})
+	sc.AddState("secret", State{})
+	sc.ForceState("door", true)
+	sc.ForceState("secret", true)
+
+	post := func(path, body string, resp interface{}) int {
+		rec := httptest.NewRecorder()
+		sc.GrafanaHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		if rec.Code == http.StatusOK {
+			json.Unmarshal(rec.Body.Bytes(), resp)
+		}
+		return rec.Code
+	}
+
+	var names []string
+	if post("/search", `{"target": ""}`, &names); len(names) != 1 || names[0] != "door" {
+		t.Fatalf("Expected only door to be found, got %v", names)
+	}
+	var series []grafanaSeries
+	if code := post("/query", `{"targets": [{"target": "door"}, {"target": "secret"}]}`, &series); code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for a query of secret, got %d", code)
+	}
+	var annotations []grafanaAnnotation
+	post("/annotations", `{"annotation": {"query": ""}}`, &annotations)
+	for _, a := range annotations {
+		if strings.HasPrefix(a.Title, "secret") {
+			t.Fatalf("Expected no annotations of secret, got %+v", annotations)
+		}
+	}
+	if code := post("/annotations", `{"annotation": {"query": "secret"}}`, &annotations); code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for annotations of secret, got %d", code)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+// StateHandle is a lightweight reference to a single named state of a StateController.
+// A handle does not keep the state alive; if the state is removed, operations on the
+// handle behave like the corresponding controller methods for a missing state.
+type StateHandle struct {
+	sc   *StateController
+	name string
+}
+
+// Name returns the name of the state the handle refers to.
+func (h *StateHandle) Name() string {
+	return h.name
+}
+
+// SetState sets the state, respecting the configured delay. See StateController.SetState.
+func (h *StateHandle) SetState(active bool, opts ...SetOption) error {
+	return h.sc.SetState(h.name, active, opts...)
+}
+
+// IsActive returns the current active status of the state.
+func (h *StateHandle) IsActive() bool {
+	return h.sc.IsActive(h.name)
+}
+
+// IsUnknown reports whether the value of the state is unknown. See StateController.IsUnknown.
+func (h *StateHandle) IsUnknown() bool {
+	return h.sc.IsUnknown(h.name)
+}
+
+// IsPending reports whether a delayed transition is pending and towards which value.
+// See StateController.IsPending.
+func (h *StateHandle) IsPending() (pending bool, towards bool) {
+	return h.sc.IsPending(h.name)
+}
+
+// IsRequested returns the value last requested for the state. See StateController.IsRequested.
+func (h *StateHandle) IsRequested() bool {
+	return h.sc.IsRequested(h.name)
+}
+
+// State returns the current state configuration.
+func (h *StateHandle) State() (State, error) {
+	return h.sc.GetState(h.name)
+}
+
+// Reset cancels any pending timer and immediately deactivates the state.
+func (h *StateHandle) Reset() error {
+	return h.sc.Reset(h.name)
+}
+
+// Remove removes the state from its controller and reports whether it existed.
+func (h *StateHandle) Remove() bool {
+	return h.sc.RemoveState(h.name)
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGetOrCreateCreatesState(t *testing.T) {
+	sc := NewStateController()
+
+	calls := 0
+	h, err := sc.GetOrCreate("state1", func() State {
+		calls++
+		return State{Delay: time.Second, DelayOnActivation: true}
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if h.Name() != "state1" {
+		t.Fatalf("Expected handle name 'state1', got '%s'", h.Name())
+	}
+	if calls != 1 {
+		t.Fatalf("Expected factory to be called once, got %d", calls)
+	}
+
+	state, err := h.State()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !state.DelayOnActivation || state.Delay != time.Second {
+		t.Fatalf("Expected factory configuration, got %+v", state)
+	}
+}
+
+func TestGetOrCreateExistingStateSkipsFactory(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: time.Second})
+
+	called := false
+	sc.GetOrCreate("state1", func() State {
+		called = true
+		return State{}
+	})
+
+	if called {
+		t.Fatal("Expected factory not to be called for an existing state")
+	}
+}
+
+func TestGetOrCreateInvalidState(t *testing.T) {
+	sc := NewStateController()
+
+	h, err := sc.GetOrCreate("state1", func() State {
+		return State{Delay: -time.Second}
+	})
+
+	if !errors.Is(err, ErrInvalidState) || h != nil {
+		t.Fatalf("Expected ErrInvalidState and no handle, got %v, %v", h, err)
+	}
+	if sc.HasState("state1") {
+		t.Fatal("Expected the invalid state not to be created")
+	}
+}
+
+func TestGetOrCreateNilFactory(t *testing.T) {
+	sc := NewStateController()
+
+	h, _ := sc.GetOrCreate("state1", nil)
+
+	if !sc.HasState("state1") {
+		t.Fatal("Expected state1 to be created with a nil factory")
+	}
+	if h.IsActive() {
+		t.Fatal("Expected state1 to be inactive")
+	}
+}
+
+func TestStateHandleOperations(t *testing.T) {
+	sc := NewStateController()
+	h, _ := sc.GetOrCreate("state1", func() State {
+		return State{Delay: 10 * time.Millisecond}
+	})
+
+	if err := h.SetState(true); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !h.IsActive() {
+		t.Fatal("Expected state1 to be active")
+	}
+	if !h.IsRequested() {
+		t.Fatal("Expected state1 to be requested active")
+	}
+
+	if err := h.Reset(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if h.IsActive() {
+		t.Fatal("Expected state1 to be inactive after Reset")
+	}
+
+	h.Remove()
+	if sc.HasState("state1") {
+		t.Fatal("Expected state1 to be removed")
+	}
+
+	if err := h.SetState(true); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound after removal, got %v", err)
+	}
+}
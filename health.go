@@ -0,0 +1,62 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUnhealthy is returned by Healthy, wrapped with the reason.
+var ErrUnhealthy = errors.New("controller unhealthy")
+
+// overdueTolerance is how late a pending transition may be before Healthy reports the scheduler
+// as lagging, on top of the tick interval of WithTickInterval.
+const overdueTolerance = time.Second
+
+// Healthy reports whether the controller works as expected, for wiring into application health
+// endpoints. It returns an error wrapping ErrUnhealthy if the controller is closed, a pending
+// transition is more than a second past its deadline (the scheduler is stalled or lagging), an
+// async callback queue is full, or the wall clock was set back before the controller's creation.
+// It inspects every state, so it is meant to be polled, not called on hot paths.
+func (sc *StateController) Healthy() error {
+	select {
+	case <-sc.closed:
+		return fmt.Errorf("%w: closed", ErrUnhealthy)
+	default:
+	}
+
+	// created is taken from the wall clock; deadlines from the controller's clock.
+	if time.Now().Round(0).Before(sc.created.Round(0)) {
+		return fmt.Errorf("%w: wall clock set back before the controller was created", ErrUnhealthy)
+	}
+
+	for i, d := range sc.dispatchers {
+		d.mu.Lock()
+		full := len(d.queue) >= d.size
+		d.mu.Unlock()
+		if full {
+			return fmt.Errorf("%w: callback queue of worker %d full", ErrUnhealthy, i)
+		}
+	}
+
+	now := sc.now()
+	tolerance := overdueTolerance + sc.tickInterval
+	overdue := 0
+	for _, state := range sc.loadIndex() {
+		state.mu.Lock()
+		if state.pending() && now.Sub(state.deadline) > tolerance {
+			overdue++
+		}
+		state.mu.Unlock()
+	}
+	if overdue > 0 {
+		return fmt.Errorf("%w: %d transitions overdue", ErrUnhealthy, overdue)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHealthy(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: time.Hour, IsActive: true})
+	sc.SetState("state2", true)
+
+	if err := sc.Healthy(); err != nil {
+		t.Fatalf("Expected a healthy controller, got %v", err)
+	}
+
+	// Pretend the timer missed its deadline.
+	state := sc.states["state1"]
+	state.mu.Lock()
+	state.deadline = time.Now().Add(-2 * time.Second)
+	state.mu.Unlock()
+	if err := sc.Healthy(); !errors.Is(err, ErrUnhealthy) {
+		t.Fatalf("Expected an overdue transition to be reported, got %v", err)
+	}
+
+	sc.Close()
+	if err := sc.Healthy(); !errors.Is(err, ErrUnhealthy) {
+		t.Fatalf("Expected a closed controller to be unhealthy, got %v", err)
+	}
+}
+
+func TestHealthyFullCallbackQueue(t *testing.T) {
+	release := make(chan struct{})
+	sc := NewStateController(
+		WithAsyncCallbacks(1, OverflowDropNewest),
+		WithOnStateChange(func(name string, active bool) { <-release }),
+	)
+	defer sc.Close()
+	defer close(release)
+	sc.AddState("state1", State{})
+	sc.AddState("state2", State{})
+
+	// The first callback blocks the worker, the second fills the queue.
+	sc.SetState("state1", true)
+	time.Sleep(10 * time.Millisecond)
+	sc.SetState("state2", true)
+
+	if err := sc.Healthy(); !errors.Is(err, ErrUnhealthy) {
+		t.Fatalf("Expected a full callback queue to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stateQuery is a filter given in the query string of StatesHandler.
+type stateQuery struct {
+	active  *bool
+	pending *bool
+	tags    []string
+	prefix  string
+}
+
+// parseStateQuery parses the parameters active, pending, tag (repeatable) and prefix.
+func parseStateQuery(values url.Values) (stateQuery, error) {
+	var q stateQuery
+	for key, vals := range values {
+		switch key {
+		case "active", "pending":
+			b, err := strconv.ParseBool(vals[len(vals)-1])
+			if err != nil {
+				return stateQuery{}, fmt.Errorf("parameter %s: %v", key, err)
+			}
+			if key == "active" {
+				q.active = &b
+			} else {
+				q.pending = &b
+			}
+		case "tag":
+			q.tags = vals
+		case "prefix":
+			q.prefix = vals[len(vals)-1]
+		default:
+			return stateQuery{}, fmt.Errorf("unknown parameter %s", key)
+		}
+	}
+	return q, nil
+}
+
+// match reports whether a state passes the filter.
+func (q stateQuery) match(name string, info StateInfo) bool {
+	if !strings.HasPrefix(name, q.prefix) {
+		return false
+	}
+	if q.active != nil && (info.State.IsActive != *q.active || info.State.Unknown) {
+		return false
+	}
+	if q.pending != nil && info.Pending != *q.pending {
+		return false
+	}
+	for _, tag := range q.tags {
+		if !hasTag(info.State.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// stateJSON is a state as served by StatesHandler.
+type stateJSON struct {
+	Name              string     `json:"name"`
+	Active            bool       `json:"active"`
+	Unknown           bool       `json:"unknown,omitempty"`
+	Delay             string     `json:"delay"`
+	DelayOnActivation bool       `json:"delayOnActivation"`
+	Tags              []string   `json:"tags,omitempty"`
+	Pending           bool       `json:"pending"`
+	PendingTarget     bool       `json:"pendingTarget,omitempty"`
+	Deadline          *time.Time `json:"deadline,omitempty"`
+	LastChange        *time.Time `json:"lastChange,omitempty"`
+	Disabled          bool       `json:"disabled,omitempty"`
+}
+
+func newStateJSON(info StateInfo) stateJSON {
+	s := stateJSON{
+		Name:              info.Name,
+		Active:            info.State.IsActive,
+		Unknown:           info.State.Unknown,
+		Delay:             info.State.Delay.String(),
+		DelayOnActivation: info.State.DelayOnActivation,
+		Tags:              info.State.Tags,
+		Pending:           info.Pending,
+		PendingTarget:     info.PendingTarget,
+		Disabled:          info.Disabled,
+	}
+	if info.Pending {
+		s.Deadline = &info.Deadline
+	}
+	if !info.LastChange.IsZero() {
+		s.LastChange = &info.LastChange
+	}
+	return s
+}
+
+// StatesHandler returns an http.Handler serving the states matching the query string as a JSON
+// array sorted by name, so dashboards can filter server-side, e.g.
+// ?active=true&pending=true&tag=zone1&prefix=door/. Given parameters must all match: active
+// and pending take a bool, tag may be repeated to require several tags, and prefix restricts
+// the names. Unknown states never match active. States the authorizer of WithAuthorizer does not
+// let the caller read (OpRead) are left out. Only GET is allowed; malformed queries are answered
+// with 400.
+func (sc *StateController) StatesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q, err := parseStateQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		infos := make(map[string]StateInfo)
+		names := sc.Find(func(name string, info StateInfo) bool {
+			if !q.match(name, info) || !sc.mayRead(r.Context(), name) {
+				return false
+			}
+			infos[name] = info
+			return true
+		})
+		states := make([]stateJSON, 0, len(names))
+		for _, name := range names {
+			states = append(states, newStateJSON(infos[name]))
+		}
+		writeJSON(w, states)
+	})
+}
+
+// writeJSON answers with v encoded as JSON.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// forbiddenError is an error of the authorizer in an HTTP handler, answered with 403.
+type forbiddenError struct {
+	err error
+}
+
+func (e forbiddenError) Error() string { return e.err.Error() }
+
+func (e forbiddenError) Unwrap() error { return e.err }
+
+// authorizeHTTP is like Authorize, but marks a denial to be answered with 403 by httpError.
+func (sc *StateController) authorizeHTTP(ctx context.Context, op Operation, name string) error {
+	if err := sc.Authorize(ctx, op, name); err != nil {
+		return forbiddenError{err}
+	}
+	return nil
+}
+
+// mayRead reports whether the authorizer lets the caller identified by ctx read the named state.
+func (sc *StateController) mayRead(ctx context.Context, name string) bool {
+	return sc.Authorize(ctx, OpRead, name) == nil
+}
+
+// httpError answers with err: 403 if the authorizer denied the request, 400 otherwise.
+func httpError(w http.ResponseWriter, err error) {
+	var forbidden forbiddenError
+	if errors.As(err, &forbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// hasTag reports whether tags contains tag.
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// maxSnapshotSize limits the size of uploads to SnapshotHandler.
+const maxSnapshotSize = 16 << 20
+
+// settingsJSON is a state in a snapshot of SnapshotHandler, in the format of StatesFromSettings.
+type settingsJSON struct {
+	Delay             string   `json:"delay"`
+	DelayOnActivation bool     `json:"delay_on_activation"`
+	Active            bool     `json:"active"`
+	Tags              []string `json:"tags,omitempty"`
+}
+
+// snapshotResult reports the changes made by an upload to SnapshotHandler.
+type snapshotResult struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Updated []string `json:"updated"`
+	DryRun  bool     `json:"dryRun"`
+}
+
+// SnapshotHandler returns an http.Handler for backup, restore and environment promotion of the
+// configuration. GET downloads all states as a JSON object mapping each name to its "delay",
+// "delay_on_activation", "active" and "tags", the format of StatesFromSettings. PUT or POST
+// uploads such an object and applies it with Reconcile, answering with the names of the added,
+// removed and updated states; with ?dryRun=true the upload is only validated and the changes
+// it would make are reported. An upload with an invalid state is rejected with 400 as a whole,
+// one larger than 16 MiB with 413.
+// Like configuration files, snapshots only cover these settings: StaleAfter, PendingPolicy,
+// Extension and Clock are not downloaded, and whether a value is unknown is lost. An upload
+// keeps them for existing states, as Reconcile does, while added states get their defaults.
+// With WithAuthorizer, downloads leave out the states the caller may not read (OpRead), and
+// uploads keep them rather than removing them as missing, so a download can be uploaded again.
+// An upload is rejected with 403 as a whole unless the caller may configure (OpConfigure) every
+// state it would add, remove or update, even in a dry run.
+func (sc *StateController) SnapshotHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			sc.serveSnapshot(w, r)
+		case http.MethodPut, http.MethodPost:
+			sc.serveUpload(w, r)
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func (sc *StateController) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshot := make(map[string]settingsJSON)
+	for name, state := range sc.loadIndex() {
+		if !sc.mayRead(r.Context(), name) {
+			continue
+		}
+		state.mu.Lock()
+		if !state.removed {
+			snapshot[name] = settingsJSON{
+				Delay:             state.Delay.String(),
+				DelayOnActivation: state.DelayOnActivation,
+				Active:            state.IsActive,
+				Tags:              state.Tags,
+			}
+		}
+		state.mu.Unlock()
+	}
+	writeJSON(w, snapshot)
+}
+
+func (sc *StateController) serveUpload(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if v := r.URL.Query().Get("dryRun"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "parameter dryRun: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSnapshotSize))
+	if err != nil {
+		status := http.StatusBadRequest
+		if len(body) >= maxSnapshotSize {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, "reading snapshot: "+err.Error(), status)
+		return
+	}
+	var settings map[string]interface{}
+	if err := json.Unmarshal(body, &settings); err != nil {
+		http.Error(w, "invalid snapshot: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	states, err := StatesFromSettings(settings)
+	if err == nil {
+		err = validateStates(states)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The states the caller may not read were left out of its downloads, so their absence does
+	// not ask for their removal.
+	for name := range sc.loadIndex() {
+		if _, ok := states[name]; ok || sc.mayRead(r.Context(), name) {
+			continue
+		}
+		if current, err := sc.GetState(name); err == nil {
+			states[name] = current
+		}
+	}
+
+	result := sc.planReconcile(states)
+	for _, names := range [][]string{result.Added, result.Removed, result.Updated} {
+		for _, name := range names {
+			if err := sc.authorizeHTTP(r.Context(), OpConfigure, name); err != nil {
+				httpError(w, err)
+				return
+			}
+		}
+	}
+	result.DryRun = dryRun
+	if !dryRun {
+		if err := sc.Reconcile(states); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, result)
+}
+
+// validateStates returns the errors of the invalid states, by name.
+func validateStates(states map[string]State) error {
+	var errs reconcileErrors
+	for _, name := range stateNames(states) {
+		if err := validateState(states[name]); err != nil {
+			errs = append(errs, fmt.Errorf(stateErrorFormat, name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// planReconcile returns the names of the states Reconcile would add, remove and update.
+func (sc *StateController) planReconcile(states map[string]State) snapshotResult {
+	result := snapshotResult{Added: []string{}, Removed: []string{}, Updated: []string{}}
+	for name := range sc.loadIndex() {
+		if _, keep := states[name]; !keep {
+			result.Removed = append(result.Removed, name)
+		}
+	}
+	sort.Strings(result.Removed)
+	for _, name := range stateNames(states) {
+		existing, err := sc.GetState(name)
+		switch {
+		case err != nil:
+			result.Added = append(result.Added, name)
+		case configChanged(existing, states[name]):
+			result.Updated = append(result.Updated, name)
+		}
+	}
+	return result
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatesHandler(t *testing.T) {
+	sc := NewStateController()
+	defer sc.Close()
+	sc.AddState("door/front", State{Delay: time.Hour, Tags: []string{"zone1"}})
+	sc.AddState("door/back", State{Delay: time.Hour, Tags: []string{"zone1"}})
+	sc.AddState("door/garage", State{Tags: []string{"zone2"}})
+	sc.AddState("light", State{Tags: []string{"zone1"}})
+	for _, name := range []string{"door/front", "door/back", "door/garage", "light"} {
+		sc.ForceState(name, true)
+	}
+	sc.SetState("door/front", false)
+
+	get := func(query string) (int, []stateJSON) {
+		rec := httptest.NewRecorder()
+		sc.StatesHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/states?"+query, nil))
+		var states []stateJSON
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &states); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, states
+	}
+
+	code, states := get("active=true&pending=true&tag=zone1&prefix=door/")
+	if code != http.StatusOK || len(states) != 1 || states[0].Name != "door/front" || !states[0].Pending || states[0].Deadline == nil {
+		t.Fatalf("Expected door/front, got %d %+v", code, states)
+	}
+	if _, states := get("tag=zone1&pending=false"); len(states) != 2 || states[0].Name != "door/back" || states[1].Name != "light" {
+		t.Fatalf("Expected door/back and light, got %+v", states)
+	}
+	if _, states := get(""); len(states) != 4 {
+		t.Fatalf("Expected all states, got %+v", states)
+	}
+	for _, query := range []string{"active=maybe", "color=red"} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for %q, got %d", query, code)
+		}
+	}
+}
+
+func TestSnapshotHandler(t *testing.T) {
+	sc := NewStateController()
+	defer sc.Close()
+	sc.AddState("door", State{Delay: time.Minute, Tags: []string{"zone1"}})
+	sc.AddState("light", State{IsActive: true})
+	sc.AddState("fan", State{})
+
+	rec := httptest.NewRecorder()
+	sc.SnapshotHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/snapshot", nil))
+	var snapshot map[string]settingsJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot) != 3 || snapshot["door"].Delay != "1m0s" || !snapshot["light"].Active || snapshot["door"].Tags[0] != "zone1" {
+		t.Fatalf("Unexpected snapshot %+v", snapshot)
+	}
+
+	upload := func(query, body string) (int, snapshotResult) {
+		rec := httptest.NewRecorder()
+		sc.SnapshotHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/snapshot"+query, strings.NewReader(body)))
+		var result snapshotResult
+		if rec.Code == http.StatusOK {
+			json.Unmarshal(rec.Body.Bytes(), &result)
+		}
+		return rec.Code, result
+	}
+	body := `{"door": {"delay": "5m", "tags": ["zone1"]}, "light": {"active": true}, "pump": {"delay": 30}}`
+
+	code, result := upload("?dryRun=true", body)
+	if code != http.StatusOK || !result.DryRun || len(result.Added) != 1 || result.Added[0] != "pump" ||
+		len(result.Removed) != 1 || result.Removed[0] != "fan" || len(result.Updated) != 1 || result.Updated[0] != "door" {
+		t.Fatalf("Unexpected dry-run result %d %+v", code, result)
+	}
+	if sc.HasState("pump") || !sc.HasState("fan") {
+		t.Fatal("Expected a dry run not to change the states")
+	}
+
+	if code, result := upload("", body); code != http.StatusOK || result.DryRun {
+		t.Fatalf("Unexpected result %d %+v", code, result)
+	}
+	if state, _ := sc.GetState("pump"); state.Delay != 30*time.Second || sc.HasState("fan") {
+		t.Fatal("Expected the upload to be applied")
+	}
+
+	for _, body := range []string{`{"door": {"delay": "-5m"}}`, `{"door": "5m"}`, `[`} {
+		if code, _ := upload("", body); code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for %s, got %d", body, code)
+		}
+	}
+	if state, _ := sc.GetState("door"); state.Delay != 5*time.Minute {
+		t.Fatal("Expected a rejected upload not to change the states")
+	}
+}
+
+func TestHandlersAuthorize(t *testing.T) {
+	errForbidden := errors.New("forbidden")
+	sc := NewStateController(WithAuthorizer(func(ctx context.Context, op Operation, name string) error {
+		if ctx.Value(tokenKey{}) == "admin" || op == OpRead && name != "secret" {
+			return nil
+		}
+		return errForbidden
+	}))
+	defer sc.Close()
+	sc.AddState("door", State{Delay: time.Minute})
+	sc.AddState("secret", State{})
+
+	viewer := context.WithValue(context.Background(), tokenKey{}, "viewer")
+	serve := func(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)).WithContext(viewer))
+		return rec
+	}
+
+	var states []stateJSON
+	json.Unmarshal(serve(sc.StatesHandler(), http.MethodGet, "/states", "").Body.Bytes(), &states)
+	if len(states) != 1 || states[0].Name != "door" {
+		t.Fatalf("Expected only door to be listed, got %+v", states)
+	}
+	var snapshot map[string]settingsJSON
+	json.Unmarshal(serve(sc.SnapshotHandler(), http.MethodGet, "/snapshot", "").Body.Bytes(), &snapshot)
+	if _, ok := snapshot["secret"]; ok || len(snapshot) != 1 {
+		t.Fatalf("Expected only door in the snapshot, got %+v", snapshot)
+	}
+
+	for _, query := range []string{"?dryRun=true", ""} {
+		rec := serve(sc.SnapshotHandler(), http.MethodPut, "/snapshot"+query, `{"door": {"delay": "5m"}}`)
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "forbidden") {
+			t.Fatalf("Expected 403 for the upload%s, got %d %s", query, rec.Code, rec.Body)
+		}
+	}
+	if state, _ := sc.GetState("door"); state.Delay != time.Minute || !sc.HasState("secret") {
+		t.Fatal("Expected a denied upload not to change the states")
+	}
+}
+
+func TestSnapshotHandlerKeepsUnreadableStates(t *testing.T) {
+	sc := NewStateController(WithAuthorizer(func(ctx context.Context, op Operation, name string) error {
+		if op == OpRead && name == "secret" {
+			return errors.New("forbidden")
+		}
+		return nil
+	}))
+	defer sc.Close()
+	sc.AddState("door", State{Delay: time.Minute})
+	sc.AddState("secret", State{})
+
+	rec := httptest.NewRecorder()
+	sc.SnapshotHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/snapshot", nil))
+	download := rec.Body.String()
+
+	// Uploading the download again must not remove the state left out of it.
+	rec = httptest.NewRecorder()
+	sc.SnapshotHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/snapshot", strings.NewReader(download)))
+	var result snapshotResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected the upload to succeed, got %d %s", rec.Code, rec.Body)
+	}
+	if len(result.Removed) != 0 || len(result.Added) != 0 || len(result.Updated) != 0 || !sc.HasState("secret") {
+		t.Fatalf("Expected no changes, got %+v", result)
+	}
+}
+
+func TestSnapshotHandlerTooLarge(t *testing.T) {
+	sc := NewStateController()
+	defer sc.Close()
+	sc.AddState("door", State{})
+
+	body := `{"door": {"tags": ["` + strings.Repeat("x", maxSnapshotSize) + `"]}}`
+	rec := httptest.NewRecorder()
+	sc.SnapshotHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/snapshot", strings.NewReader(body)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413 for an oversized upload, got %d", rec.Code)
+	}
+	if state, _ := sc.GetState("door"); len(state.Tags) != 0 {
+		t.Fatal("Expected an oversized upload not to change the states")
+	}
+}
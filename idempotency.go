@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultIdempotencyWindow is how long idempotency keys are remembered without WithIdempotencyWindow.
+const defaultIdempotencyWindow = time.Minute
+
+// idempotencyEntry is a request seen with an idempotency key.
+type idempotencyEntry struct {
+	done    chan struct{} // Closed once the request completed.
+	failed  bool          // The request failed; its key is forgotten.
+	expires time.Time     // When the key is forgotten; zero while the request is in flight.
+}
+
+// idempotencyKeys remembers the keys of successful requests for the idempotency window.
+type idempotencyKeys struct {
+	now     func() time.Time // The controller's clock; set by NewStateController.
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	order   []string // Keys of completed entries, in expiry order.
+}
+
+// claim reports whether the request with key is the first one within the window and must be
+// applied. A duplicate of a request in flight waits for its outcome: if it succeeds, the
+// duplicate is not applied; if it fails, the duplicate is applied instead.
+func (k *idempotencyKeys) claim(ctx context.Context, key string) (bool, error) {
+	for {
+		k.mu.Lock()
+		k.prune(k.now())
+		e, exists := k.entries[key]
+		if !exists {
+			if k.entries == nil {
+				k.entries = make(map[string]*idempotencyEntry)
+			}
+			k.entries[key] = &idempotencyEntry{done: make(chan struct{})}
+			k.mu.Unlock()
+			return true, nil
+		}
+		k.mu.Unlock()
+
+		select {
+		case <-e.done:
+			if !e.failed {
+				return false, nil
+			}
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// tryClaim is like claim, but does not wait for a request in flight with key: inFlight is true
+// instead, and the request must not be applied.
+func (k *idempotencyKeys) tryClaim(key string) (first, inFlight bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.prune(k.now())
+	e, exists := k.entries[key]
+	if !exists {
+		if k.entries == nil {
+			k.entries = make(map[string]*idempotencyEntry)
+		}
+		k.entries[key] = &idempotencyEntry{done: make(chan struct{})}
+		return true, false
+	}
+	// Failed requests are forgotten at once, so a completed entry is a successful request.
+	select {
+	case <-e.done:
+		return false, false
+	default:
+		return false, true
+	}
+}
+
+// complete records the outcome of the request claimed with key.
+func (k *idempotencyKeys) complete(key string, window time.Duration, err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	e := k.entries[key]
+	if err != nil {
+		e.failed = true
+		delete(k.entries, key)
+	} else {
+		e.expires = k.now().Add(window)
+		k.order = append(k.order, key)
+	}
+	close(e.done)
+}
+
+// prune forgets the keys expired at now. Must be called with k.mu held.
+func (k *idempotencyKeys) prune(now time.Time) {
+	for len(k.order) > 0 {
+		key := k.order[0]
+		if e := k.entries[key]; e != nil && now.Before(e.expires) {
+			return
+		}
+		delete(k.entries, key)
+		k.order = k.order[1:]
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithIdempotencyKey(t *testing.T) {
+	changes := 0
+	sc := NewStateController(
+		WithIdempotencyWindow(20*time.Millisecond),
+		WithOnStateChange(func(name string, active bool) { changes++ }),
+	)
+	sc.AddState("state1", State{})
+
+	sc.SetState("state1", true, WithIdempotencyKey("req-1"))
+	sc.Reset("state1")
+	// The retry of req-1 is skipped, so the reset stands.
+	if err := sc.SetState("state1", true, WithIdempotencyKey("req-1")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if sc.IsActive("state1") || changes != 2 {
+		t.Fatalf("Expected the retry to be skipped, got active=%v after %d changes", sc.IsActive("state1"), changes)
+	}
+
+	// After the window, the key is forgotten.
+	time.Sleep(30 * time.Millisecond)
+	sc.SetState("state1", true, WithIdempotencyKey("req-1"))
+	if !sc.IsActive("state1") {
+		t.Fatal("Expected the key to be forgotten after the window")
+	}
+}
+
+func TestWithIdempotencyKeyFailedRequest(t *testing.T) {
+	sc := NewStateController()
+
+	if err := sc.SetState("state1", true, WithIdempotencyKey("req-1")); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound, got %v", err)
+	}
+
+	// Failed requests are not remembered.
+	sc.AddState("state1", State{})
+	if err := sc.SetState("state1", true, WithIdempotencyKey("req-1")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !sc.IsActive("state1") {
+		t.Fatal("Expected the retry of a failed request to be applied")
+	}
+}
+
+func TestWithIdempotencyKeyInFlight(t *testing.T) {
+	release := make(chan struct{})
+	calls := 0
+	sc := NewStateController(WithOnStateNotExistContext(func(ctx context.Context, name string) (State, error) {
+		calls++
+		<-release
+		return State{}, nil
+	}))
+
+	first := make(chan error, 1)
+	go func() { first <- sc.SetState("state1", true, WithIdempotencyKey("req-1")) }()
+	time.Sleep(10 * time.Millisecond)
+
+	// The duplicate waits for the request in flight and gives up with its context.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := sc.SetStateCtx(ctx, "state1", true, WithIdempotencyKey("req-1")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected DeadlineExceeded, got %v", err)
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := sc.SetState("state1", true, WithIdempotencyKey("req-1")); err != nil || calls != 1 {
+		t.Fatalf("Expected the duplicate to be skipped, got %v after %d factory calls", err, calls)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"sort"
+	"time"
+)
+
+// StateInfo is a consistent snapshot of everything known about a single state.
+type StateInfo struct {
+	Name           string
+	State          State     // Configuration; State.IsActive is the effective value.
+	Requested      bool      // Value last asked for by SetState, Reset or UpdateState.
+	Quality        float64   // Quality of the request that set the current value; see WithQuality.
+	Pending        bool      // Whether a delayed transition is pending.
+	PendingTarget  bool      // Value the pending transition moves to; false unless Pending.
+	ScheduledAt    time.Time // When the pending transition was scheduled; zero unless Pending.
+	Deadline       time.Time // When the pending transition is due; zero unless Pending.
+	LastChange     time.Time // Time of the last change of the effective value; zero if never changed.
+	Changes        uint64    // Number of changes of the effective value.
+	DelaysEnabled  bool      // False while delays are disabled with SetDelaysEnabled.
+	Disabled       bool      // True while the state is disabled with DisableState.
+	Version        uint64    // Accepted writes, for SetStateVersioned; in dry-run mode, the shadow's.
+	LeaseOwner     string    // Holder of the lease set with Acquire; empty if not leased.
+	LeaseExpires   time.Time // When the lease ends; zero if not leased or leased until Release.
+	OverrideSource string    // Source of the override deciding the value; see OverrideFrom.
+	OverrideUntil  time.Time // When the override deciding the value ends; zero if not overridden.
+}
+
+// Info returns a snapshot of the named state, taken under its lock, so all fields are consistent
+// with each other.
+func (sc *StateController) Info(name string) (StateInfo, error) {
+	state := sc.lockState(name)
+	if state == nil {
+		return StateInfo{}, sc.stateError(name, ErrStateNotFound)
+	}
+	info := state.info(name, sc.now())
+	state.mu.Unlock()
+
+	return sc.withShadowVersion(info), nil
+}
+
+// info returns a snapshot of the state at now. Must be called with the state's mutex held.
+func (s *delayedState) info(name string, now time.Time) StateInfo {
+	info := StateInfo{
+		Name:          name,
+		State:         s.State,
+		Requested:     s.requested,
+		Quality:       s.quality,
+		Pending:       s.pending(),
+		LastChange:    s.lastChange,
+		Changes:       s.changes,
+		DelaysEnabled: !s.delaysDisabled,
+		Disabled:      s.disabled,
+		Version:       s.version,
+	}
+	if info.Pending {
+		info.PendingTarget = s.pendingTarget
+		info.ScheduledAt = s.scheduledAt
+		info.Deadline = s.deadline
+	}
+	if info.LeaseOwner = s.leaseHolder(now); info.LeaseOwner != "" {
+		info.LeaseExpires = s.leaseExpires
+	}
+	if o := s.overrideWinner(); o != nil {
+		info.OverrideSource = o.source
+		info.OverrideUntil = o.until
+	}
+	return info
+}
+
+// Find returns the sorted names of the states for which pred returns true, e.g. all states
+// pending deactivation within the next 30 seconds. pred is evaluated on a snapshot of each state
+// taken under its lock, but called without holding any lock, so it may call into the controller.
+func (sc *StateController) Find(pred func(name string, info StateInfo) bool) []string {
+	states := sc.loadIndex()
+
+	var names []string
+	for name := range states {
+		if info, ok := sc.snapshot(states, name); ok && pred(name, info) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
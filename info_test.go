@@ -0,0 +1,71 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInfo(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: time.Second, Tags: []string{"zone1"}})
+
+	info, err := sc.Info("state1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info.Name != "state1" || info.State.IsActive || info.Pending || info.Changes != 0 || !info.LastChange.IsZero() {
+		t.Fatalf("Expected fresh inactive state, got %+v", info)
+	}
+
+	before := time.Now()
+	sc.SetState("state1", true)
+	sc.SetState("state1", false)
+
+	info, _ = sc.Info("state1")
+	if !info.State.IsActive || info.Requested {
+		t.Fatalf("Expected effective true and requested false, got %+v", info)
+	}
+	if !info.Pending || info.PendingTarget || info.Deadline.Sub(info.ScheduledAt) != time.Second {
+		t.Fatalf("Expected pending deactivation due in one delay, got %+v", info)
+	}
+	if info.Changes != 1 || info.LastChange.Before(before) {
+		t.Fatalf("Expected one recent change, got %+v", info)
+	}
+	if len(info.State.Tags) != 1 || !info.DelaysEnabled {
+		t.Fatalf("Expected configuration in the snapshot, got %+v", info)
+	}
+
+	if _, err := sc.Info("missing"); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound, got %v", err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("soon", State{Delay: 10 * time.Second, IsActive: true})
+	sc.AddState("late", State{Delay: time.Hour, IsActive: true})
+	sc.AddState("idle", State{Delay: time.Second})
+	sc.SetState("soon", false)
+	sc.SetState("late", false)
+
+	horizon := time.Now().Add(30 * time.Second)
+	names := sc.Find(func(name string, info StateInfo) bool {
+		return info.Pending && !info.PendingTarget && info.Deadline.Before(horizon)
+	})
+	if len(names) != 1 || names[0] != "soon" {
+		t.Fatalf("Expected [soon], got %v", names)
+	}
+
+	// The predicate may call into the controller.
+	names = sc.Find(func(name string, info StateInfo) bool { return sc.IsActive(name) })
+	if len(names) != 2 || names[0] != "late" || names[1] != "soon" {
+		t.Fatalf("Expected [late soon], got %v", names)
+	}
+}
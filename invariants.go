@@ -0,0 +1,76 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrInvariantViolation is the error WithInvariantChecks panics with, wrapped with the details of
+// the violated invariant.
+var ErrInvariantViolation = errors.New("invariant violation")
+
+// checkInvariants validates the internal invariants of state after an operation, if enabled with
+// WithInvariantChecks, and panics on a violation. Must be called with the state's mutex held.
+func (sc *StateController) checkInvariants(name string, state *delayedState) {
+	if !sc.invariantChecks {
+		return
+	}
+	if err := sc.invariantError(state); err != nil {
+		panic(sc.stateError(name, err))
+	}
+}
+
+// invariantError reports the first internal invariant state violates, or nil.
+// Must be called with the state's mutex held.
+func (sc *StateController) invariantError(state *delayedState) error {
+	if state.pending() {
+		switch {
+		case state.removed:
+			return fmt.Errorf("%w: transition pending on removed state", ErrInvariantViolation)
+		case state.disabled:
+			return fmt.Errorf("%w: transition pending on disabled state", ErrInvariantViolation)
+		case !state.Unknown && state.pendingTarget == state.IsActive:
+			return fmt.Errorf("%w: transition pending towards the current value", ErrInvariantViolation)
+		case sc.ticked == nil && sc.batches == nil && state.delayedTimer == nil:
+			return fmt.Errorf("%w: transition pending without timer", ErrInvariantViolation)
+		case sc.ticked != nil && !sc.isTicked(state):
+			return fmt.Errorf("%w: transition pending without tick registration", ErrInvariantViolation)
+		case sc.ticked == nil && sc.batches != nil && !sc.isBatched(state):
+			return fmt.Errorf("%w: transition pending without timer batch", ErrInvariantViolation)
+		}
+	} else {
+		switch {
+		case state.delayedTimer != nil:
+			return fmt.Errorf("%w: timer without pending transition", ErrInvariantViolation)
+		case state.pendingCtx != nil:
+			return fmt.Errorf("%w: context of a pending transition kept after it ended", ErrInvariantViolation)
+		}
+	}
+
+	n := atomic.LoadInt32(&sc.pendingCount)
+	if n < 0 || (sc.maxPending > 0 && int(n) > sc.maxPending) {
+		return fmt.Errorf("%w: pending count %d out of range", ErrInvariantViolation, n)
+	}
+
+	for i := 1; i < len(state.outbox); i++ {
+		if prev, seq := state.outbox[i-1].event.Seq, state.outbox[i].event.Seq; seq <= prev {
+			return fmt.Errorf("%w: sequence number %d recorded after %d", ErrInvariantViolation, seq, prev)
+		}
+	}
+	return nil
+}
+
+// isTicked reports whether state is registered with the tick loop of WithTickInterval.
+func (sc *StateController) isTicked(state *delayedState) bool {
+	sc.tickMu.Lock()
+	defer sc.tickMu.Unlock()
+	_, ok := sc.ticked[state]
+	return ok
+}
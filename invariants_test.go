@@ -0,0 +1,72 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithInvariantChecks(t *testing.T) {
+	sc := NewStateController(WithInvariantChecks(true))
+	sc.AddState("state1", State{Delay: 10 * time.Millisecond})
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false)
+	sc.ExtendPending("state1", 5*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	if sc.IsActive("state1") {
+		t.Fatal("Expected state1 to deactivate")
+	}
+
+	// Corrupt the state: a timer without a pending transition.
+	state := sc.states["state1"]
+	state.mu.Lock()
+	state.delayedTimer = sc.routines.afterFunc(time.Hour, func() {})
+	state.mu.Unlock()
+
+	defer func() {
+		err, ok := recover().(error)
+		if !ok || !errors.Is(err, ErrInvariantViolation) {
+			t.Fatalf("Expected a panic with ErrInvariantViolation, got %v", err)
+		}
+	}()
+	sc.EnableState("state1")
+}
+
+func TestInvariantErrors(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: time.Hour, IsActive: true})
+	sc.SetState("state1", false)
+
+	state := sc.states["state1"]
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if err := sc.invariantError(state); err != nil {
+		t.Fatalf("Expected no violation, got %v", err)
+	}
+
+	state.removed = true
+	if err := sc.invariantError(state); !errors.Is(err, ErrInvariantViolation) {
+		t.Fatalf("Expected pending on removed state to be reported, got %v", err)
+	}
+	state.removed = false
+
+	state.IsActive = false
+	if err := sc.invariantError(state); !errors.Is(err, ErrInvariantViolation) {
+		t.Fatalf("Expected pending towards the current value to be reported, got %v", err)
+	}
+	state.IsActive = true
+
+	state.outbox = []callbackEvent{{event: StateEvent{Seq: 2}}, {event: StateEvent{Seq: 1}}}
+	if err := sc.invariantError(state); !errors.Is(err, ErrInvariantViolation) {
+		t.Fatalf("Expected decreasing sequence numbers to be reported, got %v", err)
+	}
+	state.outbox = nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"sync"
+	"time"
+)
+
+// latencyBounds are the upper bounds of the firing latency histogram buckets.
+var latencyBounds = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// LatencyHistogram is a snapshot of the delays between the deadlines of delayed transitions and
+// the moments they were actually applied. Growing latencies indicate scheduler lag.
+type LatencyHistogram struct {
+	Bounds []time.Duration // Upper bounds (inclusive) of the buckets.
+	Counts []uint64        // Number of firings per bucket; the last entry counts those above all bounds.
+	Count  uint64          // Total number of firings.
+	Sum    time.Duration   // Sum of all latencies.
+	Max    time.Duration   // Largest latency seen.
+}
+
+// latencyHistogram accumulates firing latencies.
+type latencyHistogram struct {
+	mu     sync.Mutex
+	counts [10]uint64 // len(latencyBounds) + 1
+	count  uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+func (h *latencyHistogram) observe(latency time.Duration) {
+	if latency < 0 {
+		latency = 0
+	}
+	i := 0
+	for i < len(latencyBounds) && latency > latencyBounds[i] {
+		i++
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.count++
+	h.sum += latency
+	if latency > h.max {
+		h.max = latency
+	}
+}
+
+func (h *latencyHistogram) snapshot() LatencyHistogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return LatencyHistogram{
+		Bounds: append([]time.Duration(nil), latencyBounds...),
+		Counts: append([]uint64(nil), h.counts[:]...),
+		Count:  h.count,
+		Sum:    h.sum,
+		Max:    h.max,
+	}
+}
+
+// FiringLatency returns a histogram of how late delayed transitions were applied relative to
+// their deadlines, over the lifetime of the controller. Transitions applied before their
+// deadline, e.g. by RemoveStateFlush, are not included.
+func (sc *StateController) FiringLatency() LatencyHistogram {
+	return sc.latency.snapshot()
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("output", State{})
+	engineA := WithRequestedBy(context.Background(), "engine-a")
+	engineB := WithRequestedBy(context.Background(), "engine-b")
+
+	if err := sc.Acquire("output", "engine-a", 20*time.Millisecond); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := sc.Acquire("output", "engine-b", time.Second); !errors.Is(err, ErrLeaseHeld) {
+		t.Fatalf("Expected ErrLeaseHeld, got %v", err)
+	}
+
+	if err := sc.SetStateCtx(engineA, "output", true); err != nil {
+		t.Fatalf("Expected the lease holder to set the state, got %v", err)
+	}
+	if err := sc.SetStateCtx(engineB, "output", false); !errors.Is(err, ErrLeaseHeld) {
+		t.Fatalf("Expected ErrLeaseHeld, got %v", err)
+	}
+	if err := sc.SetState("output", false); !errors.Is(err, ErrLeaseHeld) {
+		t.Fatalf("Expected ErrLeaseHeld for an anonymous request, got %v", err)
+	}
+	if info, _ := sc.Info("output"); info.LeaseOwner != "engine-a" || info.LeaseExpires.IsZero() {
+		t.Fatalf("Expected the lease of engine-a in Info, got %+v", info)
+	}
+
+	// Once expired, anybody may set the state or take the lease.
+	time.Sleep(30 * time.Millisecond)
+	if err := sc.SetStateCtx(engineB, "output", false); err != nil {
+		t.Fatalf("Expected the expired lease to be ignored, got %v", err)
+	}
+	if err := sc.Acquire("output", "engine-b", 0); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("output", State{})
+	sc.Acquire("output", "engine-a", 0)
+
+	if err := sc.Release("output", "engine-b"); !errors.Is(err, ErrLeaseHeld) {
+		t.Fatalf("Expected ErrLeaseHeld, got %v", err)
+	}
+	if err := sc.Release("output", "engine-a"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := sc.SetState("output", true); err != nil {
+		t.Fatalf("Expected the released state to be settable, got %v", err)
+	}
+}
+
+func TestAcquireControllerClock(t *testing.T) {
+	clock := &scenarioClock{now: time.Unix(1000, 0)}
+	sc := NewStateController(withClock(clock.Now))
+	defer sc.Close()
+	sc.AddState("output", State{})
+
+	sc.Acquire("output", "engine-a", time.Minute)
+	if info, _ := sc.Info("output"); !info.LeaseExpires.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatalf("Expected the lease to expire a minute after the controller clock, got %v", info.LeaseExpires)
+	}
+	if err := sc.SetState("output", true); !errors.Is(err, ErrLeaseHeld) {
+		t.Fatalf("Expected ErrLeaseHeld, got %v", err)
+	}
+
+	clock.set(clock.Now().Add(time.Minute))
+	if err := sc.SetState("output", true); err != nil {
+		t.Fatalf("Expected the lease to expire with the controller clock, got %v", err)
+	}
+}
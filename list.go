@@ -0,0 +1,170 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInvalidListOptions is returned by List for an unknown SortBy or a cursor it did not produce
+// for the same SortBy.
+var ErrInvalidListOptions = errors.New("invalid list options")
+
+// SortBy orders the states listed by List.
+type SortBy int
+
+const (
+	// SortByName orders states by name. This is the default.
+	SortByName SortBy = iota
+	// SortByLastChange orders states by the time of their last change, oldest first, then by name.
+	SortByLastChange
+)
+
+// ListOptions selects a page of states for List.
+type ListOptions struct {
+	Prefix string // Only list states whose name starts with Prefix.
+	Limit  int    // Maximum number of states per page; zero or less means no limit.
+	Cursor string // NextCursor of the previous page; empty for the first page.
+	SortBy SortBy
+}
+
+// ListPage is a page of states returned by List.
+type ListPage struct {
+	States     []StateInfo
+	NextCursor string // Cursor of the next page; empty on the last page.
+}
+
+// List returns a page of states, for controllers too large to materialize at once. The cursor
+// is the sort key of the last state returned, its name or its last change and name, so states
+// added or removed between calls do not shift the following pages, they only appear or
+// disappear at their position. With SortByLastChange, a state that changes between calls moves
+// to the end of the order: it is returned again if it was already listed, and otherwise on a
+// later page than its former position. Listing by name reuses a sorted copy of the names, which is rebuilt
+// only after states were added or removed; SortByLastChange sorts all states matching Prefix on
+// every call.
+func (sc *StateController) List(opts ListOptions) (ListPage, error) {
+	switch opts.SortBy {
+	case SortByName:
+		return sc.listByName(opts), nil
+	case SortByLastChange:
+		return sc.listByLastChange(opts)
+	default:
+		return ListPage{}, fmt.Errorf("%w: unknown SortBy %d", ErrInvalidListOptions, int(opts.SortBy))
+	}
+}
+
+func (sc *StateController) listByName(opts ListOptions) ListPage {
+	states := sc.loadIndex()
+	names := sc.sortedNames()
+
+	start := opts.Prefix
+	if opts.Cursor > start {
+		start = opts.Cursor
+	}
+	i := sort.SearchStrings(names, start)
+	if i < len(names) && names[i] == opts.Cursor {
+		i++
+	}
+
+	var page ListPage
+	for ; i < len(names) && strings.HasPrefix(names[i], opts.Prefix); i++ {
+		if opts.Limit > 0 && len(page.States) == opts.Limit {
+			page.NextCursor = page.States[len(page.States)-1].Name
+			break
+		}
+		if info, ok := sc.snapshot(states, names[i]); ok {
+			page.States = append(page.States, info)
+		}
+	}
+	return page
+}
+
+func (sc *StateController) listByLastChange(opts ListOptions) (ListPage, error) {
+	var after StateInfo
+	if opts.Cursor != "" {
+		nanos, name, ok := strings.Cut(opts.Cursor, "/")
+		if !ok {
+			return ListPage{}, fmt.Errorf("%w: cursor %q", ErrInvalidListOptions, opts.Cursor)
+		}
+		after.Name = name
+		if nanos != "" {
+			unix, err := strconv.ParseInt(nanos, 10, 64)
+			if err != nil {
+				return ListPage{}, fmt.Errorf("%w: cursor %q", ErrInvalidListOptions, opts.Cursor)
+			}
+			after.LastChange = time.Unix(0, unix)
+		}
+	}
+
+	var infos []StateInfo
+	states := sc.loadIndex()
+	for name := range states {
+		if !strings.HasPrefix(name, opts.Prefix) {
+			continue
+		}
+		if info, ok := sc.snapshot(states, name); ok && (opts.Cursor == "" || changedBefore(after, info)) {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return changedBefore(infos[i], infos[j]) })
+
+	page := ListPage{States: infos}
+	if opts.Limit > 0 && len(infos) > opts.Limit {
+		page.States = infos[:opts.Limit]
+		last := page.States[opts.Limit-1]
+		page.NextCursor = "/" + last.Name
+		if !last.LastChange.IsZero() {
+			page.NextCursor = strconv.FormatInt(last.LastChange.UnixNano(), 10) + page.NextCursor
+		}
+	}
+	return page, nil
+}
+
+// changedBefore orders a before b for SortByLastChange.
+func changedBefore(a, b StateInfo) bool {
+	if !a.LastChange.Equal(b.LastChange) {
+		return a.LastChange.Before(b.LastChange)
+	}
+	return a.Name < b.Name
+}
+
+// snapshot returns the StateInfo of the named state in states, unless it was removed meanwhile.
+func (sc *StateController) snapshot(states map[string]*delayedState, name string) (StateInfo, bool) {
+	state := states[name]
+	if state == nil {
+		return StateInfo{}, false
+	}
+	state.mu.Lock()
+	if state.removed {
+		state.mu.Unlock()
+		return StateInfo{}, false
+	}
+	info := state.info(name, sc.now())
+	state.mu.Unlock()
+	return sc.withShadowVersion(info), true
+}
+
+// sortedNames returns the sorted names of the current index, rebuilt only after it changed.
+// The returned slice must not be modified.
+func (sc *StateController) sortedNames() []string {
+	gen := atomic.LoadUint64(&sc.indexGen)
+
+	sc.sortedMu.Lock()
+	defer sc.sortedMu.Unlock()
+	if sc.sorted == nil || sc.sortedGen != gen {
+		names := sc.StateNames()
+		sort.Strings(names)
+		sc.sorted, sc.sortedGen = names, gen
+	}
+	return sc.sorted
+}
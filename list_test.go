@@ -0,0 +1,76 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func listNames(page ListPage) []string {
+	var names []string
+	for _, info := range page.States {
+		names = append(names, info.Name)
+	}
+	return names
+}
+
+func TestListByName(t *testing.T) {
+	sc := NewStateController()
+	for _, name := range []string{"door/3", "door/1", "window/1", "door/2", "door/4"} {
+		sc.AddState(name, State{})
+	}
+
+	page, err := sc.List(ListOptions{Prefix: "door/", Limit: 2})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := listNames(page); !equalNames(got, []string{"door/1", "door/2"}) || page.NextCursor == "" {
+		t.Fatalf("Expected the first page, got %v, cursor %q", got, page.NextCursor)
+	}
+
+	// Changes before the cursor do not shift the next page.
+	sc.RemoveState("door/1")
+	sc.AddState("door/0", State{})
+
+	page, _ = sc.List(ListOptions{Prefix: "door/", Limit: 2, Cursor: page.NextCursor})
+	if got := listNames(page); !equalNames(got, []string{"door/3", "door/4"}) || page.NextCursor != "" {
+		t.Fatalf("Expected the last page, got %v, cursor %q", got, page.NextCursor)
+	}
+}
+
+func TestListByLastChange(t *testing.T) {
+	sc := NewStateController()
+	for _, name := range []string{"a", "b", "c"} {
+		sc.AddState(name, State{})
+	}
+	sc.SetState("c", true)
+	time.Sleep(time.Millisecond)
+	sc.SetState("a", true)
+
+	// b never changed, so it comes first.
+	var got []string
+	cursor := ""
+	for i := 0; i < 3; i++ {
+		page, err := sc.List(ListOptions{SortBy: SortByLastChange, Limit: 1, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		got = append(got, listNames(page)...)
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if !equalNames(got, []string{"b", "c", "a"}) || cursor != "" {
+		t.Fatalf("Expected pages [b] [c] [a], got %v, cursor %q", got, cursor)
+	}
+
+	if _, err := sc.List(ListOptions{SortBy: SortByLastChange, Cursor: "bogus"}); !errors.Is(err, ErrInvalidListOptions) {
+		t.Fatalf("Expected ErrInvalidListOptions, got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+// Package loadgen drives a delayedstate.StateController with a synthetic mix of SetState and
+// IsActive calls and reports throughput, latency and allocations, so controllers can be sized
+// for the hardware they run on.
+package loadgen
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cod3-wav3/delayedstate"
+)
+
+// sampleEvery is how often an operation's latency is measured, to keep the overhead of reading
+// the clock out of the throughput.
+const sampleEvery = 16
+
+// Config describes the load to generate. Zero-value fields take their defaults.
+type Config struct {
+	States      int                   // Number of states; 1000 by default.
+	Workers     int                   // Number of concurrent callers; runtime.GOMAXPROCS(0) by default.
+	Duration    time.Duration         // How long to generate load; one second by default.
+	SetWeight   int                   // Relative share of SetState calls; 1 by default, unless ReadWeight is set.
+	ReadWeight  int                   // Relative share of IsActive calls; 1 by default, unless SetWeight is set.
+	Delay       time.Duration         // Delay of the states; zero makes all transitions immediate.
+	ActiveRatio float64               // Share of SetState calls activating a state; 0.5 by default.
+	Options     []delayedstate.Option // Options of the controller under load, e.g. WithAsyncCallbacks.
+	Seed        int64                 // Seed of the random operation mix; 1 by default.
+}
+
+// Latency summarizes the measured latencies of one kind of operation.
+type Latency struct {
+	P50 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+// Result is the outcome of Run.
+type Result struct {
+	Sets        uint64        // Number of SetState calls.
+	Reads       uint64        // Number of IsActive calls.
+	Elapsed     time.Duration // Time the load was generated.
+	Throughput  float64       // Calls per second.
+	SetLatency  Latency       // Latency of SetState calls, from a sample.
+	ReadLatency Latency       // Latency of IsActive calls, from a sample.
+	AllocsPerOp float64       // Heap allocations per call.
+	BytesPerOp  float64       // Heap bytes allocated per call.
+}
+
+// String formats the result as a short report.
+func (r Result) String() string {
+	return fmt.Sprintf("%d calls in %s (%.0f/s), %.1f allocs/op, %.0f B/op\n"+
+		"  SetState: %d calls, p50 %s, p99 %s, max %s\n"+
+		"  IsActive: %d calls, p50 %s, p99 %s, max %s",
+		r.Sets+r.Reads, r.Elapsed, r.Throughput, r.AllocsPerOp, r.BytesPerOp,
+		r.Sets, r.SetLatency.P50, r.SetLatency.P99, r.SetLatency.Max,
+		r.Reads, r.ReadLatency.P50, r.ReadLatency.P99, r.ReadLatency.Max)
+}
+
+// Run generates the configured load against a new controller, closes it and reports the result.
+func Run(cfg Config) Result {
+	cfg = cfg.withDefaults()
+
+	names := make([]string, cfg.States)
+	states := make(map[string]delayedstate.State, cfg.States)
+	for i := range names {
+		names[i] = "state-" + strconv.Itoa(i)
+		states[names[i]] = delayedstate.State{Delay: cfg.Delay}
+	}
+	opts := append(cfg.Options[:len(cfg.Options):len(cfg.Options)], delayedstate.WithInitializeStates(states))
+	sc := delayedstate.NewStateController(opts...)
+	defer sc.Close()
+
+	var (
+		stop    int32
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		sets    uint64
+		reads   uint64
+		setLat  []time.Duration
+		readLat []time.Duration
+	)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			var (
+				n, s, r  uint64
+				sl, rl   []time.Duration
+				total    = cfg.SetWeight + cfg.ReadWeight
+				measured time.Time
+			)
+			for atomic.LoadInt32(&stop) == 0 {
+				name := names[rnd.Intn(len(names))]
+				isSet := rnd.Intn(total) < cfg.SetWeight
+				active := rnd.Float64() < cfg.ActiveRatio
+				sample := n%sampleEvery == 0
+				if sample {
+					measured = time.Now()
+				}
+				if isSet {
+					sc.SetState(name, active)
+					s++
+				} else {
+					sc.IsActive(name)
+					r++
+				}
+				if sample {
+					if isSet {
+						sl = append(sl, time.Since(measured))
+					} else {
+						rl = append(rl, time.Since(measured))
+					}
+				}
+				n++
+			}
+			mu.Lock()
+			sets += s
+			reads += r
+			setLat = append(setLat, sl...)
+			readLat = append(readLat, rl...)
+			mu.Unlock()
+		}(cfg.Seed + int64(w))
+	}
+
+	time.Sleep(cfg.Duration)
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	res := Result{
+		Sets:        sets,
+		Reads:       reads,
+		Elapsed:     elapsed,
+		SetLatency:  summarize(setLat),
+		ReadLatency: summarize(readLat),
+	}
+	if ops := sets + reads; ops > 0 {
+		res.Throughput = float64(ops) / elapsed.Seconds()
+		// Includes the latency samples, which take a few bytes per sampled call.
+		res.AllocsPerOp = float64(after.Mallocs-before.Mallocs) / float64(ops)
+		res.BytesPerOp = float64(after.TotalAlloc-before.TotalAlloc) / float64(ops)
+	}
+	return res
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.States <= 0 {
+		cfg.States = 1000
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.GOMAXPROCS(0)
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = time.Second
+	}
+	if cfg.SetWeight < 0 {
+		cfg.SetWeight = 0
+	}
+	if cfg.ReadWeight < 0 {
+		cfg.ReadWeight = 0
+	}
+	if cfg.SetWeight == 0 && cfg.ReadWeight == 0 {
+		cfg.SetWeight, cfg.ReadWeight = 1, 1
+	}
+	if cfg.ActiveRatio <= 0 {
+		cfg.ActiveRatio = 0.5
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = 1
+	}
+	return cfg
+}
+
+// summarize returns the percentiles of samples, sorting them in place.
+func summarize(samples []time.Duration) Latency {
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return Latency{
+		P50: samples[len(samples)*50/100],
+		P99: samples[len(samples)*99/100],
+		Max: samples[len(samples)-1],
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package loadgen
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cod3-wav3/delayedstate"
+)
+
+func TestRun(t *testing.T) {
+	res := Run(Config{
+		States:   100,
+		Workers:  2,
+		Duration: 50 * time.Millisecond,
+		Delay:    time.Millisecond,
+		Options:  []delayedstate.Option{delayedstate.WithSuppressNoops(true)},
+	})
+
+	if res.Sets == 0 || res.Reads == 0 {
+		t.Fatalf("Expected both SetState and IsActive calls, got %+v", res)
+	}
+	if res.Throughput <= 0 || res.SetLatency.Max < res.SetLatency.P50 || res.ReadLatency.P99 < res.ReadLatency.P50 {
+		t.Fatalf("Expected consistent measurements, got %+v", res)
+	}
+	if !strings.Contains(res.String(), "SetState: ") {
+		t.Fatalf("Unexpected report %q", res.String())
+	}
+}
+
+func TestRunReadOnly(t *testing.T) {
+	res := Run(Config{States: 10, Workers: 1, Duration: 10 * time.Millisecond, ReadWeight: 1})
+	if res.Sets != 0 || res.Reads == 0 {
+		t.Fatalf("Expected only IsActive calls, got %+v", res)
+	}
+}
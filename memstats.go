@@ -0,0 +1,119 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Rough per-entry overheads not visible to unsafe.Sizeof.
+const (
+	mapEntryOverhead = 48 // Key, value pointer and bucket bookkeeping of a map entry.
+	timerOverhead    = 64 // Runtime timer and the callback closure of a time.AfterFunc timer.
+)
+
+// MemStats is an estimate of the memory held by a controller. It counts the controller's own data
+// structures, not memory shared with the caller such as contexts, and is meant for budgeting
+// rather than exact accounting.
+type MemStats struct {
+	Controller    string // Name of the controller set with WithName, if any.
+	States        int    // Number of states.
+	Pending       int    // Number of pending delayed transitions.
+	StateBytes    uint64 // States, including names, tags and index entries.
+	TimerBytes    uint64 // Timers of pending transitions.
+	BufferBytes   uint64 // Undelivered callbacks, subscription buffers and series of WithSeries.
+	TotalBytes    uint64 // Sum of the above.
+	BytesPerState uint64 // TotalBytes divided by States; zero without states.
+}
+
+// MemStats returns an estimate of the memory held by the controller.
+func (sc *StateController) MemStats() MemStats {
+	m := MemStats{Controller: sc.name}
+	stateSize := uint64(unsafe.Sizeof(delayedState{}))
+	timerSize := uint64(unsafe.Sizeof(time.Timer{})) + timerOverhead
+	eventSize := uint64(unsafe.Sizeof(callbackEvent{}))
+	changeSize := uint64(unsafe.Sizeof(StateEvent{}))
+
+	for name, state := range sc.loadIndex() {
+		state.mu.Lock()
+		m.States++
+		m.StateBytes += stateSize + mapEntryOverhead + uint64(len(name))
+		for _, tag := range state.Tags {
+			m.StateBytes += uint64(unsafe.Sizeof(tag)) + uint64(len(tag))
+		}
+		if state.pending() {
+			m.Pending++
+			if state.delayedTimer != nil {
+				m.TimerBytes += timerSize
+			}
+		}
+		m.BufferBytes += uint64(cap(state.outbox)) * eventSize
+		m.BufferBytes += uint64(cap(state.series)) * uint64(unsafe.Sizeof(Sample{}))
+		state.mu.Unlock()
+	}
+
+	if sc.ticked != nil {
+		sc.tickMu.Lock()
+		m.TimerBytes += uint64(len(sc.ticked)) * mapEntryOverhead
+		sc.tickMu.Unlock()
+	}
+	sc.batchMu.Lock()
+	for _, b := range sc.batches {
+		m.TimerBytes += timerSize + mapEntryOverhead + uint64(len(b.states))*mapEntryOverhead
+	}
+	sc.batchMu.Unlock()
+
+	for _, d := range sc.dispatchers {
+		d.mu.Lock()
+		m.BufferBytes += uint64(cap(d.queue)) * eventSize
+		d.mu.Unlock()
+	}
+
+	sc.subsMu.RLock()
+	for c := range sc.consumers {
+		c.mu.Lock()
+		m.BufferBytes += uint64(cap(c.buf)+len(c.held)) * changeSize
+		c.mu.Unlock()
+	}
+	sc.subsMu.RUnlock()
+
+	m.TotalBytes = m.StateBytes + m.TimerBytes + m.BufferBytes
+	if m.States > 0 {
+		m.BytesPerState = m.TotalBytes / uint64(m.States)
+	}
+	return m
+}
+
+// expvarMu serializes PublishExpvar, so checking and publishing a name cannot race.
+var expvarMu sync.Mutex
+
+// PublishExpvar publishes the controller's MemStats as the expvar variable name, computed on
+// each read. If name is empty, the controller's name is used. Returns an error if neither is
+// set or the name is already in use; expvar variables cannot be unpublished.
+func (sc *StateController) PublishExpvar(name string) error {
+	if name == "" {
+		name = sc.name
+	}
+	if name == "" {
+		return errors.New("expvar name is empty")
+	}
+
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("expvar %q already in use", name)
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return sc.MemStats()
+	}))
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemStats(t *testing.T) {
+	sc := NewStateController()
+	empty := sc.MemStats()
+	if empty.States != 0 || empty.TotalBytes != 0 || empty.BytesPerState != 0 {
+		t.Fatalf("Expected empty stats, got %+v", empty)
+	}
+
+	sc.AddState("state1", State{Delay: time.Second, Tags: []string{"zone1"}})
+	sc.AddState("state2", State{})
+	sc.SetState("state1", true)
+	sc.SetState("state1", false)
+
+	m := sc.MemStats()
+	if m.States != 2 || m.Pending != 1 {
+		t.Fatalf("Expected 2 states, 1 pending, got %+v", m)
+	}
+	if m.StateBytes == 0 || m.TimerBytes == 0 {
+		t.Fatalf("Expected non-zero state and timer estimates, got %+v", m)
+	}
+	if m.TotalBytes != m.StateBytes+m.TimerBytes+m.BufferBytes || m.BytesPerState != m.TotalBytes/2 {
+		t.Fatalf("Expected consistent totals, got %+v", m)
+	}
+}
+
+func TestPublishExpvar(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{})
+	// expvar variables live for the whole process, so every run needs a new name.
+	name := fmt.Sprintf("delayedstate_test_memstats_%d", time.Now().UnixNano())
+	if err := sc.PublishExpvar(name); err != nil {
+		t.Fatalf("Expected the variable to be published, got %v", err)
+	}
+	if err := sc.PublishExpvar(name); err == nil {
+		t.Fatal("Expected an error for a name already in use")
+	}
+	if err := sc.PublishExpvar(""); err == nil {
+		t.Fatal("Expected an error for an unnamed controller")
+	}
+
+	v := expvar.Get(name)
+	if v == nil {
+		t.Fatal("Expected published variable")
+	}
+	var m MemStats
+	if err := json.Unmarshal([]byte(v.String()), &m); err != nil {
+		t.Fatalf("Expected JSON, got %v", err)
+	}
+	if m.States != 1 {
+		t.Fatalf("Expected 1 state, got %+v", m)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !chaos
+
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"time"
+)
+
+// chaos is empty without the chaos build tag: WithChaos does not exist, and no faults are
+// injected.
+type chaos struct{}
+
+// delivery never delays or drops a change.
+func (*chaos) delivery() (time.Duration, bool) {
+	return 0, false
+}
+
+// jitter never delays a timer.
+func (*chaos) jitter() time.Duration {
+	return 0
+}
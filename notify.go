@@ -0,0 +1,218 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// defaultNotifyBuffer is the number of events held for a slow notifier if NotifyRoute.Buffer is
+// not set.
+const defaultNotifyBuffer = 64
+
+// defaultWebhookClient posts the events of a WebhookNotifier without Client, so that a hung
+// endpoint cannot block its notifier forever.
+var defaultWebhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// Notifier delivers state events to an alert destination, e.g. a webhook or a message broker.
+type Notifier interface {
+	Notify(ctx context.Context, ev StateEvent) error
+}
+
+// NotifierFunc adapts a function to the Notifier interface.
+type NotifierFunc func(ctx context.Context, ev StateEvent) error
+
+// Notify calls f(ctx, ev).
+func (f NotifierFunc) Notify(ctx context.Context, ev StateEvent) error {
+	return f(ctx, ev)
+}
+
+// NotifyRoute configures the delivery of events to a notifier; see AddNotifier.
+type NotifyRoute struct {
+	Filter  SubscribeFilter                // Events delivered to the notifier; the zero value delivers all.
+	Buffer  int                            // Events held for a slow notifier, beyond which the oldest are dropped; 64 if zero.
+	Retries int                            // Additional attempts after Notify failed.
+	Backoff time.Duration                  // Wait before the first retry, doubled for each further one.
+	OnError func(ev StateEvent, err error) // Called when an event could not be delivered, if set.
+}
+
+// AddNotifier delivers every subsequent state event matching the route's filter to n, in order,
+// on a goroutine of its own, so a slow or failing destination does not hold up the controller or
+// other notifiers. A failed Notify is retried as configured by the route; events it still could
+// not deliver are passed to the route's OnError. Close the returned subscription to stop the
+// delivery; Close of the controller stops it as well. Either cancels the context passed to
+// Notify, so a notifier must return once it is done. Only the subscription's Dropped and Close
+// methods may be used; its channel is read by the notifier's goroutine.
+func (sc *StateController) AddNotifier(n Notifier, route NotifyRoute) *Subscription {
+	buffer := route.Buffer
+	if buffer == 0 {
+		buffer = defaultNotifyBuffer
+	}
+	sub := sc.Subscribe(buffer, WithFilter(route.Filter))
+	ctx, cancel := context.WithCancel(context.Background())
+	sc.routines.goTracked(func() {
+		select {
+		case <-sub.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	})
+	sc.routines.goTracked(func() {
+		defer cancel()
+		for ev := range sub.C() {
+			if err := route.deliver(ctx, n, ev, sub.closed); err != nil && route.OnError != nil {
+				route.OnError(ev, err)
+			}
+		}
+	})
+	return sub
+}
+
+// deliver passes ev to n with ctx, retrying as configured, until it succeeds or closed is
+// closed. Returns the error of the last attempt.
+func (r NotifyRoute) deliver(ctx context.Context, n Notifier, ev StateEvent, closed <-chan struct{}) error {
+	backoff := r.Backoff
+	for attempt := 0; ; attempt++ {
+		err := n.Notify(ctx, ev)
+		if err == nil || attempt >= r.Retries {
+			return err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-closed:
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// notification is the JSON encoding of a StateEvent sent by the built-in notifiers.
+type notification struct {
+	Controller  string    `json:"controller,omitempty"`
+	Seq         uint64    `json:"seq"`
+	Name        string    `json:"name"`
+	Old         bool      `json:"old"`
+	Active      bool      `json:"active"`
+	Unknown     bool      `json:"unknown,omitempty"`
+	Suppressed  bool      `json:"suppressed,omitempty"`
+	Cause       string    `json:"cause"`
+	RequestedBy string    `json:"requestedBy,omitempty"`
+	Time        time.Time `json:"time"`
+	Tags        []string  `json:"tags,omitempty"`
+}
+
+func encodeNotification(ev StateEvent) ([]byte, error) {
+	return json.Marshal(notification{
+		Controller:  ev.Controller,
+		Seq:         ev.Seq,
+		Name:        ev.Name,
+		Old:         ev.Old,
+		Active:      ev.Active,
+		Unknown:     ev.Unknown,
+		Suppressed:  ev.Suppressed,
+		Cause:       ev.Cause.String(),
+		RequestedBy: ev.RequestedBy,
+		Time:        ev.Time,
+		Tags:        ev.Tags,
+	})
+}
+
+// WebhookNotifier posts each event as a JSON object to URL. A response status other than 2xx is
+// an error.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client // A client with a timeout of 10 seconds if nil.
+	Header http.Header  // Additional request headers, e.g. for authentication.
+}
+
+// Notify posts ev to the webhook.
+func (w *WebhookNotifier) Notify(ctx context.Context, ev StateEvent) error {
+	body, err := encodeNotification(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range w.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = defaultWebhookClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s: %s", w.URL, resp.Status)
+	}
+	return nil
+}
+
+// MQTTPublisher publishes a message to an MQTT topic. It is implemented by a thin adapter around
+// the MQTT client of the application's choice.
+type MQTTPublisher interface {
+	Publish(topic string, payload []byte) error
+}
+
+// MQTTNotifier publishes each event as a JSON object to the topic Topic/<state name>.
+type MQTTNotifier struct {
+	Publisher MQTTPublisher
+	Topic     string
+}
+
+// Notify publishes ev.
+func (m *MQTTNotifier) Notify(ctx context.Context, ev StateEvent) error {
+	payload, err := encodeNotification(ev)
+	if err != nil {
+		return err
+	}
+	return m.Publisher.Publish(m.Topic+"/"+ev.Name, payload)
+}
+
+// LogNotifier writes a line per event to Logger, e.g. "state light: false -> true (explicit)".
+// An event of a state becoming unknown is written as "unknown", one held back by Suppress as
+// "suppressed", since their values did not change.
+type LogNotifier struct {
+	Logger *log.Logger // The standard logger if nil.
+}
+
+// Notify logs ev.
+func (l *LogNotifier) Notify(ctx context.Context, ev StateEvent) error {
+	var msg string
+	switch {
+	case ev.Unknown:
+		msg = fmt.Sprintf("state %s: %t -> unknown (%s)", ev.Name, ev.Old, ev.Cause)
+	case ev.Suppressed:
+		msg = fmt.Sprintf("state %s: %t -> %t suppressed (%s)", ev.Name, ev.Old, ev.Active, ev.Cause)
+	default:
+		msg = fmt.Sprintf("state %s: %t -> %t (%s)", ev.Name, ev.Old, ev.Active, ev.Cause)
+	}
+	if ev.Controller != "" {
+		msg = ev.Controller + ": " + msg
+	}
+	if l.Logger == nil {
+		log.Print(msg)
+	} else {
+		l.Logger.Print(msg)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakePublisher struct {
+	mu       sync.Mutex
+	topics   []string
+	payloads [][]byte
+}
+
+func (p *fakePublisher) Publish(topic string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.topics = append(p.topics, topic)
+	p.payloads = append(p.payloads, payload)
+	return nil
+}
+
+func TestAddNotifier(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("pump", State{Tags: []string{"alerts"}})
+	sc.AddState("light", State{})
+
+	var (
+		mu       sync.Mutex
+		attempts int
+		failed   []string
+	)
+	flaky := NotifierFunc(func(ctx context.Context, ev StateEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+	failing := NotifierFunc(func(ctx context.Context, ev StateEvent) error { return errors.New("down") })
+	onError := func(ev StateEvent, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, ev.Name)
+	}
+	pub := &fakePublisher{}
+
+	sc.AddNotifier(flaky, NotifyRoute{Filter: SubscribeFilter{Tags: []string{"alerts"}}, Retries: 2, Backoff: time.Millisecond})
+	sc.AddNotifier(failing, NotifyRoute{Retries: 1, OnError: onError})
+	sc.AddNotifier(&MQTTNotifier{Publisher: pub, Topic: "home"}, NotifyRoute{Filter: SubscribeFilter{Tags: []string{"alerts"}}})
+
+	sc.ForceState("pump", true)
+	sc.ForceState("light", true)
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		pub.mu.Lock()
+		done := attempts == 3 && len(failed) == 2 && len(pub.topics) == 1
+		pub.mu.Unlock()
+		mu.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	sc.Close() // Waits for the notifiers.
+
+	if attempts != 3 {
+		t.Fatalf("Expected the event to be delivered on the third attempt, got %d attempts", attempts)
+	}
+	if len(failed) != 2 || failed[0] != "pump" || failed[1] != "light" {
+		t.Fatalf("Expected both events to be reported as failed, got %v", failed)
+	}
+	if len(pub.topics) != 1 || pub.topics[0] != "home/pump" {
+		t.Fatalf("Expected one message for pump, got %v", pub.topics)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(pub.payloads[0], &msg); err != nil || msg["active"] != true || msg["cause"] != "forced" {
+		t.Fatalf("Unexpected payload %s (%v)", pub.payloads[0], err)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	ev := StateEvent{Name: "pump", Active: true, Cause: CauseTimer}
+	w := &WebhookNotifier{URL: srv.URL, Header: http.Header{"Authorization": {"Bearer token"}}}
+	if err := w.Notify(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "pump" || !got.Active || got.Cause != "timer" {
+		t.Fatalf("Unexpected notification %+v", got)
+	}
+
+	w.Header = nil
+	if err := w.Notify(context.Background(), ev); err == nil {
+		t.Fatal("Expected an error for a rejected request")
+	}
+}
+
+func TestLogNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	l := &LogNotifier{Logger: log.New(&buf, "", 0)}
+	l.Notify(context.Background(), StateEvent{Controller: "hall", Name: "light", Active: true})
+	if got := strings.TrimSpace(buf.String()); got != "hall: state light: false -> true (explicit)" {
+		t.Fatalf("Unexpected log line %q", got)
+	}
+
+	buf.Reset()
+	l.Notify(context.Background(), StateEvent{Name: "light", Old: true, Active: true, Unknown: true})
+	l.Notify(context.Background(), StateEvent{Name: "light", Active: true, Suppressed: true})
+	want := "state light: true -> unknown (explicit)\nstate light: false -> true suppressed (explicit)"
+	if got := strings.TrimSpace(buf.String()); got != want {
+		t.Fatalf("Unexpected log lines %q", got)
+	}
+}
+
+func TestAddNotifierCloseCancelsNotify(t *testing.T) {
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer srv.Close()
+	defer close(hang)
+
+	sc := NewStateController(WithInitializeStates(map[string]State{"pump": {}}))
+	// Without a timeout of its own, only the cancelled context ends the request.
+	sc.AddNotifier(&WebhookNotifier{URL: srv.URL, Client: &http.Client{}}, NotifyRoute{})
+	sc.SetState("pump", true)
+	time.Sleep(20 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		sc.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Close to return while the webhook hangs")
+	}
+}
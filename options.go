@@ -6,23 +6,394 @@
 
 package delayedstate
 
+import (
+	"context"
+	"time"
+)
+
 type Option func(*StateController)
 
-// WithOnStateNotExist sets the callback function to be github.com/fsnotify/fsnotifycalled when a state does not exist.
+// SetOption configures a single SetState call.
+type SetOption func(*setOptions)
+
+type setOptions struct {
+	factory    StateFactory
+	quality    float64
+	hasQuality bool
+	priority   Priority
+
+	idempotencyKey string
+}
+
+// WithOnStateNotExist sets the callback function to be called when a state does not exist.
 func WithOnStateNotExist(cb func(name string) (State, error)) Option {
 	return func(sc *StateController) {
-		sc.onStateNotExist = cb
+		if cb == nil {
+			sc.onStateNotExist = nil
+			return
+		}
+		sc.onStateNotExist = func(_ context.Context, name string) (State, error) {
+			return cb(name)
+		}
+	}
+}
+
+// WithOnStateNotExistContext sets a context-aware StateFactory to be called when a state does not exist.
+func WithOnStateNotExistContext(factory StateFactory) Option {
+	return func(sc *StateController) {
+		sc.onStateNotExist = factory
+	}
+}
+
+// WithStateFactory overrides the controller-wide onStateNotExist callback for a single SetState call.
+func WithStateFactory(factory StateFactory) SetOption {
+	return func(o *setOptions) {
+		o.factory = factory
+	}
+}
+
+// WithQuality attaches a quality or confidence value, by convention in [0, 1], to a SetState
+// call, e.g. to tell a flaky sensor from a wired contact. It is reported as StateEvent.Quality
+// for the changes the call causes, including its delayed transition, and as StateInfo.Quality
+// once the requested value is in effect. Calls without it have quality 1.
+func WithQuality(quality float64) SetOption {
+	return func(o *setOptions) {
+		o.quality = quality
+		o.hasQuality = true
+	}
+}
+
+// WithPriority sets the priority of the changes a SetState call causes, including its delayed
+// transition, e.g. PriorityCritical for emergency signals that must propagate under load. It is
+// reported as StateEvent.Priority. Calls without it have PriorityNormal.
+func WithPriority(priority Priority) SetOption {
+	return func(o *setOptions) {
+		o.priority = priority
+	}
+}
+
+// WithIdempotencyKey makes SetState skip the call if a call with the same key succeeded within
+// the idempotency window (see WithIdempotencyWindow) and return nil, e.g. for requests retried by
+// at-least-once transports. A call made while another with its key is in flight waits for its
+// outcome; see TrySetState for calls that must not wait. Failed calls are not remembered, so
+// they can be retried. Keys are controller-wide and shared by SetState, TrySetState and
+// SetStateVersioned.
+func WithIdempotencyKey(key string) SetOption {
+	return func(o *setOptions) {
+		o.idempotencyKey = key
+	}
+}
+
+func newSetOptions(opts ...SetOption) setOptions {
+	var o setOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// context returns ctx carrying the per-call values of o that are reported in StateEvent.
+func (o setOptions) context(ctx context.Context) context.Context {
+	if o.hasQuality {
+		ctx = withQuality(ctx, o.quality)
+	}
+	if o.priority != PriorityNormal {
+		ctx = withPriority(ctx, o.priority)
+	}
+	return ctx
+}
+
+// WithName names the controller, so that applications running several controllers can tell
+// their outputs apart. The name prefixes errors and is included in StateEvent and MemStats.
+func WithName(name string) Option {
+	return func(sc *StateController) {
+		sc.name = name
 	}
 }
 
 // WithOnStateChange sets the callback function to be called when a state's active value changes.
 func WithOnStateChange(cb StateChangeCallback) Option {
+	return func(sc *StateController) {
+		if cb == nil {
+			sc.onStateChange = nil
+			return
+		}
+		sc.onStateChange = func(_ context.Context, ev StateEvent) {
+			if !ev.Unknown && !ev.Suppressed {
+				cb(ev.Name, ev.Active)
+			}
+		}
+	}
+}
+
+// WithOnStateChangeContext sets a context-aware callback to be called when a state's active value changes.
+// For changes caused by SetStateCtx, the callback receives the caller's context.
+func WithOnStateChangeContext(cb StateChangeContextCallback) Option {
+	return func(sc *StateController) {
+		if cb == nil {
+			sc.onStateChange = nil
+			return
+		}
+		sc.onStateChange = func(ctx context.Context, ev StateEvent) {
+			if !ev.Unknown && !ev.Suppressed {
+				cb(ctx, ev.Name, ev.Active)
+			}
+		}
+	}
+}
+
+// WithOnStateEvent sets a callback receiving the full StateEvent of each change, including its
+// previous value, cause and requester. It replaces any WithOnStateChange callback.
+func WithOnStateEvent(cb StateEventCallback) Option {
 	return func(sc *StateController) {
 		sc.onStateChange = cb
 	}
 }
 
-// WithInitializeStates initializes the StateController with the provided states.
+// WithAsyncCallbacks makes onStateChange callbacks run on a background goroutine, fed by a
+// bounded queue of queueSize entries; policy decides what happens when the queue is full.
+// Callbacks of a state are always delivered in transition order. Call Close to stop the goroutine.
+// A queueSize below 1 keeps callbacks synchronous.
+func WithAsyncCallbacks(queueSize int, policy OverflowPolicy) Option {
+	return func(sc *StateController) {
+		sc.asyncQueueSize = queueSize
+		sc.asyncOverflow = policy
+	}
+}
+
+// WithCallbackWorkers spreads async callbacks over n background goroutines, each with its own
+// queue as configured by WithAsyncCallbacks. All callbacks of one state are handled by the same
+// worker, so they keep their transition order, while different states are delivered concurrently.
+// Has no effect without WithAsyncCallbacks.
+func WithCallbackWorkers(n int) Option {
+	return func(sc *StateController) {
+		sc.asyncWorkers = n
+	}
+}
+
+// WithSuppressNoops makes SetState a no-op when it requests the same value as the previous
+// request for that state: pending timers are neither restarted nor cancelled and no events are
+// emitted, regardless of the state's PendingPolicy. The previous request is the last SetState,
+// Reset or UpdateState value, or the initial IsActive value of the state.
+func WithSuppressNoops(enabled bool) Option {
+	return func(sc *StateController) {
+		sc.suppressNoops = enabled
+	}
+}
+
+// WithOnBypassChange sets a callback fired whenever SetBypassAll turns the global delay bypass
+// on or off, so observers know that delays are (no longer) applied.
+func WithOnBypassChange(callback func(bypass bool)) Option {
+	return func(sc *StateController) {
+		sc.onBypassChange = callback
+	}
+}
+
+// WithCancelOnOpposite controls what an opposing SetState does while a delayed transition is
+// pending. If enabled (the default), it cancels the pending transition. If disabled, the pending
+// transition is treated as committed: it fires at its deadline, and the opposing request is
+// applied right after, as if it had been made at that moment (including its own delay, if any).
+func WithCancelOnOpposite(enabled bool) Option {
+	return func(sc *StateController) {
+		sc.keepPendingOnOpposite = !enabled
+	}
+}
+
+// WithMaxPending caps the number of delayed transitions pending at the same time, protecting
+// memory on constrained devices. A SetState that would start a delayed transition beyond the cap
+// fails with ErrTooManyPending and leaves the state untouched. Repeated requests for already
+// pending transitions are not affected. Zero means no cap.
+func WithMaxPending(n int) Option {
+	return func(sc *StateController) {
+		sc.maxPending = n
+	}
+}
+
+// WithTickInterval replaces the per-transition timers with a single ticker that fires all due
+// transitions every interval. Transitions then fire up to one interval late, in exchange for a
+// single timer regardless of the number of pending transitions. Suited to deployments with
+// huge state counts and coarse (e.g. second-level) precision needs. Close stops the ticker.
+func WithTickInterval(interval time.Duration) Option {
+	return func(sc *StateController) {
+		sc.tickInterval = interval
+	}
+}
+
+// WithInputSampling samples the inputs of all states every interval instead of reacting to every
+// request, for very chatty producers: SetState and its variants are checked and accepted, but
+// only the latest request per state is kept and applied at the next sample, so states change at
+// most once per interval. Close stops the sampling; Drain applies the requests not yet sampled.
+func WithInputSampling(interval time.Duration) Option {
+	return func(sc *StateController) {
+		sc.sampleInterval = interval
+	}
+}
+
+// WithTimerCoalescing rounds the deadlines of delayed transitions up to a multiple of
+// granularity and fires all transitions sharing a deadline from a single timer, e.g. thousands of
+// sessions set within the same granularity that expire together. Transitions then fire up to one
+// granularity late, in exchange for fewer timers and wakeups. Ignored with WithTickInterval.
+func WithTimerCoalescing(granularity time.Duration) Option {
+	return func(sc *StateController) {
+		sc.coalesceGranularity = granularity
+	}
+}
+
+// WithWallClockDeadlines additionally checks the deadlines of pending transitions against the
+// wall clock every interval. Delays are otherwise measured on the monotonic clock, which does
+// not advance while the host is suspended; with this option, a transition that became due
+// during a suspend fires within interval after the host resumes instead of waiting out its
+// remaining delay. Note that wall-clock jumps (e.g. NTP corrections) can then fire transitions
+// early. Close stops the background check.
+func WithWallClockDeadlines(interval time.Duration) Option {
+	return func(sc *StateController) {
+		sc.wallCheckInterval = interval
+	}
+}
+
+// WithClockJumpDetection calls hook whenever the wall clock moved by at least threshold relative
+// to the monotonic clock, e.g. when an edge device without a real-time clock sets its time by NTP
+// hours after boot. A suspend of the host is reported as a jump forward on platforms whose
+// monotonic clock stops while suspended. The clock is checked at the interval of
+// WithWallClockDeadlines, or else every second or threshold, whichever is shorter. The hook may
+// call ReevaluateDeadlines to fire the transitions that became due by the wall clock right away.
+func WithClockJumpDetection(threshold time.Duration, hook func(ClockJump)) Option {
+	return func(sc *StateController) {
+		sc.jumpThreshold = threshold
+		sc.onClockJump = hook
+	}
+}
+
+// WithBootGrace holds all states at their configured initial values for d after construction,
+// e.g. while sensors reconnect after a restart: SetState and its variants are accepted but only
+// buffered, keeping the latest request per state, and applied when the period ends or
+// EndBootGrace is called. ForceState, Reset and UpdateState apply immediately. Requests that
+// fail when applied, e.g. for a state disabled meanwhile, are dropped.
+func WithBootGrace(d time.Duration) Option {
+	return func(sc *StateController) {
+		sc.bootGrace = d
+	}
+}
+
+// WithEscalations watches how long states keep their values and calls hook for each rule whose
+// state kept the rule's value for its duration, e.g. to page someone if "pump-failure" stays
+// active for more than 10 minutes, without an external scheduler. A rule escalates at most once
+// each time its state takes the value; the time counts from the change, or for a state starting
+// at the value, from when it was added. Unknown values do not escalate. hook runs on a timer
+// goroutine and must not block for long.
+func WithEscalations(hook func(EscalationEvent), rules ...Escalation) Option {
+	return func(sc *StateController) {
+		sc.onEscalation = hook
+		sc.escalations = nil
+		if hook == nil {
+			return
+		}
+		sc.escalations = make(map[string][]Escalation)
+		for _, rule := range rules {
+			sc.escalations[rule.Name] = append(sc.escalations[rule.Name], rule)
+		}
+	}
+}
+
+// WithSeries records a timestamped sample of each state when it is added and at each change of
+// its value, so its history can be queried with Series or exported with WriteOpenMetrics, e.g.
+// to plot states over time without scraping infrastructure. Samples older than retention are
+// dropped, as are the oldest samples of a state beyond maxSamples; zero means no limit.
+func WithSeries(retention time.Duration, maxSamples int) Option {
+	return func(sc *StateController) {
+		sc.seriesEnabled = true
+		sc.seriesRetention = retention
+		sc.seriesMax = maxSamples
+	}
+}
+
+// WithSuppressPolicy decides what happens to requests for states held by Suppress;
+// SuppressBuffer by default.
+func WithSuppressPolicy(policy SuppressPolicy) Option {
+	return func(sc *StateController) {
+		sc.suppressPolicy = policy
+	}
+}
+
+// WithInvariantChecks validates internal invariants of a state after every operation on it, e.g.
+// that no timer runs without a pending transition, no transition is pending on a removed state
+// and sequence numbers are increasing, and panics with ErrInvariantViolation on a violation.
+// Meant for debugging and tests; the checks cost time on every operation.
+func WithInvariantChecks(enabled bool) Option {
+	return func(sc *StateController) {
+		sc.invariantChecks = enabled
+	}
+}
+
+// WithStallDetection records how long the controller lock is held and how long onStateChange
+// callbacks run, see HoldTimes, and calls warn, if not nil, for every hold or callback that
+// exceeds threshold. Such stalls block every caller adding, removing or registering states or,
+// for callbacks, the delivery of later changes. The locks of individual states, which SetState
+// and the other per-state methods take instead of the controller lock, are not timed. warn is
+// called without holding any controller lock.
+func WithStallDetection(threshold time.Duration, warn func(Stall)) Option {
+	return func(sc *StateController) {
+		sc.stallThreshold = threshold
+		sc.onStall = warn
+	}
+}
+
+// WithOnStart adds a hook that NewStateController runs once the controller is fully set up,
+// including its initial states and background goroutines, e.g. to register metrics or connect
+// integrations. Hooks run in the order they were added.
+func WithOnStart(hook func(sc *StateController)) Option {
+	return func(sc *StateController) {
+		sc.onStart = append(sc.onStart, hook)
+	}
+}
+
+// WithOnClose adds a hook that the first Close runs after the controller has stopped all its
+// goroutines, e.g. to unregister metrics or disconnect integrations. Hooks run in the reverse
+// order they were added, so setup done in WithOnStart hooks is torn down in reverse.
+func WithOnClose(hook func(sc *StateController)) Option {
+	return func(sc *StateController) {
+		sc.onClose = append(sc.onClose, hook)
+	}
+}
+
+// WithIdempotencyWindow sets how long the keys of WithIdempotencyKey calls are remembered.
+// The default is one minute.
+func WithIdempotencyWindow(window time.Duration) Option {
+	return func(sc *StateController) {
+		sc.idempotencyWindow = window
+	}
+}
+
+// WithAuthorizer sets a hook deciding who may do what, consulted by Authorize for transports
+// and by SetState and its variants with operation OpSet and the caller's context, and by the HTTP
+// handlers with the request's context. ForceState, Reset, SetUnknown, Override, OverrideFrom,
+// ClearOverride, ClearOverrideFrom, Acquire, Release, DisableState, EnableState,
+// SetDelaysEnabled and ExtendPending are checked with OpForce. SetState and the other methods
+// without a context pass context.Background(). Not checked are the queries, AddState,
+// UpdateState and the other configuration methods, which code calls directly, and Suppress,
+// Unsuppress, SetBypassAll and EndBootGrace, which act on tags or the whole controller rather
+// than a state; transports exposing them must call Authorize themselves.
+func WithAuthorizer(authorizer Authorizer) Option {
+	return func(sc *StateController) {
+		sc.authorizer = authorizer
+	}
+}
+
+// WithDryRun runs the controller in dry-run mode, e.g. to validate new delay configurations
+// against live traffic before enabling them: SetState and its variants, ForceState, Reset and
+// SetUnknown are processed by an internal shadow controller with the same states and options,
+// and report receives every change they would have made, but the effective values of this
+// controller never change. Adding, updating and removing states applies to both, as do
+// disabling states, leases, suppressions, bypasses and the end of the boot grace period, so
+// the shadow rejects and holds back the same requests as this controller would.
+func WithDryRun(report StateEventCallback) Option {
+	return func(sc *StateController) {
+		sc.dryRunReport = report
+	}
+}
+
+// WithInitializeStates initializes the StateController with the provided states. States with an
+// invalid configuration are left out; InitError and New return the error of the first one by name.
 // Note: onStateChange is not called for the initial states.
 func WithInitializeStates(states map[string]State) Option {
 	if states == nil {
@@ -30,8 +401,14 @@ func WithInitializeStates(states map[string]State) Option {
 	}
 
 	return func(sc *StateController) {
-		for name, state := range states {
-			sc.states[name] = &delayedState{State: state}
+		for _, name := range stateNames(states) {
+			if err := validateState(states[name]); err != nil {
+				if sc.invalidStateErr == nil {
+					sc.invalidState, sc.invalidStateErr = name, err
+				}
+				continue
+			}
+			sc.states[name] = sc.newDelayedState(states[name])
 		}
 	}
 }
@@ -7,6 +7,7 @@
 package delayedstate
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -110,3 +111,197 @@ func TestOnStateNotExistErrorHandling(t *testing.T) {
 		t.Fatal("Expected 'errorState' not to be added to states due to error")
 	}
 }
+
+func TestWithOnStateNotExistContextIsCalled(t *testing.T) {
+	var gotCtx context.Context
+	factory := func(ctx context.Context, name string) (State, error) {
+		gotCtx = ctx
+		return State{Delay: time.Millisecond * 10}, nil
+	}
+
+	sc := NewStateController(WithOnStateNotExistContext(factory))
+
+	if err := sc.SetState("newState", true); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if gotCtx == nil {
+		t.Fatal("Expected factory to receive a non-nil context")
+	}
+	if !sc.IsActive("newState") {
+		t.Fatal("Expected 'newState' to be created and active")
+	}
+}
+
+func TestWithStateFactoryOverridesOnStateNotExist(t *testing.T) {
+	controllerCalled := false
+	sc := NewStateController(WithOnStateNotExist(func(name string) (State, error) {
+		controllerCalled = true
+		return State{}, nil
+	}))
+
+	overrideCalled := false
+	err := sc.SetState("newState", true, WithStateFactory(func(ctx context.Context, name string) (State, error) {
+		overrideCalled = true
+		return State{Delay: time.Second, DelayOnActivation: true}, nil
+	}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if controllerCalled {
+		t.Fatal("Expected controller-wide callback not to be called")
+	}
+	if !overrideCalled {
+		t.Fatal("Expected per-call factory to be called")
+	}
+
+	state, _ := sc.GetState("newState")
+	if !state.DelayOnActivation {
+		t.Fatal("Expected state to be created from the per-call factory")
+	}
+}
+
+func TestWithStateFactoryWithoutControllerCallback(t *testing.T) {
+	sc := NewStateController()
+
+	err := sc.SetState("newState", true, WithStateFactory(func(ctx context.Context, name string) (State, error) {
+		return State{}, nil
+	}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if !sc.HasState("newState") {
+		t.Fatal("Expected 'newState' to be created by the per-call factory")
+	}
+}
+
+func TestWithSuppressNoops(t *testing.T) {
+	callCount := 0
+	sc := NewStateController(
+		WithSuppressNoops(true),
+		WithOnStateChange(func(name string, active bool) {
+			callCount++
+		}),
+	)
+	sc.AddState("state1", State{Delay: time.Second})
+	sc.SetState("state1", true)
+	sc.SetState("state1", false) // pending deactivation
+
+	gen := sc.states["state1"].timerGen
+	for i := 0; i < 5; i++ {
+		if err := sc.SetState("state1", false); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	if sc.states["state1"].timerGen != gen {
+		t.Fatal("Expected repeated requests not to touch the pending timer")
+	}
+	if callCount != 1 {
+		t.Fatalf("Expected only the activation callback, got %d calls", callCount)
+	}
+
+	// A different request is still applied.
+	sc.SetState("state1", true)
+	if len(sc.PendingStates()) != 0 {
+		t.Fatal("Expected reactivation to cancel the pending deactivation")
+	}
+}
+
+func TestWithSuppressNoopsStillReportsMissingState(t *testing.T) {
+	sc := NewStateController(WithSuppressNoops(true))
+
+	if err := sc.SetState("missing", false); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound, got %v", err)
+	}
+}
+
+func TestWithName(t *testing.T) {
+	var got StateEvent
+	sc := NewStateController(WithName("doors"), WithOnStateEvent(func(ctx context.Context, ev StateEvent) {
+		got = ev
+	}))
+	if sc.Name() != "doors" {
+		t.Fatalf("Expected name doors, got %q", sc.Name())
+	}
+
+	err := sc.SetState("missing", true)
+	if !errors.Is(err, ErrStateNotFound) || err.Error() != "doors: state missing: state not found" {
+		t.Fatalf("Expected error prefixed with the controller name, got %v", err)
+	}
+
+	sc.AddState("state1", State{})
+	sc.SetState("state1", true)
+	if got.Controller != "doors" {
+		t.Fatalf("Expected event of controller doors, got %+v", got)
+	}
+	if m := sc.MemStats(); m.Controller != "doors" {
+		t.Fatalf("Expected MemStats of controller doors, got %+v", m)
+	}
+}
+
+func TestWithQuality(t *testing.T) {
+	events := make(chan StateEvent, 4)
+	sc := NewStateController(WithOnStateEvent(func(ctx context.Context, ev StateEvent) { events <- ev }))
+	sc.AddState("state1", State{Delay: 10 * time.Millisecond})
+
+	sc.SetState("state1", true, WithQuality(0.5))
+	if ev := <-events; ev.Quality != 0.5 {
+		t.Fatalf("Expected quality 0.5, got %v", ev.Quality)
+	}
+
+	// Repeating the value refreshes the quality without an event.
+	sc.SetState("state1", true, WithQuality(0.9))
+	if info, _ := sc.Info("state1"); info.Quality != 0.9 {
+		t.Fatalf("Expected quality 0.9, got %v", info.Quality)
+	}
+
+	// The delayed transition carries the quality of its request.
+	sc.SetState("state1", false, WithQuality(0.7))
+	if info, _ := sc.Info("state1"); info.Quality != 0.9 {
+		t.Fatalf("Expected quality of the effective value to stay 0.9 while pending, got %v", info.Quality)
+	}
+	if ev := <-events; ev.Quality != 0.7 {
+		t.Fatalf("Expected quality 0.7, got %v", ev.Quality)
+	}
+
+	sc.SetState("state1", true)
+	if ev := <-events; ev.Quality != 1 {
+		t.Fatalf("Expected default quality 1, got %v", ev.Quality)
+	}
+}
+
+func TestWithOnStartAndOnClose(t *testing.T) {
+	var calls []string
+	hook := func(name string) func(*StateController) {
+		return func(sc *StateController) {
+			calls = append(calls, name)
+		}
+	}
+	sc := NewStateController(
+		WithOnStart(hook("start1")),
+		WithOnClose(hook("close1")),
+		WithOnStart(func(sc *StateController) {
+			calls = append(calls, "start2")
+			if !sc.HasState("state1") {
+				t.Fatal("Expected the controller to be set up before the start hooks run")
+			}
+		}),
+		WithOnClose(hook("close2")),
+		WithInitializeStates(map[string]State{"state1": {}}),
+	)
+	sc.Close()
+	sc.Close()
+
+	want := []string{"start1", "start2", "close2", "close1"}
+	if len(calls) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, calls)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, calls)
+		}
+	}
+}
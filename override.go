@@ -0,0 +1,180 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// override is a running manual override of a state by one source; see OverrideFrom.
+type override struct {
+	source     string
+	precedence int
+	active     bool
+	until      time.Time
+	timer      *trackedTimer
+}
+
+// Override pins the named state to active for the duration d, e.g. to hold a light on for an
+// hour. It is OverrideFrom with the source "" and precedence 0.
+func (sc *StateController) Override(name string, active bool, d time.Duration) error {
+	return sc.OverrideFrom(name, "", 0, active, d)
+}
+
+// OverrideFrom pins the named state to active for the duration d on behalf of source, e.g. a
+// wall switch, a schedule or an operator. Each source has at most one override per state, which
+// this call sets or replaces; overrides of different sources run side by side, each with its own
+// expiry. The override with the highest precedence, or among equal ones the latest set, decides
+// the value, which changes immediately, as with ForceState, with CauseOverride, whenever the
+// winner changes. SetState and its variants do not change the value while any override runs.
+// When the last one ends, the state reverts to following its inputs: the latest request
+// received meanwhile, or the one before the first override, is applied with the configured
+// delays. ForceState, Reset and UpdateState apply immediately without ending the overrides.
+// Returns ErrInvalidDelay if d is not positive.
+func (sc *StateController) OverrideFrom(name, source string, precedence int, active bool, d time.Duration) error {
+	if d <= 0 {
+		return sc.stateError(name, fmt.Errorf("%w: override duration %v is not positive", ErrInvalidDelay, d))
+	}
+	if sc.Draining() {
+		return sc.stateError(name, ErrDraining)
+	}
+	if err := sc.Authorize(context.Background(), OpForce, name); err != nil {
+		return err
+	}
+	if sc.shadow != nil {
+		return sc.shadow.OverrideFrom(name, source, precedence, active, d)
+	}
+	state := sc.lockState(name)
+	if state == nil {
+		return sc.stateError(name, ErrStateNotFound)
+	}
+	if state.disabled {
+		state.mu.Unlock()
+		return sc.stateError(name, ErrStateDisabled)
+	}
+
+	if len(state.overrides) == 0 {
+		if state.held == nil {
+			// The request the state follows when the overrides end, unless a newer one arrives.
+			ctx := context.Background()
+			if state.pending() {
+				ctx = state.pendingCtx
+			}
+			state.held = &heldRequest{ctx: ctx, active: state.requested}
+		}
+		state.stopTimer()
+		state.stopStaleTimer() // Restarted by the request applied when the overrides end.
+	}
+	state.removeOverrides(func(o *override) bool { return o.source == source })
+	o := &override{source: source, precedence: precedence, active: active, until: sc.now().Add(d)}
+	o.timer = sc.afterFunc(d, func() {
+		sc.endOverrides(name, state, func(x *override) bool { return x == o })
+	})
+	state.overrides = append(state.overrides, o)
+
+	state.version++
+	sc.applyOverride(name, state)
+	sc.checkInvariants(name, state)
+	state.mu.Unlock()
+	sc.flush(state)
+
+	return nil
+}
+
+// ClearOverride ends all overrides of the named state early; see OverrideFrom. Has no effect if
+// the state is not overridden.
+func (sc *StateController) ClearOverride(name string) error {
+	return sc.clearOverrides(name, func(o *override) bool { return true })
+}
+
+// ClearOverrideFrom ends the override of the named state by source early; see OverrideFrom.
+// Has no effect if source does not override the state.
+func (sc *StateController) ClearOverrideFrom(name, source string) error {
+	return sc.clearOverrides(name, func(o *override) bool { return o.source == source })
+}
+
+func (sc *StateController) clearOverrides(name string, match func(o *override) bool) error {
+	if err := sc.Authorize(context.Background(), OpForce, name); err != nil {
+		return err
+	}
+	if sc.shadow != nil {
+		return sc.shadow.clearOverrides(name, match)
+	}
+	state := sc.loadIndex()[name]
+	if state == nil {
+		return sc.stateError(name, ErrStateNotFound)
+	}
+	sc.endOverrides(name, state, match)
+	return nil
+}
+
+// endOverrides ends the overrides of state matching match. The value follows the new winner, or,
+// once no override is left, the held request.
+func (sc *StateController) endOverrides(name string, state *delayedState, match func(o *override) bool) {
+	state.mu.Lock()
+	if state.removed || !state.removeOverrides(match) {
+		state.mu.Unlock()
+		return
+	}
+	if len(state.overrides) > 0 {
+		sc.applyOverride(name, state)
+	} else if held := state.held; held != nil {
+		state.held = nil
+		_ = sc.request(held.ctx, name, state, held.active)
+	}
+	sc.checkInvariants(name, state)
+	state.mu.Unlock()
+	sc.flush(state)
+}
+
+// applyOverride sets the value of state to that of the winning override.
+// Must be called with the state's mutex held and an override running.
+func (sc *StateController) applyOverride(name string, state *delayedState) {
+	active := state.overrideWinner().active
+	state.requested = active
+	sc.setValue(context.Background(), name, state, active, CauseOverride)
+}
+
+// overrideWinner returns the override deciding the value, or nil if none is running.
+// Must be called with the state's mutex held.
+func (s *delayedState) overrideWinner() *override {
+	var winner *override
+	for _, o := range s.overrides {
+		// Overrides are kept in the order they were set, so later ones win ties.
+		if winner == nil || o.precedence >= winner.precedence {
+			winner = o
+		}
+	}
+	return winner
+}
+
+// removeOverrides stops and removes the overrides matching match, and reports whether there were
+// any. Must be called with the state's mutex held.
+func (s *delayedState) removeOverrides(match func(o *override) bool) bool {
+	kept := s.overrides[:0]
+	for _, o := range s.overrides {
+		if match(o) {
+			o.timer.Stop()
+		} else {
+			kept = append(kept, o)
+		}
+	}
+	removed := len(kept) < len(s.overrides)
+	for i := len(kept); i < len(s.overrides); i++ {
+		s.overrides[i] = nil
+	}
+	s.overrides = kept
+	return removed
+}
+
+// stopOverrides ends all overrides without applying the held request.
+// Must be called with the state's mutex held.
+func (s *delayedState) stopOverrides() {
+	s.removeOverrides(func(o *override) bool { return true })
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOverride(t *testing.T) {
+	var events []StateEvent
+	sc := NewStateController(WithInvariantChecks(true))
+	sc.onStateChange = func(ctx context.Context, ev StateEvent) { events = append(events, ev) }
+	defer sc.Close()
+	sc.AddState("light", State{Delay: time.Hour})
+
+	if err := sc.Override("light", true, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if !sc.IsActive("light") || len(events) != 1 || events[0].Cause != CauseOverride {
+		t.Fatalf("Expected the override to apply immediately, got %+v", events)
+	}
+	if info, _ := sc.Info("light"); info.OverrideUntil.IsZero() {
+		t.Fatal("Expected Info to report the override")
+	}
+
+	sc.SetState("light", true)
+	sc.SetState("light", false)
+	if !sc.IsActive("light") {
+		t.Fatal("Expected requests not to change an overridden state")
+	}
+	if pending, _ := sc.IsPending("light"); pending {
+		t.Fatal("Expected no transition to be scheduled during the override")
+	}
+
+	if err := sc.ClearOverride("light"); err != nil {
+		t.Fatal(err)
+	}
+	if pending, towards := sc.IsPending("light"); !pending || towards {
+		t.Fatal("Expected the latest request to be applied with its delay when the override ends")
+	}
+	if info, _ := sc.Info("light"); !info.OverrideUntil.IsZero() {
+		t.Fatal("Expected the override to be cleared")
+	}
+	if err := sc.Override("missing", true, time.Hour); err == nil {
+		t.Fatal("Expected an error for a missing state")
+	}
+	for _, d := range []time.Duration{0, -time.Hour} {
+		if err := sc.Override("light", true, d); !errors.Is(err, ErrInvalidDelay) {
+			t.Fatalf("Expected ErrInvalidDelay for %v, got %v", d, err)
+		}
+	}
+}
+
+func TestOverrideExpires(t *testing.T) {
+	sc := NewStateController()
+	defer sc.Close()
+	sc.AddState("light", State{IsActive: true})
+
+	sc.Override("light", false, 10*time.Millisecond)
+	if sc.IsActive("light") {
+		t.Fatal("Expected the override to apply immediately")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sc.Await(ctx, "light", true); err != nil {
+		t.Fatal("Expected the state to revert to the request before the override when it expires")
+	}
+}
+
+func TestOverrideFromPrecedence(t *testing.T) {
+	sc := NewStateController(WithInvariantChecks(true))
+	defer sc.Close()
+	sc.AddState("light", State{})
+
+	sc.OverrideFrom("light", "schedule", 0, true, time.Hour)
+	sc.OverrideFrom("light", "operator", 10, false, time.Hour)
+	sc.OverrideFrom("light", "switch", 0, true, time.Hour)
+	if info, _ := sc.Info("light"); info.State.IsActive || info.OverrideSource != "operator" {
+		t.Fatalf("Expected the override with the highest precedence to win, got %+v", info)
+	}
+
+	sc.ClearOverrideFrom("light", "operator")
+	if info, _ := sc.Info("light"); !info.State.IsActive || info.OverrideSource != "switch" {
+		t.Fatalf("Expected the latest of the remaining overrides to win, got %+v", info)
+	}
+
+	sc.OverrideFrom("light", "switch", 0, false, 10*time.Millisecond)
+	if info, _ := sc.Info("light"); info.State.IsActive || info.OverrideSource != "switch" {
+		t.Fatalf("Expected the replaced override of switch to win, got %+v", info)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sc.Await(ctx, "light", true); err != nil {
+		t.Fatal("Expected the schedule to win when the override of switch expires")
+	}
+	if info, _ := sc.Info("light"); info.OverrideSource != "schedule" {
+		t.Fatalf("Expected schedule to be reported as the winner, got %q", info.OverrideSource)
+	}
+
+	sc.SetState("light", false)
+	sc.ClearOverride("light")
+	if info, _ := sc.Info("light"); !info.OverrideUntil.IsZero() {
+		t.Fatal("Expected no override to be left")
+	}
+	if err := sc.Await(ctx, "light", false); err != nil {
+		t.Fatal("Expected the held request to apply once no override is left")
+	}
+}
+
+func TestOverrideRemoveWhere(t *testing.T) {
+	sc := NewStateController()
+	defer sc.Close()
+	sc.AddState("light", State{})
+	sc.Override("light", true, time.Hour)
+	state := sc.loadIndex()["light"]
+
+	sc.RemoveWhere(func(name string, state State) bool { return true })
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if len(state.overrides) != 0 {
+		t.Fatal("Expected the overrides of a removed state to be stopped")
+	}
+}
+
+func TestOverrideUntilControllerClock(t *testing.T) {
+	now := time.Unix(1000, 0)
+	sc := NewStateController(withClock(func() time.Time { return now }))
+	defer sc.Close()
+	sc.AddState("light", State{})
+
+	sc.Override("light", true, time.Hour)
+	if info, _ := sc.Info("light"); !info.OverrideUntil.Equal(now.Add(time.Hour)) {
+		t.Fatalf("Expected the override to end an hour after the controller clock, got %v", info.OverrideUntil)
+	}
+}
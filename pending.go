@@ -0,0 +1,50 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"sort"
+	"time"
+)
+
+// PendingTransition describes a delayed transition that has not fired yet.
+type PendingTransition struct {
+	Name        string
+	Target      bool      // Value the transition moves to.
+	ScheduledAt time.Time // When the transition was scheduled.
+	Deadline    time.Time // When the transition is due.
+	RequestedBy string    // Requester of the scheduling call set with WithRequestedBy, if any.
+	Priority    Priority  // Priority of the scheduling call set with WithPriority.
+}
+
+// Pending returns every pending delayed transition, ordered by deadline, as a single "what is
+// about to happen" view for operators.
+func (sc *StateController) Pending() []PendingTransition {
+	var pending []PendingTransition
+	for name, state := range sc.loadIndex() {
+		state.mu.Lock()
+		if !state.removed && state.pending() {
+			pending = append(pending, PendingTransition{
+				Name:        name,
+				Target:      state.pendingTarget,
+				ScheduledAt: state.scheduledAt,
+				Deadline:    state.deadline,
+				RequestedBy: RequestedBy(state.pendingCtx),
+				Priority:    priorityFrom(state.pendingCtx),
+			})
+		}
+		state.mu.Unlock()
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		if !pending[i].Deadline.Equal(pending[j].Deadline) {
+			return pending[i].Deadline.Before(pending[j].Deadline)
+		}
+		return pending[i].Name < pending[j].Name
+	})
+	return pending
+}
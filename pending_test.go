@@ -0,0 +1,34 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPending(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("late", State{Delay: time.Hour, IsActive: true})
+	sc.AddState("soon", State{Delay: time.Minute, DelayOnActivation: true})
+	sc.AddState("idle", State{Delay: time.Minute})
+
+	sc.SetStateCtx(WithRequestedBy(context.Background(), "operator"), "late", false)
+	sc.SetState("soon", true, WithPriority(PriorityCritical))
+
+	pending := sc.Pending()
+	if len(pending) != 2 {
+		t.Fatalf("Expected 2 pending transitions, got %+v", pending)
+	}
+	if p := pending[0]; p.Name != "soon" || !p.Target || p.Priority != PriorityCritical || p.Deadline.Sub(p.ScheduledAt) != time.Minute {
+		t.Fatalf("Expected the activation of soon first, got %+v", p)
+	}
+	if p := pending[1]; p.Name != "late" || p.Target || p.RequestedBy != "operator" {
+		t.Fatalf("Expected the deactivation of late requested by operator, got %+v", p)
+	}
+}
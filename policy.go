@@ -0,0 +1,99 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidState is returned when a State configuration is invalid, e.g. has an unknown PendingPolicy.
+var ErrInvalidState = errors.New("invalid state configuration")
+
+// PendingPolicy decides what happens when SetState requests the delayed value again while the
+// delayed transition towards it is pending. Requesting the opposite value is not covered by the
+// policy; it cancels the pending transition or, with WithCancelOnOpposite(false), is applied
+// once the transition fired.
+type PendingPolicy int
+
+const (
+	// PendingKeepEarliest ignores the repeated request; the first deadline stands. This is the default.
+	PendingKeepEarliest PendingPolicy = iota
+	// PendingRestartDelay restarts the full delay from the repeated request, turning the
+	// delay into an inactivity timeout.
+	PendingRestartDelay
+	// PendingExtendBy pushes the current deadline out by State.Extension.
+	PendingExtendBy
+	// PendingReplaceWithLatest keeps the deadline, but the transition fires with the context of
+	// the latest request instead of the first, e.g. to link it to the latest trace.
+	PendingReplaceWithLatest
+)
+
+// String returns the name of the policy.
+func (p PendingPolicy) String() string {
+	switch p {
+	case PendingKeepEarliest:
+		return "keep-earliest"
+	case PendingRestartDelay:
+		return "restart-delay"
+	case PendingExtendBy:
+		return "extend-by"
+	case PendingReplaceWithLatest:
+		return "replace-with-latest"
+	default:
+		return fmt.Sprintf("PendingPolicy(%d)", int(p))
+	}
+}
+
+// validateState reports whether state is a valid configuration.
+func validateState(state State) error {
+	switch state.PendingPolicy {
+	case PendingKeepEarliest, PendingRestartDelay, PendingReplaceWithLatest:
+	case PendingExtendBy:
+		if state.Extension <= 0 {
+			return fmt.Errorf("%w: %s requires a positive Extension", ErrInvalidState, state.PendingPolicy)
+		}
+	default:
+		return fmt.Errorf("%w: unknown %s", ErrInvalidState, state.PendingPolicy)
+	}
+	switch state.Clock {
+	case ClockDefault, ClockMonotonic, ClockWall:
+	default:
+		return fmt.Errorf("%w: unknown %s", ErrInvalidState, state.Clock)
+	}
+	if state.Delay < 0 {
+		return fmt.Errorf("%w: negative Delay", ErrInvalidState)
+	}
+	if state.StaleAfter < 0 {
+		return fmt.Errorf("%w: negative StaleAfter", ErrInvalidState)
+	}
+	return nil
+}
+
+// reassert applies the state's PendingPolicy to a repeated request for the pending transition.
+// Must be called with the state's mutex held and a timer pending.
+func (sc *StateController) reassert(ctx context.Context, name string, state *delayedState) {
+	switch state.PendingPolicy {
+	case PendingRestartDelay:
+		sc.startTimer(ctx, name, state, state.pendingTarget, state.Delay)
+	case PendingExtendBy:
+		sc.extendTimer(name, state, state.Extension)
+	case PendingReplaceWithLatest:
+		state.pendingCtx = detachContext(ctx)
+	}
+}
+
+// extendTimer moves the pending timer's deadline out by extra, keeping its target and context.
+// Must be called with the state's mutex held and a timer pending.
+func (sc *StateController) extendTimer(name string, state *delayedState, extra time.Duration) {
+	target, timerCtx, scheduledAt := state.pendingTarget, state.pendingCtx, state.scheduledAt
+	remaining := state.deadline.Sub(sc.now())
+	sc.startTimer(timerCtx, name, state, target, remaining+extra)
+	state.scheduledAt = scheduledAt
+}
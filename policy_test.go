@@ -0,0 +1,215 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// pendingTimeline sets up a state with a pending delayed transition, repeats the request after
+// 20ms and reports whether the transition had happened at 50ms and at 90ms.
+// The delay is 40ms, so the first deadline is at 40ms and a restarted one at 60ms.
+func pendingTimeline(t *testing.T, state State) (at50, at90 bool) {
+	t.Helper()
+	sc := NewStateController()
+	state.Delay = 40 * time.Millisecond
+	if err := sc.AddState("state1", state); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	target := state.DelayOnActivation
+	if !target {
+		sc.SetState("state1", true)
+	}
+	sc.SetState("state1", target)
+
+	time.Sleep(20 * time.Millisecond)
+	sc.SetState("state1", target)
+
+	time.Sleep(30 * time.Millisecond)
+	at50 = sc.IsActive("state1") == target
+	time.Sleep(40 * time.Millisecond)
+	at90 = sc.IsActive("state1") == target
+	return at50, at90
+}
+
+func TestPendingPolicyRepeat(t *testing.T) {
+	tests := []struct {
+		policy    PendingPolicy
+		extension time.Duration
+		at50      bool
+	}{
+		{PendingKeepEarliest, 0, true},                  // fires at 40ms
+		{PendingRestartDelay, 0, false},                 // fires at 60ms
+		{PendingExtendBy, 25 * time.Millisecond, false}, // fires at 65ms
+		{PendingReplaceWithLatest, 0, true},             // fires at 40ms
+	}
+
+	for _, tt := range tests {
+		for _, delayOnActivation := range []bool{false, true} {
+			at50, at90 := pendingTimeline(t, State{
+				DelayOnActivation: delayOnActivation,
+				PendingPolicy:     tt.policy,
+				Extension:         tt.extension,
+			})
+			if at50 != tt.at50 {
+				t.Fatalf("%s (DelayOnActivation=%v): expected transition at 50ms=%v, got %v",
+					tt.policy, delayOnActivation, tt.at50, at50)
+			}
+			if !at90 {
+				t.Fatalf("%s (DelayOnActivation=%v): expected transition by 90ms", tt.policy, delayOnActivation)
+			}
+		}
+	}
+}
+
+func TestPendingPolicyReverseCancels(t *testing.T) {
+	policies := []PendingPolicy{PendingKeepEarliest, PendingRestartDelay, PendingExtendBy, PendingReplaceWithLatest}
+
+	for _, policy := range policies {
+		for _, delayOnActivation := range []bool{false, true} {
+			sc := NewStateController()
+			sc.AddState("state1", State{
+				Delay:             20 * time.Millisecond,
+				DelayOnActivation: delayOnActivation,
+				PendingPolicy:     policy,
+				Extension:         time.Millisecond,
+			})
+
+			target := delayOnActivation
+			if !target {
+				sc.SetState("state1", true)
+			}
+			sc.SetState("state1", target)
+			sc.SetState("state1", !target)
+
+			time.Sleep(40 * time.Millisecond)
+			if sc.IsActive("state1") != !target {
+				t.Fatalf("%s (DelayOnActivation=%v): expected reversal to cancel the pending transition",
+					policy, delayOnActivation)
+			}
+		}
+	}
+}
+
+func TestCancelOnOppositeDisabled(t *testing.T) {
+	for _, delayOnActivation := range []bool{false, true} {
+		var changes []bool
+		sc := NewStateController(
+			WithCancelOnOpposite(false),
+			WithOnStateChange(func(name string, active bool) { changes = append(changes, active) }),
+		)
+		sc.AddState("state1", State{Delay: 20 * time.Millisecond, DelayOnActivation: delayOnActivation})
+
+		target := delayOnActivation
+		if !target {
+			sc.SetState("state1", true)
+		}
+		sc.SetState("state1", target)
+		sc.SetState("state1", !target)
+		if sc.IsActive("state1") == target {
+			t.Fatalf("DelayOnActivation=%v: expected pending transition not to be applied early", delayOnActivation)
+		}
+
+		time.Sleep(40 * time.Millisecond)
+		if sc.IsActive("state1") != !target {
+			t.Fatalf("DelayOnActivation=%v: expected deferred request to be applied after the transition", delayOnActivation)
+		}
+		if n := len(changes); n < 2 || changes[n-2] != target || changes[n-1] != !target {
+			t.Fatalf("DelayOnActivation=%v: expected committed transition followed by deferred request, got %v",
+				delayOnActivation, changes)
+		}
+	}
+}
+
+func TestCancelOnOppositeDeferredDelay(t *testing.T) {
+	sc := NewStateController(WithCancelOnOpposite(false))
+	sc.AddState("state1", State{Delay: 20 * time.Millisecond})
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false) // Pending deactivation.
+	sc.SetState("state1", true)  // Deferred.
+	sc.SetState("state1", false) // Reverts the deferred request.
+
+	time.Sleep(40 * time.Millisecond)
+	if sc.IsActive("state1") {
+		t.Fatal("Expected the committed deactivation to stand")
+	}
+}
+
+func TestPendingReplaceWithLatestUsesLatestContext(t *testing.T) {
+	done := make(chan interface{}, 1)
+	sc := NewStateController(WithOnStateChangeContext(func(ctx context.Context, name string, active bool) {
+		if active {
+			done <- ctx.Value(testCtxKey{})
+		}
+	}))
+	sc.AddState("state1", State{
+		Delay:             20 * time.Millisecond,
+		DelayOnActivation: true,
+		PendingPolicy:     PendingReplaceWithLatest,
+	})
+
+	sc.SetStateCtx(context.WithValue(context.Background(), testCtxKey{}, "first"), "state1", true)
+	sc.SetStateCtx(context.WithValue(context.Background(), testCtxKey{}, "latest"), "state1", true)
+
+	select {
+	case got := <-done:
+		if got != "latest" {
+			t.Fatalf("Expected callback with latest context, got %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected delayed activation callback")
+	}
+}
+
+func TestValidateState(t *testing.T) {
+	sc := NewStateController()
+
+	err := sc.AddState("state1", State{PendingPolicy: PendingPolicy(42)})
+	if !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("Expected ErrInvalidState for unknown policy, got %v", err)
+	}
+
+	err = sc.AddState("state1", State{PendingPolicy: PendingExtendBy})
+	if !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("Expected ErrInvalidState for PendingExtendBy without Extension, got %v", err)
+	}
+
+	err = sc.AddState("state1", State{Delay: -time.Second})
+	if !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("Expected ErrInvalidState for negative delay, got %v", err)
+	}
+
+	sc.AddState("state2", State{})
+	err = sc.UpdateState("state2", State{PendingPolicy: PendingPolicy(-1)})
+	if !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("Expected ErrInvalidState from UpdateState, got %v", err)
+	}
+
+	err = sc.SetState("state3", true, WithStateFactory(func(ctx context.Context, name string) (State, error) {
+		return State{PendingPolicy: PendingPolicy(42)}, nil
+	}))
+	if !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("Expected ErrInvalidState from factory-created state, got %v", err)
+	}
+	if sc.HasState("state3") {
+		t.Fatal("Expected invalid factory state not to be added")
+	}
+}
+
+func TestPendingPolicyString(t *testing.T) {
+	if PendingRestartDelay.String() != "restart-delay" {
+		t.Fatalf("Unexpected name %q", PendingRestartDelay.String())
+	}
+	if PendingPolicy(42).String() != "PendingPolicy(42)" {
+		t.Fatalf("Unexpected name %q", PendingPolicy(42).String())
+	}
+}
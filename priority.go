@@ -0,0 +1,40 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"fmt"
+)
+
+// Priority ranks the changes of a SetState call against other work queued in the controller.
+type Priority int
+
+const (
+	// PriorityLow marks changes that may be shed first: on async callback queue overflow, low
+	// priority callbacks are dropped before all others.
+	PriorityLow Priority = iota - 1
+	// PriorityNormal is the priority of calls without WithPriority.
+	PriorityNormal
+	// PriorityCritical marks emergency changes: their async callbacks are queued ahead of other
+	// states' callbacks and are never dropped or blocked by a full queue, and subscriptions deliver
+	// them without WithCoalesce holding them back.
+	PriorityCritical
+)
+
+// String returns the name of the priority.
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityCritical:
+		return "critical"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stoppedDispatcher returns a dispatcher without a worker, so its queue can be inspected.
+func stoppedDispatcher(size int, policy OverflowPolicy) *dispatcher {
+	d := &dispatcher{size: size, policy: policy}
+	d.notEmpty = sync.NewCond(&d.mu)
+	d.notFull = sync.NewCond(&d.mu)
+	return d
+}
+
+func queued(name string, priority Priority) callbackEvent {
+	return callbackEvent{ctx: context.Background(), event: StateEvent{Name: name, Priority: priority}}
+}
+
+func queueNames(d *dispatcher) []string {
+	var names []string
+	for _, ev := range d.queue {
+		names = append(names, ev.event.Name)
+	}
+	return names
+}
+
+func TestDispatcherPriorities(t *testing.T) {
+	d := stoppedDispatcher(3, OverflowBlock)
+	d.enqueue(queued("a", PriorityNormal))
+	d.enqueue(queued("b", PriorityNormal))
+	d.enqueue(queued("a", PriorityNormal))
+
+	// Critical callbacks never block, and go ahead of other states but behind their own.
+	d.enqueue(queued("c", PriorityCritical))
+	d.enqueue(queued("a", PriorityCritical))
+	if got, want := queueNames(d), []string{"c", "a", "b", "a", "a"}; !equalNames(got, want) {
+		t.Fatalf("Expected queue %v, got %v", want, got)
+	}
+	if d.queue[3].event.Priority != PriorityNormal || d.queue[4].event.Priority != PriorityCritical {
+		t.Fatalf("Expected the critical callback of a behind its normal ones, got %+v", d.queue)
+	}
+
+	// Low priority callbacks are dropped first.
+	d = stoppedDispatcher(2, OverflowDropOldest)
+	d.enqueue(queued("a", PriorityNormal))
+	d.enqueue(queued("b", PriorityLow))
+	d.enqueue(queued("c", PriorityNormal))
+	if got, want := queueNames(d), []string{"a", "c"}; !equalNames(got, want) {
+		t.Fatalf("Expected queue %v, got %v", want, got)
+	}
+
+	d = stoppedDispatcher(2, OverflowDropNewest)
+	d.enqueue(queued("a", PriorityLow))
+	d.enqueue(queued("b", PriorityNormal))
+	d.enqueue(queued("c", PriorityNormal))
+	d.enqueue(queued("d", PriorityNormal))
+	if got, want := queueNames(d), []string{"b", "c"}; !equalNames(got, want) {
+		t.Fatalf("Expected queue %v, got %v", want, got)
+	}
+	if d.dropped != 2 {
+		t.Fatalf("Expected 2 dropped callbacks, got %d", d.dropped)
+	}
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWithPriority(t *testing.T) {
+	events := make(chan StateEvent, 4)
+	sc := NewStateController(WithOnStateEvent(func(ctx context.Context, ev StateEvent) { events <- ev }))
+	sc.AddState("state1", State{Delay: 10 * time.Millisecond})
+
+	sc.SetState("state1", true, WithPriority(PriorityCritical))
+	if ev := <-events; ev.Priority != PriorityCritical {
+		t.Fatalf("Expected critical priority, got %s", ev.Priority)
+	}
+
+	// The delayed transition carries the priority of its request.
+	sc.SetState("state1", false, WithPriority(PriorityLow))
+	if ev := <-events; ev.Priority != PriorityLow {
+		t.Fatalf("Expected low priority, got %s", ev.Priority)
+	}
+}
+
+func TestSubscribeCoalesceCritical(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("a", State{})
+	sub := sc.Subscribe(8, WithCoalesce(time.Hour))
+	defer sub.Close()
+
+	sc.SetState("a", true)
+	sc.SetState("a", false, WithPriority(PriorityCritical))
+
+	ev := receive(t, sub)
+	if ev.Active || ev.Priority != PriorityCritical {
+		t.Fatalf("Expected the critical deactivation right away, got %+v", ev)
+	}
+}
+
+func TestPriorityString(t *testing.T) {
+	tests := map[Priority]string{
+		PriorityLow:      "low",
+		PriorityNormal:   "normal",
+		PriorityCritical: "critical",
+		Priority(42):     "Priority(42)",
+	}
+	for priority, want := range tests {
+		if got := priority.String(); got != want {
+			t.Fatalf("Expected %q, got %q", want, got)
+		}
+	}
+}
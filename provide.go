@@ -0,0 +1,26 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+// NewStateControllerWithCleanup is like New, but also returns Close as the cleanup function,
+// matching the constructor shape expected by dependency injection frameworks such as uber/fx and
+// google/wire.
+func NewStateControllerWithCleanup(opts ...Option) (*StateController, func(), error) {
+	sc, err := New(opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sc, sc.Close, nil
+}
+
+// Provider returns a constructor for a controller with opts, for registering with dependency
+// injection frameworks, e.g. fx.Provide(delayedstate.Provider(opts...)).
+func Provider(opts ...Option) func() (*StateController, func(), error) {
+	return func() (*StateController, func(), error) {
+		return NewStateControllerWithCleanup(opts...)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewStateControllerWithCleanup(t *testing.T) {
+	closed := false
+	sc, cleanup, err := NewStateControllerWithCleanup(
+		WithInitializeStates(map[string]State{"state1": {Delay: time.Second}}),
+		WithOnClose(func(*StateController) { closed = true }),
+	)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !sc.HasState("state1") {
+		t.Fatal("Expected the initial state to exist")
+	}
+
+	cleanup()
+	if !closed {
+		t.Fatal("Expected cleanup to close the controller")
+	}
+}
+
+func TestNewStateControllerWithCleanupInvalidState(t *testing.T) {
+	closed := false
+	sc, cleanup, err := Provider(
+		WithInitializeStates(map[string]State{"state1": {Delay: -time.Second}}),
+		WithOnClose(func(*StateController) { closed = true }),
+	)()
+	if !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("Expected ErrInvalidState, got %v", err)
+	}
+	if sc != nil || cleanup != nil {
+		t.Fatal("Expected no controller and no cleanup on error")
+	}
+	if !closed {
+		t.Fatal("Expected the invalid controller to be closed")
+	}
+}
+
+func TestNew(t *testing.T) {
+	sc, err := New(WithInitializeStates(map[string]State{"state1": {Delay: time.Second}}))
+	if err != nil || !sc.HasState("state1") {
+		t.Fatalf("Expected a controller with state1, got %v", err)
+	}
+	sc.Close()
+
+	states := map[string]State{
+		"a": {PendingPolicy: PendingPolicy(99)},
+		"b": {Clock: Clock(99)},
+		"c": {},
+	}
+	if _, err := New(WithInitializeStates(states)); !errors.Is(err, ErrInvalidState) || !strings.HasPrefix(err.Error(), "state a:") {
+		t.Fatalf("Expected ErrInvalidState for state a, got %v", err)
+	}
+	sc = NewStateController(WithInitializeStates(states))
+	defer sc.Close()
+	if sc.HasState("a") || sc.HasState("b") || !sc.HasState("c") {
+		t.Fatal("Expected the invalid initial states to be left out")
+	}
+	if err := sc.InitError(); !errors.Is(err, ErrInvalidState) || !strings.HasPrefix(err.Error(), "state a:") {
+		t.Fatalf("Expected InitError to report state a, got %v", err)
+	}
+	if err := NewStateController().InitError(); err != nil {
+		t.Fatalf("Expected no InitError without initial states, got %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+)
+
+// Run blocks until ctx is done and then closes the controller, for services that manage their
+// components as a run group (e.g. errgroup or oklog/run). It also returns once the controller is
+// closed otherwise. Run returns nil, as cancellation is the regular way to stop it; call Drain
+// before cancelling ctx to let pending transitions fire first.
+func (sc *StateController) Run(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		sc.Close()
+	case <-sc.closed:
+	}
+	return nil
+}
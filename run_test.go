@@ -0,0 +1,48 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	sc := NewStateController(WithTickInterval(time.Hour))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() { done <- sc.Run(ctx) }()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Run to return after cancellation")
+	}
+	if got := sc.PendingGoroutines(); got != 0 {
+		t.Fatalf("Expected the controller to be closed, got %d goroutines", got)
+	}
+}
+
+func TestRunReturnsOnClose(t *testing.T) {
+	sc := NewStateController()
+
+	done := make(chan error, 1)
+	go func() { done <- sc.Run(context.Background()) }()
+
+	sc.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Run to return after Close")
+	}
+}
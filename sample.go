@@ -0,0 +1,44 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"time"
+)
+
+// runSampler applies the latest request of every state every interval until stop is closed; see
+// WithInputSampling.
+func (sc *StateController) runSampler(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sc.sampleInputs()
+		}
+	}
+}
+
+// sampleInputs applies the requests received since the last sample, one per state. A request
+// that fails now, e.g. as the state was disabled meanwhile, is dropped.
+func (sc *StateController) sampleInputs() {
+	for name, state := range sc.loadIndex() {
+		state.mu.Lock()
+		sampled := state.sampled
+		if sampled != nil && !state.removed {
+			state.sampled = nil
+			if !state.disabled {
+				_ = sc.accept(sampled.ctx, name, state, sampled.active)
+			}
+		}
+		state.mu.Unlock()
+		sc.flush(state)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithInputSampling(t *testing.T) {
+	var changes []bool
+	sc := NewStateController(WithInputSampling(time.Hour), WithOnStateChange(func(name string, active bool) {
+		changes = append(changes, active)
+	}))
+	defer sc.Close()
+	sc.AddState("motion", State{})
+
+	for i := 0; i < 10; i++ {
+		sc.SetState("motion", i%2 == 0)
+	}
+	sc.SetState("motion", true)
+	if sc.IsActive("motion") {
+		t.Fatal("Expected requests to wait for the next sample")
+	}
+
+	sc.sampleInputs()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sc.Await(ctx, "motion", true); err != nil {
+		t.Fatal("Expected the latest request to be applied by the sample")
+	}
+	if len(changes) != 1 {
+		t.Fatalf("Expected a single change, got %v", changes)
+	}
+
+	sc.DisableState("motion")
+	if err := sc.SetState("motion", false); !errors.Is(err, ErrStateDisabled) {
+		t.Fatalf("Expected requests to be checked when accepted, got %v", err)
+	}
+}
+
+func TestWithInputSamplingTicks(t *testing.T) {
+	sc := NewStateController(WithInputSampling(5 * time.Millisecond))
+	defer sc.Close()
+	sc.AddState("motion", State{})
+	sc.SetState("motion", true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sc.Await(ctx, "motion", true); err != nil {
+		t.Fatal("Expected the sampler to apply the request")
+	}
+}
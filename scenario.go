@@ -0,0 +1,316 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidScenario is returned by ParseScenario for malformed scenarios.
+	ErrInvalidScenario = errors.New("invalid scenario")
+	// ErrScenarioFailed is returned by Scenario.Run if an expectation was not met.
+	ErrScenarioFailed = errors.New("scenario failed")
+)
+
+// Scenario is an acceptance test of state configurations and delays, written without Go code and
+// executed against a fake clock, so even scenarios spanning hours run instantly. A scenario has
+// one statement per line or separated by ";"; "#" starts a comment:
+//
+//	state door delay=30s           # settings: delay=, extend-by=, on-activation, active
+//	at t=0 set door=true
+//	at t=10s set door=false
+//	at t=39s expect door active
+//	at t=40s expect door inactive
+//
+// Times are offsets from the start of the scenario, parsed with ParseDelay, and must not
+// decrease. Staleness (State.StaleAfter) is measured on the real clock and not covered.
+type Scenario struct {
+	states map[string]State
+	steps  []scenarioStep
+}
+
+// scenarioStep is an "at" statement of a scenario.
+type scenarioStep struct {
+	line   int
+	at     time.Duration
+	expect bool // Checks the value of name instead of setting it.
+	name   string
+	active bool
+}
+
+// ParseScenario parses a scenario; see Scenario for the format.
+func ParseScenario(src string) (*Scenario, error) {
+	s := &Scenario{states: make(map[string]State)}
+	var last time.Duration
+	for i, line := range strings.Split(src, "\n") {
+		if n := strings.IndexByte(line, '#'); n >= 0 {
+			line = line[:n]
+		}
+		for _, stmt := range strings.Split(line, ";") {
+			fields := strings.Fields(stmt)
+			if len(fields) == 0 {
+				continue
+			}
+			var err error
+			switch fields[0] {
+			case "state":
+				err = s.parseState(fields[1:])
+			case "at":
+				var step scenarioStep
+				step, err = parseScenarioStep(fields[1:])
+				if err == nil && step.at < last {
+					err = fmt.Errorf("time %s before previous step at %s", step.at, last)
+				}
+				step.line = i + 1
+				last = step.at
+				s.steps = append(s.steps, step)
+			default:
+				err = fmt.Errorf("unknown statement %q", fields[0])
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidScenario, i+1, err)
+			}
+		}
+	}
+	return s, nil
+}
+
+// parseState parses the arguments of a "state" statement.
+func (s *Scenario) parseState(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing state name")
+	}
+	name := args[0]
+	if _, exists := s.states[name]; exists {
+		return fmt.Errorf("state %s declared twice", name)
+	}
+	var state State
+	for _, arg := range args[1:] {
+		switch {
+		case strings.HasPrefix(arg, "delay="):
+			d, err := ParseDelay(strings.TrimPrefix(arg, "delay="))
+			if err != nil {
+				return err
+			}
+			state.Delay = d
+		case strings.HasPrefix(arg, "extend-by="):
+			d, err := ParseDelay(strings.TrimPrefix(arg, "extend-by="))
+			if err != nil {
+				return err
+			}
+			state.PendingPolicy, state.Extension = PendingExtendBy, d
+		case arg == "on-activation":
+			state.DelayOnActivation = true
+		case arg == "active":
+			state.IsActive = true
+		default:
+			return fmt.Errorf("unknown setting %q of state %s", arg, name)
+		}
+	}
+	s.states[name] = state
+	return nil
+}
+
+// parseScenarioStep parses the arguments of an "at" statement.
+func parseScenarioStep(args []string) (scenarioStep, error) {
+	var step scenarioStep
+	if len(args) != 3 && len(args) != 4 {
+		return step, errors.New(`expected "at <time> set <name>=<bool>" or "at <time> expect <name> active|inactive"`)
+	}
+	at, err := ParseDelay(strings.TrimPrefix(args[0], "t="))
+	if err != nil {
+		return step, err
+	}
+	step.at = at
+
+	switch {
+	case args[1] == "set" && len(args) == 3:
+		name, value, ok := strings.Cut(args[2], "=")
+		if !ok || name == "" {
+			return step, fmt.Errorf("expected <name>=<bool>, got %q", args[2])
+		}
+		if step.active, err = strconv.ParseBool(value); err != nil {
+			return step, fmt.Errorf("invalid value of %s: %q", name, value)
+		}
+		step.name = name
+	case args[1] == "expect" && len(args) == 4:
+		step.expect, step.name = true, args[2]
+		switch args[3] {
+		case "active":
+			step.active = true
+		case "inactive":
+		default:
+			return step, fmt.Errorf(`expected "active" or "inactive", got %q`, args[3])
+		}
+	default:
+		return step, fmt.Errorf(`expected "set <name>=<bool>" or "expect <name> active|inactive" after the time`)
+	}
+	return step, nil
+}
+
+// Run executes the scenario on a new controller created with opts and the states declared by the
+// scenario, e.g. to add a factory with WithOnStateNotExist. WithTickInterval and
+// WithWallClockDeadlines must not be given. If expectations are not met, the returned error wraps
+// ErrScenarioFailed and lists every unmet expectation followed by all changes made.
+func (s *Scenario) Run(opts ...Option) error {
+	_, err := s.Transcript(opts...)
+	return err
+}
+
+// Transcript runs the scenario like Run and returns every change made, one per line in the
+// form "30s: light false (timer)", e.g. for comparison with a golden file by MatchGolden.
+// Since the scenario runs on a fake clock, the transcript is the same on every run.
+func (s *Scenario) Transcript(opts ...Option) (string, error) {
+	clock := &scenarioClock{now: time.Unix(0, 0).UTC()}
+	start := clock.Now()
+
+	var (
+		logMu sync.Mutex
+		log   []string
+	)
+	opts = append(opts[:len(opts):len(opts)],
+		WithInitializeStates(s.states),
+		withClock(clock.Now),
+		withEventLog(func(ev StateEvent) {
+			value := strconv.FormatBool(ev.Active)
+			if ev.Unknown {
+				value = "unknown"
+			}
+			logMu.Lock()
+			log = append(log, fmt.Sprintf("%s: %s %s (%s)", ev.Time.Sub(start), ev.Name, value, ev.Cause))
+			logMu.Unlock()
+		}),
+	)
+	sc := NewStateController(opts...)
+
+	var failures []string
+	for _, step := range s.steps {
+		sc.advanceTo(clock, start.Add(step.at))
+		if !step.expect {
+			if err := sc.SetState(step.name, step.active); err != nil {
+				failures = append(failures, fmt.Sprintf("  line %d: at %s: set %s: %v", step.line, step.at, step.name, err))
+			}
+			continue
+		}
+		if !sc.HasState(step.name) {
+			failures = append(failures, fmt.Sprintf("  line %d: at %s: expected %s %s, but it does not exist",
+				step.line, step.at, step.name, activeWord(step.active)))
+		} else if got := sc.IsActive(step.name); got != step.active {
+			failures = append(failures, fmt.Sprintf("  line %d: at %s: expected %s %s, got %s",
+				step.line, step.at, step.name, activeWord(step.active), activeWord(got)))
+		}
+	}
+	// Close waits for async callbacks, so the transcript is complete.
+	sc.Close()
+
+	transcript := strings.Join(log, "\n")
+	if len(failures) == 0 {
+		return transcript, nil
+	}
+	return transcript, fmt.Errorf("%w:\n%s\nchanges:\n  %s", ErrScenarioFailed,
+		strings.Join(failures, "\n"), strings.Join(log, "\n  "))
+}
+
+func activeWord(active bool) string {
+	if active {
+		return "active"
+	}
+	return "inactive"
+}
+
+// scenarioClock is the fake clock of a scenario.
+type scenarioClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *scenarioClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *scenarioClock) set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.mu.Unlock()
+}
+
+// withClock makes the controller read the time from now and fire transitions only when advanced
+// with advanceTo.
+func withClock(now func() time.Time) Option {
+	return func(sc *StateController) {
+		sc.clock = now
+	}
+}
+
+// withEventLog passes every change to log before the callback set by earlier options.
+func withEventLog(log func(StateEvent)) Option {
+	return func(sc *StateController) {
+		cb := sc.onStateChange
+		sc.onStateChange = func(ctx context.Context, ev StateEvent) {
+			log(ev)
+			if cb != nil {
+				cb(ctx, ev)
+			}
+		}
+	}
+}
+
+// advanceTo moves clock forward to t, firing the transitions due on the way in deadline order.
+func (sc *StateController) advanceTo(clock *scenarioClock, t time.Time) {
+	for {
+		next, ok := sc.nextDeadline()
+		if !ok || next.After(t) {
+			break
+		}
+		if next.After(clock.Now()) {
+			clock.set(next)
+		}
+		sc.tick(clock.Now())
+		if sc.shadow != nil {
+			sc.shadow.tick(clock.Now())
+		}
+	}
+	clock.set(t)
+}
+
+// nextDeadline returns the earliest deadline of the transitions scheduled in ticked, including
+// those of the dry-run shadow.
+func (sc *StateController) nextDeadline() (time.Time, bool) {
+	sc.tickMu.Lock()
+	states := make([]*delayedState, 0, len(sc.ticked))
+	for state := range sc.ticked {
+		states = append(states, state)
+	}
+	sc.tickMu.Unlock()
+
+	var (
+		next time.Time
+		ok   bool
+	)
+	for _, state := range states {
+		state.mu.Lock()
+		if !state.removed && state.pending() && (!ok || state.deadline.Before(next)) {
+			next, ok = state.deadline, true
+		}
+		state.mu.Unlock()
+	}
+	if sc.shadow != nil {
+		if shadowNext, shadowOK := sc.shadow.nextDeadline(); shadowOK && (!ok || shadowNext.Before(next)) {
+			next, ok = shadowNext, true
+		}
+	}
+	return next, ok
+}
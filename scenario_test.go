@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScenario(t *testing.T) {
+	s, err := ParseScenario(`
+		# The light stays on for half an hour after the door closes.
+		state door
+		state light delay=30m
+		state alarm delay=1m on-activation
+
+		at t=0 set door=true; at 0 set light=true
+		at t=10s set door=false; at t=10s set light=false
+		at 10s expect door inactive
+		at 30m expect light active
+		at 30m10s expect light inactive
+
+		at 1h set alarm=true
+		at 1h59s expect alarm inactive
+		at 1h1m expect alarm active
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected the scenario to run on a fake clock, took %s", elapsed)
+	}
+}
+
+func TestScenarioExtendBy(t *testing.T) {
+	s, err := ParseScenario(`
+		state light delay=30s extend-by=20s active
+		at 0 set light=false
+		at 10s set light=false # Extends the deadline from 30s to 50s.
+		at 49s expect light active
+		at 50s expect light inactive
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScenarioFailure(t *testing.T) {
+	s, err := ParseScenario("state light delay=30s\nat 0 set light=true\nat 1s set light=false\nat 31s expect light active")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Run()
+	if !errors.Is(err, ErrScenarioFailed) {
+		t.Fatalf("Expected ErrScenarioFailed, got %v", err)
+	}
+	for _, want := range []string{
+		"line 4: at 31s: expected light active, got inactive",
+		"0s: light true (explicit)",
+		"31s: light false (timer)",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("Expected %q in the failure output, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestParseScenarioErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown statement": "wait 10s",
+		"bad time":          "at soon set a=true",
+		"bad value":         "at 0 set a=maybe",
+		"bad expectation":   "at 0 expect a on",
+		"decreasing time":   "at 10s set a=true\nat 5s set a=false",
+		"bad setting":       "state a delay=forever",
+		"duplicate state":   "state a; state a",
+	}
+	for name, src := range tests {
+		if _, err := ParseScenario(src); !errors.Is(err, ErrInvalidScenario) {
+			t.Fatalf("%s: expected ErrInvalidScenario, got %v", name, err)
+		}
+	}
+}
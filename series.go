@@ -0,0 +1,122 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sample is the value of a state from Time on, as recorded with WithSeries.
+type Sample struct {
+	Time    time.Time
+	Active  bool
+	Unknown bool // The value was unknown; Active is the last known value then.
+}
+
+// addSample records the current value of state at now and drops the samples beyond the limits
+// of WithSeries. The latest sample older than the retention is kept, as it holds the value at the
+// start of the retention. Must be called with the state's mutex held.
+func (sc *StateController) addSample(state *delayedState, now time.Time) {
+	state.series = append(state.series, Sample{Time: now, Active: state.IsActive, Unknown: state.Unknown})
+	drop := 0
+	if sc.seriesMax > 0 && len(state.series) > sc.seriesMax {
+		drop = len(state.series) - sc.seriesMax
+	}
+	if sc.seriesRetention > 0 {
+		cutoff := now.Add(-sc.seriesRetention)
+		for drop+1 < len(state.series) && !state.series[drop+1].Time.After(cutoff) {
+			drop++
+		}
+	}
+	state.series = state.series[drop:]
+}
+
+// Series returns the recorded values of the named state between from and to, oldest first; see
+// WithSeries. If the state had a value already at from, it is returned as a sample at from,
+// so the series covers the whole range. A zero from or to leaves the range open at that end.
+// Returns nil if series are not recorded.
+func (sc *StateController) Series(name string, from, to time.Time) ([]Sample, error) {
+	state := sc.lockState(name)
+	if state == nil {
+		return nil, sc.stateError(name, ErrStateNotFound)
+	}
+	defer state.mu.Unlock()
+
+	return selectSamples(state.series, from, to), nil
+}
+
+// selectSamples returns a copy of the samples between from and to; see Series.
+func selectSamples(series []Sample, from, to time.Time) []Sample {
+	var samples []Sample
+	for i, sample := range series {
+		if !to.IsZero() && sample.Time.After(to) {
+			break
+		}
+		if !from.IsZero() && sample.Time.Before(from) {
+			if i+1 == len(series) || series[i+1].Time.After(from) {
+				sample.Time = from
+				samples = append(samples, sample)
+			}
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+// WriteOpenMetrics writes the series of all states between from and to to w in the OpenMetrics
+// text format, as the gauge delayedstate_active with one timestamped point per sample: 1 for
+// active, 0 for inactive and NaN for unknown. Points are labelled with the state name and, if
+// set, the controller name. See Series for from and to.
+func (sc *StateController) WriteOpenMetrics(w io.Writer, from, to time.Time) error {
+	states := sc.loadIndex()
+	names := make([]string, 0, len(states))
+	for name := range states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# TYPE delayedstate_active gauge")
+	fmt.Fprintln(bw, "# HELP delayedstate_active Whether the state is active.")
+	for _, name := range names {
+		samples, err := sc.Series(name, from, to)
+		if err != nil {
+			continue // Removed meanwhile.
+		}
+		labels := `state="` + escapeLabel(name) + `"`
+		if sc.name != "" {
+			labels = `controller="` + escapeLabel(sc.name) + `",` + labels
+		}
+		for _, sample := range samples {
+			value := "0"
+			switch {
+			case sample.Unknown:
+				value = "NaN"
+			case sample.Active:
+				value = "1"
+			}
+			ts := strconv.FormatFloat(float64(sample.Time.UnixNano())/1e9, 'f', -1, 64)
+			fmt.Fprintf(bw, "delayedstate_active{%s} %s %s\n", labels, value, ts)
+		}
+	}
+	fmt.Fprintln(bw, "# EOF")
+	return bw.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value for the OpenMetrics text format.
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
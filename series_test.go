@@ -0,0 +1,83 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeries(t *testing.T) {
+	sc := NewStateController(WithSeries(0, 3))
+	defer sc.Close()
+	sc.AddState("door", State{})
+	sc.ForceState("door", true)
+	sc.ForceState("door", false)
+
+	samples, err := sc.Series("door", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 3 || samples[0].Active || !samples[1].Active || samples[2].Active {
+		t.Fatalf("Expected the initial value and both changes, got %+v", samples)
+	}
+
+	from := samples[1].Time.Add(time.Nanosecond)
+	if got, _ := sc.Series("door", from, time.Time{}); len(got) != 2 || !got[0].Active || !got[0].Time.Equal(from) {
+		t.Fatalf("Expected the value at from to start the series, got %+v", got)
+	}
+	if got, _ := sc.Series("door", time.Time{}, samples[0].Time); len(got) != 1 {
+		t.Fatalf("Expected only the initial value, got %+v", got)
+	}
+
+	sc.ForceState("door", true)
+	if got, _ := sc.Series("door", time.Time{}, time.Time{}); len(got) != 3 || !got[0].Active {
+		t.Fatalf("Expected the oldest sample to be dropped, got %+v", got)
+	}
+	if _, err := sc.Series("missing", time.Time{}, time.Time{}); err == nil {
+		t.Fatal("Expected an error for a missing state")
+	}
+}
+
+func TestAddSampleRetention(t *testing.T) {
+	sc := NewStateController(WithSeries(time.Minute, 0))
+	defer sc.Close()
+	state := &delayedState{}
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		state.IsActive = i%2 == 1
+		sc.addSample(state, start.Add(time.Duration(i)*30*time.Second))
+	}
+	// The sample at 60s holds the value at the start of the retention.
+	if len(state.series) != 3 || !state.series[0].Time.Equal(start.Add(time.Minute)) {
+		t.Fatalf("Expected samples from 60s on, got %+v", state.series)
+	}
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	sc := NewStateController(WithName("hall"), WithSeries(0, 0))
+	defer sc.Close()
+	sc.AddState("light", State{})
+	sc.SetUnknown("light")
+
+	var b strings.Builder
+	if err := sc.WriteOpenMetrics(&b, time.Time{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	samples, _ := sc.Series("light", time.Time{}, time.Time{})
+	ts := func(tm time.Time) string { return strconv.FormatFloat(float64(tm.UnixNano())/1e9, 'f', -1, 64) }
+	want := "# TYPE delayedstate_active gauge\n" +
+		"# HELP delayedstate_active Whether the state is active.\n" +
+		`delayedstate_active{controller="hall",state="light"} 0 ` + ts(samples[0].Time) + "\n" +
+		`delayedstate_active{controller="hall",state="light"} NaN ` + ts(samples[1].Time) + "\n" +
+		"# EOF\n"
+	if b.String() != want {
+		t.Fatalf("Expected\n%s\ngot\n%s", want, b.String())
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// StallKind tells what held up the controller in a Stall.
+type StallKind int
+
+const (
+	// StallLock is the controller lock held beyond the threshold, e.g. by a slow RemoveWhere
+	// predicate. The controller lock is only taken to add, remove and register states; SetState
+	// and the other per-state methods take the state's own lock, which is not timed.
+	StallLock StallKind = iota
+	// StallCallback is an onStateChange callback running beyond the threshold. A synchronous
+	// callback blocks the call that caused the change; an async one blocks its worker's queue.
+	StallCallback
+)
+
+// String returns the name of the kind.
+func (k StallKind) String() string {
+	switch k {
+	case StallLock:
+		return "lock"
+	case StallCallback:
+		return "callback"
+	default:
+		return fmt.Sprintf("StallKind(%d)", int(k))
+	}
+}
+
+// Stall reports a lock hold or callback that exceeded the threshold of WithStallDetection.
+type Stall struct {
+	Controller string        // Name of the controller set with WithName, if any.
+	Kind       StallKind     // What held up the controller.
+	Name       string        // Name of the state whose callback stalled; empty for StallLock.
+	Held       time.Duration // How long the lock was held or the callback ran.
+}
+
+// HoldTimes is a snapshot of the longest lock holds and callbacks recorded with WithStallDetection.
+type HoldTimes struct {
+	MaxLock     time.Duration // Longest hold of the controller lock; see StallLock.
+	MaxCallback time.Duration // Longest onStateChange callback.
+	Stalls      uint64        // Number of holds and callbacks beyond the threshold.
+}
+
+// holdStats accumulates lock hold and callback times.
+type holdStats struct {
+	mu          sync.Mutex
+	maxLock     time.Duration
+	maxCallback time.Duration
+	stalls      uint64
+}
+
+func (h *holdStats) observe(kind StallKind, held, threshold time.Duration) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch kind {
+	case StallLock:
+		if held > h.maxLock {
+			h.maxLock = held
+		}
+	case StallCallback:
+		if held > h.maxCallback {
+			h.maxCallback = held
+		}
+	}
+	if held <= threshold {
+		return false
+	}
+	h.stalls++
+	return true
+}
+
+func (h *holdStats) snapshot() HoldTimes {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return HoldTimes{MaxLock: h.maxLock, MaxCallback: h.maxCallback, Stalls: h.stalls}
+}
+
+// HoldTimes returns the longest controller lock hold and onStateChange callback over the lifetime
+// of the controller. It is only recorded with WithStallDetection; otherwise it is always zero.
+// The locks of individual states, taken by SetState and the other per-state methods, are not
+// timed.
+func (sc *StateController) HoldTimes() HoldTimes {
+	return sc.holds.snapshot()
+}
+
+// lockController acquires the controller lock and returns the time it was acquired, if hold
+// times are recorded. Pass the result to unlockController.
+func (sc *StateController) lockController() time.Time {
+	sc.mu.Lock()
+	if sc.stallThreshold <= 0 {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// unlockController releases the controller lock acquired at locked by lockController and
+// reports a stall, if any, after releasing it.
+func (sc *StateController) unlockController(locked time.Time) {
+	if locked.IsZero() {
+		sc.mu.Unlock()
+		return
+	}
+	held := time.Since(locked)
+	sc.mu.Unlock()
+	sc.observeHold(StallLock, "", held)
+}
+
+// observeHold records a lock hold or callback of held and reports it if it exceeds the threshold.
+// Must be called without holding any controller or state lock.
+func (sc *StateController) observeHold(kind StallKind, name string, held time.Duration) {
+	if sc.holds.observe(kind, held, sc.stallThreshold) && sc.onStall != nil {
+		sc.onStall(Stall{Controller: sc.name, Kind: kind, Name: name, Held: held})
+	}
+}
+
+// timeCallback wraps cb to record its run times for WithStallDetection.
+func (sc *StateController) timeCallback(cb StateEventCallback) StateEventCallback {
+	return func(ctx context.Context, ev StateEvent) {
+		start := time.Now()
+		cb(ctx, ev)
+		sc.observeHold(StallCallback, ev.Name, time.Since(start))
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithStallDetection(t *testing.T) {
+	var stalls []Stall
+	sc := NewStateController(
+		WithName("doors"),
+		WithStallDetection(5*time.Millisecond, func(s Stall) {
+			stalls = append(stalls, s)
+		}),
+		WithOnStateChange(func(name string, active bool) {
+			if name == "slow" {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}),
+	)
+	sc.AddState("fast", State{})
+	sc.AddState("slow", State{})
+
+	sc.SetState("fast", true)
+	if len(stalls) != 0 {
+		t.Fatalf("Expected no stalls, got %+v", stalls)
+	}
+
+	sc.SetState("slow", true)
+	if len(stalls) != 1 || stalls[0].Kind != StallCallback || stalls[0].Name != "slow" || stalls[0].Controller != "doors" {
+		t.Fatalf("Expected a callback stall of slow, got %+v", stalls)
+	}
+
+	sc.RemoveWhere(func(name string, state State) bool {
+		time.Sleep(10 * time.Millisecond)
+		return false
+	})
+	if len(stalls) != 2 || stalls[1].Kind != StallLock || stalls[1].Held < 10*time.Millisecond {
+		t.Fatalf("Expected a lock stall, got %+v", stalls)
+	}
+
+	h := sc.HoldTimes()
+	if h.Stalls != 2 || h.MaxCallback < 10*time.Millisecond || h.MaxLock < 10*time.Millisecond {
+		t.Fatalf("Expected hold times of both stalls, got %+v", h)
+	}
+}
+
+func TestHoldTimesWithoutStallDetection(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{})
+	sc.RemoveState("state1")
+
+	if h := sc.HoldTimes(); h != (HoldTimes{}) {
+		t.Fatalf("Expected no recorded hold times, got %+v", h)
+	}
+}
+
+func TestStallKindString(t *testing.T) {
+	tests := map[StallKind]string{
+		StallLock:     "lock",
+		StallCallback: "callback",
+		StallKind(42): "StallKind(42)",
+	}
+	for kind, want := range tests {
+		if got := kind.String(); got != want {
+			t.Fatalf("Expected %q, got %q", want, got)
+		}
+	}
+}
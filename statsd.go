@@ -0,0 +1,166 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultStatsdInterval is how often gauges are sent if StatsdConfig.Interval is not set.
+	defaultStatsdInterval = 10 * time.Second
+	// maxStatsdPacket keeps packets within the usual MTU; several metrics share one packet.
+	maxStatsdPacket = 1432
+	// statsdBuffer is the number of changes held for the emitter before the oldest are dropped.
+	statsdBuffer = 1024
+)
+
+// StatsdConfig configures the metrics sent by WithStatsd.
+type StatsdConfig struct {
+	Prefix   string        // Prepended to metric names, e.g. "myapp.".
+	Tagged   bool          // Put state names in a DogStatsD "state" tag instead of the metric names.
+	Tags     []string      // DogStatsD tags added to all metrics, e.g. "env:prod"; requires a DogStatsD agent.
+	Interval time.Duration // How often gauges are sent; 10 seconds if zero.
+}
+
+// WithStatsd sends metrics in the statsd line protocol to w, typically a UDP connection to a
+// statsd or Datadog agent, for deployments without Prometheus: the counter "transitions" for each
+// change of a state's value, and every interval the gauges "active" (1 or 0, not sent while
+// unknown) per state and the totals "states" and "pending". Per-state metrics are named
+// "<prefix><state>.<metric>", or with StatsdConfig.Tagged "<prefix><metric>" with the tag
+// "state:<state>". Metrics are sent from a goroutine of their own, started once the controller
+// is set up and stopped by Close; write errors are ignored, as is usual for statsd.
+func WithStatsd(w io.Writer, cfg StatsdConfig) Option {
+	return WithOnStart(func(sc *StateController) {
+		e := &statsdEmitter{w: w, cfg: cfg}
+		if e.cfg.Interval <= 0 {
+			e.cfg.Interval = defaultStatsdInterval
+		}
+		sub := sc.Subscribe(statsdBuffer)
+		sc.routines.goTracked(func() { e.run(sc, sub) })
+	})
+}
+
+// statsdEmitter batches metrics into packets for WithStatsd.
+type statsdEmitter struct {
+	w      io.Writer
+	cfg    StatsdConfig
+	packet []byte
+}
+
+// run sends a counter for each change received on sub and the gauges every interval until sub
+// is closed.
+func (e *statsdEmitter) run(sc *StateController, sub *Subscription) {
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			e.count(ev)
+			// Send changes arriving together in as few packets as possible.
+			for more := true; more; {
+				select {
+				case ev, ok := <-sub.C():
+					if !ok {
+						e.flush()
+						return
+					}
+					e.count(ev)
+				default:
+					more = false
+				}
+			}
+		case <-ticker.C:
+			e.gauges(sc)
+		}
+		e.flush()
+	}
+}
+
+// count adds the counter for ev if it is a change of value.
+func (e *statsdEmitter) count(ev StateEvent) {
+	if !ev.Unknown && !ev.Suppressed {
+		e.metric(ev.Name, "transitions", "1", "c")
+	}
+}
+
+// gauges adds the gauges of all states and the totals.
+func (e *statsdEmitter) gauges(sc *StateController) {
+	states := sc.loadIndex()
+	for name, state := range states {
+		state.mu.Lock()
+		active, unknown := state.IsActive, state.Unknown
+		state.mu.Unlock()
+		if unknown {
+			continue
+		}
+		value := "0"
+		if active {
+			value = "1"
+		}
+		e.metric(name, "active", value, "g")
+	}
+	e.metric("", "states", strconv.Itoa(len(states)), "g")
+	e.metric("", "pending", strconv.Itoa(int(atomic.LoadInt32(&sc.pendingCount))), "g")
+}
+
+// metric adds a metric of the given statsd type to the packet, sending the packet first if the
+// metric does not fit. An empty state marks a controller-wide metric.
+func (e *statsdEmitter) metric(state, name, value, typ string) {
+	var line strings.Builder
+	line.WriteString(e.cfg.Prefix)
+	if state != "" && !e.cfg.Tagged {
+		line.WriteString(sanitizeStatsd(state))
+		line.WriteByte('.')
+	}
+	line.WriteString(name)
+	line.WriteByte(':')
+	line.WriteString(value)
+	line.WriteByte('|')
+	line.WriteString(typ)
+
+	tags := e.cfg.Tags
+	if state != "" && e.cfg.Tagged {
+		tags = append(tags[:len(tags):len(tags)], "state:"+sanitizeStatsd(state))
+	}
+	if len(tags) > 0 {
+		line.WriteString("|#")
+		line.WriteString(strings.Join(tags, ","))
+	}
+
+	if len(e.packet) > 0 && len(e.packet)+1+line.Len() > maxStatsdPacket {
+		e.flush()
+	}
+	if len(e.packet) > 0 {
+		e.packet = append(e.packet, '\n')
+	}
+	e.packet = append(e.packet, line.String()...)
+}
+
+// flush sends the packet, if any.
+func (e *statsdEmitter) flush() {
+	if len(e.packet) == 0 {
+		return
+	}
+	_, _ = e.w.Write(e.packet)
+	e.packet = e.packet[:0]
+}
+
+var statsdReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+
+// sanitizeStatsd replaces the characters with a meaning in the statsd line protocol.
+func sanitizeStatsd(s string) string {
+	return statsdReplacer.Replace(s)
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// packetWriter collects the packets written to it.
+type packetWriter struct {
+	mu      sync.Mutex
+	packets []string
+}
+
+func (w *packetWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.packets = append(w.packets, string(p))
+	return len(p), nil
+}
+
+// lines returns the metrics of all packets, sorted.
+func (w *packetWriter) lines() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var lines []string
+	for _, p := range w.packets {
+		lines = append(lines, strings.Split(p, "\n")...)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestWithStatsd(t *testing.T) {
+	w := &packetWriter{}
+	sc := NewStateController(
+		WithInitializeStates(map[string]State{"door:front": {}}),
+		WithStatsd(w, StatsdConfig{Prefix: "app.", Interval: 10 * time.Millisecond}),
+	)
+	sc.ForceState("door:front", true)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		lines := strings.Join(w.lines(), "\n")
+		if strings.Contains(lines, "app.door_front.transitions:1|c") && strings.Contains(lines, "app.door_front.active:1|g") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a counter and a gauge for the state, got %q", lines)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	sc.Close()
+
+	lines := strings.Join(w.lines(), "\n")
+	if !strings.Contains(lines, "app.states:1|g") || !strings.Contains(lines, "app.pending:0|g") {
+		t.Fatalf("Expected the totals, got %q", lines)
+	}
+}
+
+func TestStatsdTagged(t *testing.T) {
+	w := &packetWriter{}
+	e := &statsdEmitter{w: w, cfg: StatsdConfig{Tagged: true, Tags: []string{"env:prod"}}}
+	e.count(StateEvent{Name: "door", Active: true})
+	e.metric("", "states", "1", "g")
+	e.flush()
+
+	want := []string{"states:1|g|#env:prod", "transitions:1|c|#env:prod,state:door"}
+	if got := w.lines(); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("Expected %q, got %q", want, got)
+	}
+	if len(w.packets) != 1 {
+		t.Fatalf("Expected the metrics to share one packet, got %d", len(w.packets))
+	}
+}
@@ -0,0 +1,449 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrConsumerAttached is returned by SubscribeDurable if the named consumer already has an
+// open subscription.
+var ErrConsumerAttached = errors.New("consumer already attached")
+
+// Edge selects changes by direction.
+type Edge int
+
+const (
+	// EdgeRising selects activations.
+	EdgeRising Edge = 1 << iota
+	// EdgeFalling selects deactivations.
+	EdgeFalling
+
+	// EdgeBoth selects all changes.
+	EdgeBoth = EdgeRising | EdgeFalling
+)
+
+// SubscribeFilter restricts the changes delivered to a subscription. The filter is evaluated
+// by the controller before a change is buffered, so filtered-out changes cost the consumer
+// nothing. Zero-value fields match everything.
+type SubscribeFilter struct {
+	Pattern string   // path.Match pattern for state names, e.g. "door/*". A malformed pattern matches nothing.
+	Tags    []string // Matches states carrying at least one of the tags.
+	Edges   Edge     // Matches changes in the given directions.
+}
+
+// SubscribeOption configures a subscription.
+type SubscribeOption func(*consumer)
+
+// WithFilter delivers only the changes matching filter.
+func WithFilter(filter SubscribeFilter) SubscribeOption {
+	return func(c *consumer) {
+		c.filter = filter
+	}
+}
+
+// WithCoalesce delivers at most one change per state per interval: changes are held back and
+// at the end of the interval only the latest change of each state is delivered. If a state
+// flickered back to the value last delivered, nothing is delivered for it. This trades up to
+// one interval of latency for much less traffic, e.g. for UI consumers.
+func WithCoalesce(interval time.Duration) SubscribeOption {
+	return func(c *consumer) {
+		c.coalesce = interval
+	}
+}
+
+// Subscription receives state changes on the channel returned by C.
+type Subscription struct {
+	consumer *consumer
+	c        chan StateEvent
+	closed   chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// consumer buffers changes for a subscription. Durable consumers outlive their subscriptions
+// and keep unacknowledged changes for replay; ephemeral ones drop changes once sent.
+type consumer struct {
+	dropped uint64 // Accessed atomically; kept first for 64-bit alignment on 32-bit platforms.
+
+	name     string // Empty for ephemeral consumers.
+	durable  bool
+	max      int
+	filter   SubscribeFilter
+	coalesce time.Duration
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      []StateEvent
+	next     int // Index into buf of the first change not yet sent to the subscription.
+	attached *Subscription
+
+	// Changes held back for coalescing; see WithCoalesce.
+	held       map[string]StateEvent
+	heldOrder  []string
+	lastValue  map[string]bool
+	flushTimer *trackedTimer
+	routines   *routines // The controller's, counting flushTimer.
+}
+
+// Subscribe returns a subscription receiving every subsequent state change. Up to buffer
+// changes are held for a slow reader; beyond that the oldest changes are dropped.
+// The subscription must be closed when no longer needed.
+func (sc *StateController) Subscribe(buffer int, opts ...SubscribeOption) *Subscription {
+	c := newConsumer(&sc.routines, "", false, buffer, opts...)
+
+	sc.subsMu.Lock()
+	sc.consumers[c] = struct{}{}
+	sc.subsMu.Unlock()
+	atomic.AddInt32(&sc.consumerCount, 1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.attach(&sc.routines, func() {
+		sc.subsMu.Lock()
+		delete(sc.consumers, c)
+		sc.subsMu.Unlock()
+		atomic.AddInt32(&sc.consumerCount, -1)
+	})
+}
+
+// SubscribeDurable returns a subscription for the named durable consumer. Changes are buffered
+// for the consumer (up to buffer, dropping the oldest) from its first subscription on, including
+// while it has no open subscription, until they are acknowledged with Ack. When the consumer
+// subscribes again, all unacknowledged changes are replayed in order before new ones.
+// The options of the consumer's first subscription apply until the consumer is removed.
+// Returns ErrConsumerAttached if the consumer already has an open subscription.
+func (sc *StateController) SubscribeDurable(name string, buffer int, opts ...SubscribeOption) (*Subscription, error) {
+	sc.subsMu.Lock()
+	c, exists := sc.durable[name]
+	if !exists {
+		c = newConsumer(&sc.routines, name, true, buffer, opts...)
+		sc.durable[name] = c
+		sc.consumers[c] = struct{}{}
+		atomic.AddInt32(&sc.consumerCount, 1)
+	}
+	sc.subsMu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.attached != nil {
+		if sc.name != "" {
+			return nil, fmt.Errorf("%s: consumer %s: %w", sc.name, name, ErrConsumerAttached)
+		}
+		return nil, fmt.Errorf("consumer %s: %w", name, ErrConsumerAttached)
+	}
+	c.next = 0 // Replay everything not yet acknowledged.
+	return c.attach(&sc.routines, nil), nil
+}
+
+// RemoveConsumer discards a durable consumer and its buffered changes, closing its
+// subscription if open.
+func (sc *StateController) RemoveConsumer(name string) {
+	sc.subsMu.Lock()
+	c, exists := sc.durable[name]
+	if exists {
+		delete(sc.durable, name)
+		delete(sc.consumers, c)
+	}
+	sc.subsMu.Unlock()
+
+	if !exists {
+		return
+	}
+	atomic.AddInt32(&sc.consumerCount, -1)
+
+	c.mu.Lock()
+	sub := c.attached
+	c.mu.Unlock()
+	if sub != nil {
+		sub.Close()
+	}
+}
+
+// C returns the channel on which changes are delivered. It is closed by Close.
+func (s *Subscription) C() <-chan StateEvent {
+	return s.c
+}
+
+// Ack acknowledges all changes up to and including seq, so they are not replayed.
+// Has no effect for subscriptions created by Subscribe.
+func (s *Subscription) Ack(seq uint64) {
+	c := s.consumer
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.durable {
+		return
+	}
+	n := 0
+	for n < c.next && c.buf[n].Seq <= seq {
+		n++
+	}
+	c.buf = c.buf[n:]
+	c.next -= n
+}
+
+// Dropped returns the number of changes dropped because the buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.consumer.dropped)
+}
+
+// Close stops delivery and closes the channel. For durable consumers, changes keep being
+// buffered until the consumer subscribes again or is removed.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		// Close under the consumer's mutex, so run cannot miss the wakeup between checking
+		// closed and waiting.
+		c := s.consumer
+		c.mu.Lock()
+		close(s.closed)
+		c.cond.Broadcast()
+		c.mu.Unlock()
+		<-s.done
+	})
+}
+
+func newConsumer(r *routines, name string, durable bool, max int, opts ...SubscribeOption) *consumer {
+	if max < 1 {
+		max = 1
+	}
+	c := &consumer{
+		routines:  r,
+		name:      name,
+		durable:   durable,
+		max:       max,
+		held:      make(map[string]StateEvent),
+		lastValue: make(map[string]bool),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// match reports whether change passes the filter.
+func (f SubscribeFilter) match(change StateEvent) bool {
+	if f.Pattern != "" {
+		if ok, _ := path.Match(f.Pattern, change.Name); !ok {
+			return false
+		}
+	}
+
+	if f.Edges != 0 {
+		edge := EdgeFalling
+		if change.Active {
+			edge = EdgeRising
+		}
+		if f.Edges&edge == 0 {
+			return false
+		}
+	}
+
+	if len(f.Tags) > 0 {
+		for _, want := range f.Tags {
+			for _, tag := range change.Tags {
+				if tag == want {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// attach creates a subscription and starts its delivery goroutine, counted in r. onClose, if not
+// nil, runs after the subscription is closed. Must be called with c.mu held.
+func (c *consumer) attach(r *routines, onClose func()) *Subscription {
+	s := &Subscription{
+		consumer: c,
+		c:        make(chan StateEvent),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	c.attached = s
+
+	r.goTracked(func() {
+		defer close(s.done)
+		defer close(s.c)
+		c.run(s)
+
+		c.mu.Lock()
+		c.attached = nil
+		c.mu.Unlock()
+		if onClose != nil {
+			onClose()
+		}
+	})
+
+	return s
+}
+
+// run sends buffered changes to s until s is closed.
+func (c *consumer) run(s *Subscription) {
+	for {
+		c.mu.Lock()
+		for c.next >= len(c.buf) && !isClosed(s.closed) {
+			c.cond.Wait()
+		}
+		if isClosed(s.closed) {
+			c.mu.Unlock()
+			return
+		}
+		ev := c.buf[c.next]
+		if !c.durable {
+			// Ephemeral consumers don't replay, so the change leaves the buffer right away.
+			c.buf = c.buf[1:]
+		}
+		c.mu.Unlock()
+
+		select {
+		case s.c <- ev:
+		case <-s.closed:
+			return
+		}
+
+		if c.durable {
+			c.mu.Lock()
+			// The change may have been dropped meanwhile; only advance if it is still next.
+			if c.next < len(c.buf) && c.buf[c.next].Seq == ev.Seq {
+				c.next++
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// push buffers ev, dropping the oldest buffered change if the buffer is full.
+// With WithCoalesce, ev is held back until the next coalescing flush instead, unless it is
+// critical or suppressed; a critical change supersedes the held change of its state.
+func (c *consumer) push(ev StateEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.coalesce > 0 && !ev.Suppressed {
+		if ev.Priority != PriorityCritical {
+			c.hold(ev)
+			return
+		}
+		c.release(ev.Name)
+		c.lastValue[ev.Name] = ev.Active
+	}
+	c.append(ev)
+}
+
+// release forgets the held change of the named state, if any. Must be called with c.mu held.
+func (c *consumer) release(name string) {
+	if _, held := c.held[name]; !held {
+		return
+	}
+	delete(c.held, name)
+	for i, n := range c.heldOrder {
+		if n == name {
+			c.heldOrder = append(c.heldOrder[:i], c.heldOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+// hold keeps ev as the latest change of its state until the next coalescing flush.
+// Must be called with c.mu held.
+func (c *consumer) hold(ev StateEvent) {
+	if _, held := c.held[ev.Name]; !held {
+		c.heldOrder = append(c.heldOrder, ev.Name)
+	}
+	c.held[ev.Name] = ev
+
+	if c.flushTimer == nil {
+		c.flushTimer = c.routines.afterFunc(c.coalesce, c.flushHeld)
+	}
+}
+
+// flushHeld buffers the latest held change of every state, unless the value equals the one
+// last buffered for that state, i.e. the state only flickered during the interval.
+func (c *consumer) flushHeld() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, name := range c.heldOrder {
+		ev := c.held[name]
+		if last, ok := c.lastValue[name]; !ok || last != ev.Active {
+			c.lastValue[name] = ev.Active
+			c.append(ev)
+		}
+	}
+	c.held = make(map[string]StateEvent)
+	c.heldOrder = nil
+	c.flushTimer = nil
+}
+
+// append buffers ev for delivery. Must be called with c.mu held.
+func (c *consumer) append(ev StateEvent) {
+	if len(c.buf) >= c.max {
+		c.buf = c.buf[1:]
+		if c.next > 0 {
+			c.next--
+		}
+		atomic.AddUint64(&c.dropped, 1)
+	}
+	c.buf = append(c.buf, ev)
+	c.cond.Signal()
+}
+
+// broadcast hands ev to all consumers.
+func (sc *StateController) broadcast(ev callbackEvent) {
+	if atomic.LoadInt32(&sc.consumerCount) == 0 {
+		return
+	}
+
+	sc.subsMu.RLock()
+	defer sc.subsMu.RUnlock()
+	for c := range sc.consumers {
+		if c.filter.match(ev.event) {
+			c.push(ev.event)
+		}
+	}
+}
+
+// closeSubscriptions stops the coalescing flushes of all consumers and closes all open
+// subscriptions.
+func (sc *StateController) closeSubscriptions() {
+	sc.subsMu.RLock()
+	var subs []*Subscription
+	for c := range sc.consumers {
+		c.mu.Lock()
+		if c.flushTimer != nil {
+			c.flushTimer.Stop()
+			c.flushTimer = nil
+		}
+		if c.attached != nil {
+			subs = append(subs, c.attached)
+		}
+		c.mu.Unlock()
+	}
+	sc.subsMu.RUnlock()
+
+	for _, s := range subs {
+		s.Close()
+	}
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
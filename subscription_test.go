@@ -0,0 +1,284 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, sub *Subscription) StateEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-sub.C():
+		if !ok {
+			t.Fatal("Expected a change, subscription channel is closed")
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a change")
+	}
+	return StateEvent{}
+}
+
+func TestSubscribe(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: 10 * time.Millisecond})
+
+	sub := sc.Subscribe(8)
+	defer sub.Close()
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false)
+
+	first := receive(t, sub)
+	if first.Name != "state1" || !first.Active {
+		t.Fatalf("Expected activation of state1, got %+v", first)
+	}
+	second := receive(t, sub)
+	if second.Active || second.Seq <= first.Seq {
+		t.Fatalf("Expected later deactivation of state1, got %+v", second)
+	}
+}
+
+func TestSubscriptionCloseClosesChannel(t *testing.T) {
+	sc := NewStateController()
+	sub := sc.Subscribe(1)
+	sub.Close()
+	sub.Close() // idempotent
+
+	if _, ok := <-sub.C(); ok {
+		t.Fatal("Expected channel to be closed")
+	}
+	if sc.consumerCount != 0 {
+		t.Fatalf("Expected no consumers after Close, got %d", sc.consumerCount)
+	}
+}
+
+func TestSubscribeDropsOldestWhenFull(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("a", State{})
+	sc.AddState("b", State{})
+	sc.AddState("c", State{})
+
+	sub := sc.Subscribe(1)
+	defer sub.Close()
+
+	// Nobody reads: the first change is held by the delivery goroutine, the second is
+	// buffered and replaced by the third.
+	sc.SetState("a", true)
+	time.Sleep(10 * time.Millisecond)
+	sc.SetState("b", true)
+	sc.SetState("c", true)
+
+	if got := receive(t, sub).Name; got != "a" {
+		t.Fatalf("Expected a, got %s", got)
+	}
+	if got := receive(t, sub).Name; got != "c" {
+		t.Fatalf("Expected c, got %s", got)
+	}
+	if sub.Dropped() != 1 {
+		t.Fatalf("Expected 1 dropped change, got %d", sub.Dropped())
+	}
+}
+
+func TestSubscribeDurableReplaysUnacknowledged(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("a", State{})
+	sc.AddState("b", State{})
+	sc.AddState("c", State{})
+
+	sub, err := sc.SubscribeDurable("worker", 8)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	sc.SetState("a", true)
+	sc.SetState("b", true)
+	a := receive(t, sub)
+	receive(t, sub) // b is received but not acknowledged
+	sub.Ack(a.Seq)
+	sub.Close()
+
+	// Changes while the consumer is detached are buffered.
+	sc.SetState("c", true)
+
+	sub, err = sc.SubscribeDurable("worker", 8)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer sub.Close()
+
+	if got := receive(t, sub).Name; got != "b" {
+		t.Fatalf("Expected unacknowledged b to be replayed, got %s", got)
+	}
+	if got := receive(t, sub).Name; got != "c" {
+		t.Fatalf("Expected buffered c, got %s", got)
+	}
+}
+
+func TestSubscribeDurableAlreadyAttached(t *testing.T) {
+	sc := NewStateController()
+
+	sub, _ := sc.SubscribeDurable("worker", 8)
+	defer sub.Close()
+
+	_, err := sc.SubscribeDurable("worker", 8)
+	if !errors.Is(err, ErrConsumerAttached) {
+		t.Fatalf("Expected ErrConsumerAttached, got %v", err)
+	}
+}
+
+func TestRemoveConsumer(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("a", State{})
+
+	sub, _ := sc.SubscribeDurable("worker", 8)
+	sc.RemoveConsumer("worker")
+
+	if _, ok := <-sub.C(); ok {
+		t.Fatal("Expected subscription to be closed by RemoveConsumer")
+	}
+
+	sc.SetState("a", true)
+
+	sub, _ = sc.SubscribeDurable("worker", 8)
+	defer sub.Close()
+
+	select {
+	case ev := <-sub.C():
+		t.Fatalf("Expected no replay after RemoveConsumer, got %+v", ev)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestCloseClosesSubscriptions(t *testing.T) {
+	sc := NewStateController()
+	sub := sc.Subscribe(1)
+
+	sc.Close()
+
+	if _, ok := <-sub.C(); ok {
+		t.Fatal("Expected subscription to be closed by Close")
+	}
+}
+
+func TestSubscribeFilter(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("door/front", State{Tags: []string{"zone1"}})
+	sc.AddState("door/back", State{Tags: []string{"zone2"}})
+	sc.AddState("window/front", State{Tags: []string{"zone1"}})
+
+	sub := sc.Subscribe(8, WithFilter(SubscribeFilter{
+		Pattern: "door/*",
+		Tags:    []string{"zone1", "zone3"},
+		Edges:   EdgeFalling,
+	}))
+	defer sub.Close()
+
+	sc.SetState("door/front", true)   // wrong edge
+	sc.SetState("door/back", true)    // wrong tag
+	sc.SetState("window/front", true) // wrong pattern
+	sc.Reset("window/front")          // wrong pattern
+	sc.Reset("door/back")             // wrong tag
+	sc.Reset("door/front")            // match
+
+	ev := receive(t, sub)
+	if ev.Name != "door/front" || ev.Active {
+		t.Fatalf("Expected deactivation of door/front, got %+v", ev)
+	}
+	if len(ev.Tags) != 1 || ev.Tags[0] != "zone1" {
+		t.Fatalf("Expected tags [zone1], got %v", ev.Tags)
+	}
+
+	select {
+	case ev := <-sub.C():
+		t.Fatalf("Expected no further changes, got %+v", ev)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestSubscribeFilterMatch(t *testing.T) {
+	tests := []struct {
+		filter SubscribeFilter
+		change StateEvent
+		want   bool
+	}{
+		{SubscribeFilter{}, StateEvent{Name: "a"}, true},
+		{SubscribeFilter{Pattern: "a*"}, StateEvent{Name: "ab"}, true},
+		{SubscribeFilter{Pattern: "[a"}, StateEvent{Name: "a"}, false},
+		{SubscribeFilter{Edges: EdgeRising}, StateEvent{Active: true}, true},
+		{SubscribeFilter{Edges: EdgeRising}, StateEvent{Active: false}, false},
+		{SubscribeFilter{Edges: EdgeBoth}, StateEvent{Active: false}, true},
+		{SubscribeFilter{Tags: []string{"x"}}, StateEvent{}, false},
+		{SubscribeFilter{Tags: []string{"x"}}, StateEvent{Tags: []string{"y", "x"}}, true},
+	}
+
+	for i, tt := range tests {
+		if got := tt.filter.match(tt.change); got != tt.want {
+			t.Fatalf("Case %d: expected %v, got %v", i, tt.want, got)
+		}
+	}
+}
+
+func TestSubscribeCoalesce(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("a", State{})
+	sc.AddState("b", State{})
+
+	sub := sc.Subscribe(8, WithCoalesce(30*time.Millisecond))
+	defer sub.Close()
+
+	// Within one interval: a flickers and ends active, b is activated once.
+	sc.SetState("a", true)
+	sc.Reset("a")
+	sc.SetState("a", true)
+	sc.SetState("b", true)
+
+	ev := receive(t, sub)
+	if ev.Name != "a" || !ev.Active {
+		t.Fatalf("Expected latest value of a, got %+v", ev)
+	}
+	ev = receive(t, sub)
+	if ev.Name != "b" || !ev.Active {
+		t.Fatalf("Expected latest value of b, got %+v", ev)
+	}
+
+	// Next interval: b flickers back to its delivered value, so nothing is delivered for it.
+	sc.Reset("b")
+	sc.SetState("b", true)
+	sc.Reset("a")
+
+	ev = receive(t, sub)
+	if ev.Name != "a" || ev.Active {
+		t.Fatalf("Expected deactivation of a, got %+v", ev)
+	}
+
+	select {
+	case ev := <-sub.C():
+		t.Fatalf("Expected flicker of b to be coalesced away, got %+v", ev)
+	case <-time.After(60 * time.Millisecond):
+	}
+}
+
+func TestSubscribeForcedChange(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Delay: time.Second})
+	sub := sc.Subscribe(10)
+	defer sub.Close()
+
+	sc.SetState("state1", true)
+	sc.ForceState("state1", false)
+
+	if ev := receive(t, sub); ev.Cause != CauseExplicit {
+		t.Fatalf("Expected explicit change, got %s", ev.Cause)
+	}
+	if ev := receive(t, sub); ev.Cause != CauseForced || ev.Active {
+		t.Fatalf("Expected forced deactivation, got %+v", ev)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// SuppressPolicy decides what happens to requests for a state while it is suppressed.
+type SuppressPolicy int
+
+const (
+	// SuppressBuffer keeps the latest request per state and applies it when the suppression
+	// ends. This is the default.
+	SuppressBuffer SuppressPolicy = iota
+	// SuppressDrop discards requests; states keep their values after the suppression until
+	// set again.
+	SuppressDrop
+)
+
+// String returns the name of the policy.
+func (p SuppressPolicy) String() string {
+	switch p {
+	case SuppressBuffer:
+		return "buffer"
+	case SuppressDrop:
+		return "drop"
+	default:
+		return fmt.Sprintf("SuppressPolicy(%d)", int(p))
+	}
+}
+
+// suppression is a running suppression of a tag.
+type suppression struct {
+	until time.Time
+	timer *trackedTimer
+}
+
+// Suppress holds all states tagged with tag until the given time, e.g. during planned
+// maintenance, to silence downstream automations: their values do not change, pending delayed
+// transitions are cancelled, and SetState and its variants are handled by the policy of
+// WithSuppressPolicy. A request that would change a value is reported to WithOnStateEvent
+// callbacks and subscriptions as a StateEvent with Suppressed set; WithOnStateChange callbacks
+// do not receive it. ForceState, Reset and UpdateState apply immediately. Calling Suppress for
+// a tag already suppressed replaces the end of its suppression.
+func (sc *StateController) Suppress(tag string, until time.Time) {
+	sc.suppressMu.Lock()
+	if s, ok := sc.suppressions[tag]; ok {
+		s.timer.Stop()
+	} else {
+		atomic.AddInt32(&sc.suppressCount, 1)
+	}
+	s := &suppression{until: until}
+	s.timer = sc.afterFunc(until.Sub(sc.now()), func() { sc.endSuppression(tag, s) })
+	sc.suppressions[tag] = s
+	sc.suppressMu.Unlock()
+
+	for name, state := range sc.loadIndex() {
+		state.mu.Lock()
+		if !state.removed && state.pending() && hasTag(state.Tags, tag) {
+			sc.holdPending(name, state)
+		}
+		state.mu.Unlock()
+		sc.flush(state)
+	}
+
+	if sc.shadow != nil {
+		sc.shadow.Suppress(tag, until)
+	}
+}
+
+// Unsuppress ends the suppression of tag early; see Suppress.
+func (sc *StateController) Unsuppress(tag string) {
+	sc.endSuppression(tag, nil)
+	if sc.shadow != nil {
+		sc.shadow.Unsuppress(tag)
+	}
+}
+
+// endSuppression ends the suppression of tag, if it is s or s is nil, and applies the held
+// requests of the states no longer suppressed.
+func (sc *StateController) endSuppression(tag string, s *suppression) {
+	sc.suppressMu.Lock()
+	current, ok := sc.suppressions[tag]
+	if !ok || (s != nil && current != s) {
+		sc.suppressMu.Unlock()
+		return
+	}
+	current.timer.Stop()
+	delete(sc.suppressions, tag)
+	atomic.AddInt32(&sc.suppressCount, -1)
+	sc.suppressMu.Unlock()
+
+	sc.releaseHeld(func(state *delayedState) bool {
+		return hasTag(state.Tags, tag) && !sc.suppressed(state)
+	})
+}
+
+// holdPending cancels the pending transition of a state that became suppressed and, with
+// SuppressBuffer, holds the request that started it. Must be called with the state's mutex held.
+func (sc *StateController) holdPending(name string, state *delayedState) {
+	if sc.suppressPolicy == SuppressBuffer {
+		state.held = &heldRequest{ctx: state.pendingCtx, active: state.requested}
+	}
+	state.stopTimer()
+	state.requested = state.IsActive
+	sc.checkInvariants(name, state)
+}
+
+// suppressed reports whether one of the tags of state is suppressed.
+// Must be called with the state's mutex held.
+func (sc *StateController) suppressed(state *delayedState) bool {
+	if atomic.LoadInt32(&sc.suppressCount) == 0 || len(state.Tags) == 0 {
+		return false
+	}
+	now := sc.now()
+	sc.suppressMu.Lock()
+	defer sc.suppressMu.Unlock()
+	for _, tag := range state.Tags {
+		if s, ok := sc.suppressions[tag]; ok && now.Before(s.until) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordSuppressed reports a request held back by a suppression that would have changed the
+// value of state. Must be called with the state's mutex held.
+func (sc *StateController) recordSuppressed(ctx context.Context, name string, state *delayedState, active bool) {
+	if active == state.IsActive && !state.Unknown {
+		return
+	}
+	if sc.onStateChange == nil && atomic.LoadInt32(&sc.consumerCount) == 0 {
+		return
+	}
+	ev := StateEvent{
+		Controller:  sc.name,
+		Seq:         atomic.AddUint64(&sc.seq, 1),
+		Name:        name,
+		Old:         state.IsActive,
+		Active:      active,
+		WasUnknown:  state.Unknown,
+		Suppressed:  true,
+		Cause:       CauseExplicit,
+		RequestedBy: RequestedBy(ctx),
+		Quality:     qualityFrom(ctx),
+		Priority:    priorityFrom(ctx),
+		Time:        sc.now(),
+		Tags:        state.Tags,
+	}
+	if state.outbox == nil {
+		state.outbox = *outboxPool.Get().(*[]callbackEvent)
+	}
+	state.outbox = append(state.outbox, callbackEvent{ctx: ctx, event: ev})
+}
+
+// stopSuppressions stops the timers of all suppressions.
+func (sc *StateController) stopSuppressions() {
+	sc.suppressMu.Lock()
+	defer sc.suppressMu.Unlock()
+	for _, s := range sc.suppressions {
+		s.timer.Stop()
+	}
+}
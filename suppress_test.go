@@ -0,0 +1,86 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSuppress(t *testing.T) {
+	var (
+		changes []string
+		events  []StateEvent
+	)
+	sc := NewStateController(WithInvariantChecks(true))
+	sc.onStateChange = func(ctx context.Context, ev StateEvent) {
+		events = append(events, ev)
+		if !ev.Suppressed {
+			changes = append(changes, ev.Name)
+		}
+	}
+	defer sc.Close()
+	sc.AddState("hall", State{Tags: []string{"zone1"}})
+	sc.AddState("porch", State{Tags: []string{"zone1"}, Delay: time.Hour, IsActive: true})
+	sc.AddState("garden", State{Tags: []string{"zone2"}})
+
+	sc.SetState("porch", false) // Pending; cancelled by the suppression.
+	sc.Suppress("zone1", time.Now().Add(time.Hour))
+
+	if pending, _ := sc.IsPending("porch"); pending {
+		t.Fatal("Expected the pending transition to be cancelled")
+	}
+	sc.SetState("hall", true)
+	sc.SetState("garden", true)
+	if sc.IsActive("hall") || !sc.IsActive("porch") || !sc.IsActive("garden") {
+		t.Fatal("Expected only states tagged zone1 to be held")
+	}
+	if len(events) != 2 || !events[0].Suppressed || events[0].Name != "hall" || events[1].Suppressed {
+		t.Fatalf("Expected a suppressed event for hall and a change of garden, got %+v", events)
+	}
+
+	sc.Unsuppress("zone1")
+	if !sc.IsActive("hall") {
+		t.Fatal("Expected the buffered request of hall to be applied")
+	}
+	if pending, towards := sc.IsPending("porch"); !pending || towards {
+		t.Fatal("Expected the held deactivation of porch to restart its delay")
+	}
+	if len(changes) != 2 || changes[1] != "hall" {
+		t.Fatalf("Expected changes of garden and hall, got %v", changes)
+	}
+}
+
+func TestSuppressDropExpires(t *testing.T) {
+	var changes []string
+	sc := NewStateController(
+		WithSuppressPolicy(SuppressDrop),
+		WithOnStateChange(func(name string, active bool) { changes = append(changes, name) }),
+	)
+	defer sc.Close()
+	sc.AddState("hall", State{Tags: []string{"zone1"}})
+
+	sc.Suppress("zone1", time.Now().Add(10*time.Millisecond))
+	sc.SetState("hall", true)
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&sc.suppressCount) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the suppression to expire")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sc.IsActive("hall") || len(changes) != 0 {
+		t.Fatal("Expected the request during the suppression to be dropped")
+	}
+	sc.SetState("hall", true)
+	if !sc.IsActive("hall") {
+		t.Fatal("Expected requests to apply after the suppression")
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"time"
+)
+
+// runTicker fires due transitions every interval until stop is closed; see WithTickInterval.
+func (sc *StateController) runTicker(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			sc.tick(now)
+		}
+	}
+}
+
+// tick fires the scheduled transitions due at now and forgets states no longer pending.
+func (sc *StateController) tick(now time.Time) {
+	sc.tickMu.Lock()
+	scheduled := make(map[*delayedState]string, len(sc.ticked))
+	for state, name := range sc.ticked {
+		scheduled[state] = name
+	}
+	sc.tickMu.Unlock()
+
+	for state, name := range scheduled {
+		if sc.fireIfDue(name, state, now) {
+			continue
+		}
+		// Only forget the state under its mutex, so a concurrent startTimer is not lost.
+		state.mu.Lock()
+		if !state.pending() {
+			sc.tickMu.Lock()
+			delete(sc.ticked, state)
+			sc.tickMu.Unlock()
+		}
+		state.mu.Unlock()
+	}
+}
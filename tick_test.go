@@ -0,0 +1,59 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTickInterval(t *testing.T) {
+	sc := NewStateController(WithTickInterval(10 * time.Millisecond))
+	defer sc.Close()
+	sc.AddState("state1", State{Delay: 20 * time.Millisecond})
+	sc.AddState("state2", State{Delay: 20 * time.Millisecond, DelayOnActivation: true})
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false)
+	sc.SetState("state2", true)
+
+	state := sc.lockState("state1")
+	if state.delayedTimer != nil {
+		t.Fatal("Expected no per-state timer with WithTickInterval")
+	}
+	state.mu.Unlock()
+
+	if !sc.IsActive("state1") || sc.IsActive("state2") {
+		t.Fatal("Expected transitions to be pending")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if sc.IsActive("state1") || !sc.IsActive("state2") {
+		t.Fatal("Expected transitions to fire on a tick")
+	}
+
+	sc.tickMu.Lock()
+	defer sc.tickMu.Unlock()
+	if len(sc.ticked) != 0 {
+		t.Fatalf("Expected fired states to be forgotten, got %d", len(sc.ticked))
+	}
+}
+
+func TestTickIntervalCancelled(t *testing.T) {
+	sc := NewStateController(WithTickInterval(5 * time.Millisecond))
+	defer sc.Close()
+	sc.AddState("state1", State{Delay: 10 * time.Millisecond})
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false)
+	sc.SetState("state1", true) // Cancels the pending deactivation.
+
+	time.Sleep(40 * time.Millisecond)
+	if !sc.IsActive("state1") {
+		t.Fatal("Expected cancelled transition not to fire")
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+)
+
+// errNotAccepted records a TrySetState request with an idempotency key that was not applied, so
+// that its key is not remembered.
+var errNotAccepted = errors.New("request not accepted")
+
+// TrySetState is like SetState, but never waits, for real-time loops that must not stall on the
+// controller: if the state is locked by another call or, with WithAsyncCallbacks and
+// OverflowBlock, its callback queue is full, the request is not applied and accepted is false.
+// TrySetState does not create missing states, as the StateFactory could block. Callbacks of
+// synchronous mode still run on the calling goroutine. With WithIdempotencyKey, a duplicate of a
+// request that succeeded is accepted without being applied, and one made while a request with
+// its key is in flight is not accepted; the key is only remembered if the request is accepted.
+func (sc *StateController) TrySetState(name string, active bool, opts ...SetOption) (accepted bool, err error) {
+	if sc.Draining() {
+		return false, sc.stateError(name, ErrDraining)
+	}
+	if err := sc.Authorize(context.Background(), OpSet, name); err != nil {
+		return false, err
+	}
+	if sc.shadow != nil {
+		return sc.shadow.TrySetState(name, active, opts...)
+	}
+
+	o := newSetOptions(opts...)
+	if o.idempotencyKey == "" {
+		return sc.trySetState(name, active, o)
+	}
+	first, inFlight := sc.idempotency.tryClaim(o.idempotencyKey)
+	if !first {
+		return !inFlight, nil
+	}
+	accepted, err = sc.trySetState(name, active, o)
+	outcome := err
+	if !accepted && err == nil {
+		outcome = errNotAccepted
+	}
+	sc.idempotency.complete(o.idempotencyKey, sc.idempotencyWindow, outcome)
+	return accepted, err
+}
+
+// trySetState applies a TrySetState request with the options o.
+func (sc *StateController) trySetState(name string, active bool, o setOptions) (accepted bool, err error) {
+	state := sc.loadIndex()[name]
+	if state == nil {
+		return false, sc.stateError(name, ErrStateNotFound)
+	}
+	if sc.callbacksSaturated(name) || !state.mu.TryLock() {
+		return false, nil
+	}
+	if state.removed {
+		state.mu.Unlock()
+		return false, sc.stateError(name, ErrStateNotFound)
+	}
+
+	ctx := o.context(context.Background())
+	err = sc.request(ctx, name, state, active)
+	state.mu.Unlock()
+	sc.flush(state)
+
+	if err != nil {
+		return false, sc.stateError(name, err)
+	}
+	return true, nil
+}
+
+// callbacksSaturated reports whether delivering a callback for the named state could block,
+// because its dispatcher's queue is full and the overflow policy is OverflowBlock.
+func (sc *StateController) callbacksSaturated(name string) bool {
+	if len(sc.dispatchers) == 0 || sc.asyncOverflow != OverflowBlock {
+		return false
+	}
+	d := sc.dispatchers[sc.workerFor(name)]
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return !d.closed && len(d.queue) >= d.size
+}
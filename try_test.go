@@ -0,0 +1,134 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTrySetState(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{})
+
+	accepted, err := sc.TrySetState("state1", true)
+	if !accepted || err != nil {
+		t.Fatalf("Expected the request to be accepted, got %v, %v", accepted, err)
+	}
+	if !sc.IsActive("state1") {
+		t.Fatal("Expected state1 to be active")
+	}
+
+	// A state locked by another call is not waited for.
+	state := sc.states["state1"]
+	state.mu.Lock()
+	accepted, err = sc.TrySetState("state1", false)
+	state.mu.Unlock()
+	if accepted || err != nil {
+		t.Fatalf("Expected the request to be rejected without error, got %v, %v", accepted, err)
+	}
+	if !sc.IsActive("state1") {
+		t.Fatal("Expected state1 to stay active")
+	}
+
+	if _, err := sc.TrySetState("missing", true); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound, got %v", err)
+	}
+}
+
+func TestTrySetStateFullCallbackQueue(t *testing.T) {
+	release := make(chan struct{})
+	sc := NewStateController(
+		WithAsyncCallbacks(1, OverflowBlock),
+		WithOnStateChange(func(name string, active bool) { <-release }),
+	)
+	defer sc.Close()
+	defer close(release)
+	sc.AddState("state1", State{})
+	sc.AddState("state2", State{})
+
+	// The first callback blocks the worker, the second fills the queue.
+	sc.SetState("state1", true)
+	d := sc.dispatchers[0]
+	for {
+		d.mu.Lock()
+		taken := len(d.queue) == 0
+		d.mu.Unlock()
+		if taken {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	sc.SetState("state2", true)
+
+	accepted, err := sc.TrySetState("state1", false)
+	if accepted || err != nil {
+		t.Fatalf("Expected the request to be rejected without error, got %v, %v", accepted, err)
+	}
+}
+
+func TestTrySetStateIdempotencyKey(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{})
+
+	if accepted, err := sc.TrySetState("state1", true, WithIdempotencyKey("req-1")); !accepted || err != nil {
+		t.Fatalf("Expected the request to be accepted, got %v, %v", accepted, err)
+	}
+	sc.ForceState("state1", false)
+	if accepted, err := sc.TrySetState("state1", true, WithIdempotencyKey("req-1")); !accepted || err != nil {
+		t.Fatalf("Expected the duplicate to be accepted, got %v, %v", accepted, err)
+	}
+	if sc.IsActive("state1") {
+		t.Fatal("Expected the duplicate not to be applied")
+	}
+
+	// A request that is not accepted does not remember its key.
+	state := sc.states["state1"]
+	state.mu.Lock()
+	accepted, _ := sc.TrySetState("state1", true, WithIdempotencyKey("req-2"))
+	state.mu.Unlock()
+	if accepted {
+		t.Fatal("Expected the request to be rejected")
+	}
+	if accepted, _ := sc.TrySetState("state1", true, WithIdempotencyKey("req-2")); !accepted || !sc.IsActive("state1") {
+		t.Fatal("Expected the retry to be applied")
+	}
+
+	if _, err := sc.TrySetState("missing", true, WithIdempotencyKey("req-3")); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound, got %v", err)
+	}
+	sc.AddState("missing", State{})
+	if accepted, _ := sc.TrySetState("missing", true, WithIdempotencyKey("req-3")); !accepted || !sc.IsActive("missing") {
+		t.Fatal("Expected a failed request not to remember its key")
+	}
+}
+
+func TestTrySetStateIdempotencyKeyInFlight(t *testing.T) {
+	release := make(chan struct{})
+	sc := NewStateController(WithOnStateNotExistContext(func(ctx context.Context, name string) (State, error) {
+		<-release
+		return State{}, nil
+	}))
+
+	first := make(chan error, 1)
+	go func() { first <- sc.SetState("state1", true, WithIdempotencyKey("req-1")) }()
+	time.Sleep(10 * time.Millisecond)
+
+	// The duplicate does not wait for the request in flight.
+	if accepted, err := sc.TrySetState("state1", false, WithIdempotencyKey("req-1")); accepted || err != nil {
+		t.Fatalf("Expected the duplicate to be rejected without error, got %v, %v", accepted, err)
+	}
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+	if !sc.IsActive("state1") {
+		t.Fatal("Expected the duplicate not to be applied")
+	}
+}
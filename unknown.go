@@ -0,0 +1,124 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// SetUnknown marks the value of the named state as unknown, e.g. after a restart without
+// persisted values. Any pending transition is cancelled and IsActive keeps the last known value.
+// Subscribers and WithOnStateEvent callbacks receive an event with Unknown set; the callbacks of
+// WithOnStateChange and WithOnStateChangeContext are not fired, as the value did not change.
+func (sc *StateController) SetUnknown(name string) error {
+	if sc.Draining() {
+		return sc.stateError(name, ErrDraining)
+	}
+	if err := sc.Authorize(context.Background(), OpForce, name); err != nil {
+		return err
+	}
+	if sc.shadow != nil {
+		return sc.shadow.SetUnknown(name)
+	}
+	state := sc.lockState(name)
+	if state == nil {
+		return sc.stateError(name, ErrStateNotFound)
+	}
+	if state.disabled {
+		state.mu.Unlock()
+		return sc.stateError(name, ErrStateDisabled)
+	}
+
+	state.stopStaleTimer()
+	state.version++
+	sc.markUnknown(context.Background(), name, state, CauseExplicit)
+	sc.checkInvariants(name, state)
+	state.mu.Unlock()
+	sc.flush(state)
+
+	return nil
+}
+
+// markUnknown makes the value of state unknown. Must be called with the state's mutex held.
+func (sc *StateController) markUnknown(ctx context.Context, name string, state *delayedState, cause Cause) {
+	state.stopTimer()
+	if state.Unknown {
+		return
+	}
+	state.Unknown = true
+	sc.record(state, ctx, StateEvent{Name: name, Old: state.IsActive, Active: state.IsActive, Unknown: true, Cause: cause})
+}
+
+// applyFromUnknown moves an unknown state towards active. Leaving the unknown value in the
+// state's delayed direction waits out the delay like any other transition in that direction,
+// during which the value stays unknown; the other direction applies at once.
+// Must be called with the state's mutex held.
+func (sc *StateController) applyFromUnknown(ctx context.Context, name string, state *delayedState, active bool) error {
+	if state.pending() {
+		if state.pendingTarget == active {
+			sc.reassert(ctx, name, state)
+			return nil
+		}
+		state.stopTimer()
+	}
+
+	delayed := active == state.DelayOnActivation && state.Delay > 0 &&
+		!state.delaysDisabled && atomic.LoadInt32(&sc.bypassAll) == 0
+	if !delayed {
+		sc.setValue(ctx, name, state, active, CauseExplicit)
+		return nil
+	}
+	if !sc.reservePending() {
+		return ErrTooManyPending
+	}
+	sc.startTimer(ctx, name, state, active, state.Delay)
+	return nil
+}
+
+// setValue sets the value of state to active at once and records the change, if the value
+// changed or was unknown. Must be called with the state's mutex held.
+func (sc *StateController) setValue(ctx context.Context, name string, state *delayedState, active bool, cause Cause) {
+	if !state.Unknown && state.IsActive == active {
+		return
+	}
+	ev := StateEvent{Name: name, Old: state.IsActive, Active: active, WasUnknown: state.Unknown, Cause: cause}
+	state.Unknown = false
+	state.IsActive = active
+	sc.record(state, ctx, ev)
+}
+
+// startStaleTimer (re)starts the watchdog that marks state unknown if it is not set again
+// within StaleAfter. Must be called with the state's mutex held.
+func (sc *StateController) startStaleTimer(name string, state *delayedState) {
+	state.stopStaleTimer()
+	if state.StaleAfter <= 0 {
+		return
+	}
+	state.staleGen++
+	gen := state.staleGen
+	state.staleTimer = sc.afterFunc(state.StaleAfter, func() {
+		state.mu.Lock()
+		if state.removed || state.disabled || state.staleTimer == nil || state.staleGen != gen {
+			state.mu.Unlock()
+			return
+		}
+		state.staleTimer = nil
+		sc.markUnknown(context.Background(), name, state, CauseStale)
+		sc.checkInvariants(name, state)
+		state.mu.Unlock()
+		sc.flush(state)
+	})
+}
+
+// stopStaleTimer stops the staleness watchdog, if any. Must be called with the state's mutex held.
+func (s *delayedState) stopStaleTimer() {
+	if s.staleTimer != nil {
+		s.staleTimer.Stop()
+		s.staleTimer = nil
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSetUnknown(t *testing.T) {
+	var changes []bool
+	events := make(chan StateEvent, 8)
+	sc := NewStateController(WithOnStateChange(func(name string, active bool) { changes = append(changes, active) }))
+	sub := sc.Subscribe(8)
+	defer sub.Close()
+	sc.AddState("state1", State{Delay: time.Second})
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false) // Pending deactivation, cancelled below.
+	if err := sc.SetUnknown("state1"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !sc.IsUnknown("state1") || !sc.IsActive("state1") {
+		t.Fatal("Expected unknown value, keeping the last known one")
+	}
+	if pending, _ := sc.IsPending("state1"); pending {
+		t.Fatal("Expected pending transition to be cancelled")
+	}
+
+	// Leaving unknown in the non-delayed direction applies at once, even without a value change.
+	sc.SetState("state1", true)
+	if sc.IsUnknown("state1") {
+		t.Fatal("Expected value to be known again")
+	}
+
+	for i := 0; i < 3; i++ {
+		events <- receive(t, sub)
+	}
+	close(events)
+	var got []StateEvent
+	for ev := range events {
+		got = append(got, ev)
+	}
+	if !got[1].Unknown || got[1].Active != true {
+		t.Fatalf("Expected unknown event keeping the last value, got %+v", got[1])
+	}
+	if !got[2].WasUnknown || !got[2].Old || !got[2].Active {
+		t.Fatalf("Expected event leaving unknown, got %+v", got[2])
+	}
+	if len(changes) != 2 {
+		t.Fatalf("Expected legacy callback to skip the unknown event, got %v", changes)
+	}
+
+	if err := sc.SetUnknown("missing"); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound, got %v", err)
+	}
+}
+
+func TestLeaveUnknownWithDelay(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{Unknown: true, Delay: 20 * time.Millisecond, DelayOnActivation: true})
+
+	sc.SetState("state1", true)
+	if !sc.IsUnknown("state1") {
+		t.Fatal("Expected value to stay unknown during the activation delay")
+	}
+	if err := sc.AwaitActive("state1", time.Second); err != nil {
+		t.Fatalf("Expected delayed activation, got %v", err)
+	}
+	if sc.IsUnknown("state1") {
+		t.Fatal("Expected value to be known after the delay")
+	}
+}
+
+func TestStaleAfter(t *testing.T) {
+	events := make(chan StateEvent, 8)
+	sc := NewStateController(WithOnStateEvent(func(ctx context.Context, ev StateEvent) { events <- ev }))
+	sc.AddState("sensor", State{StaleAfter: 60 * time.Millisecond})
+
+	sc.SetState("sensor", true)
+	<-events
+	time.Sleep(30 * time.Millisecond)
+	sc.SetState("sensor", true) // Refreshes the watchdog.
+	time.Sleep(40 * time.Millisecond)
+	if sc.IsUnknown("sensor") {
+		t.Fatal("Expected refreshed state not to be stale yet")
+	}
+
+	select {
+	case ev := <-events:
+		if !ev.Unknown || ev.Cause != CauseStale {
+			t.Fatalf("Expected stale event, got %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected state to become stale")
+	}
+	if !sc.IsUnknown("sensor") {
+		t.Fatal("Expected stale state to be unknown")
+	}
+}
+
+func TestStaleAfterRemoveWhere(t *testing.T) {
+	sc := NewStateController()
+	defer sc.Close()
+	sc.AddState("sensor", State{StaleAfter: time.Hour})
+	sc.SetState("sensor", true)
+	state := sc.loadIndex()["sensor"]
+
+	sc.Clear()
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.staleTimer != nil {
+		t.Fatal("Expected the watchdog of a cleared state to be stopped")
+	}
+}
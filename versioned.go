@@ -0,0 +1,69 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrVersionConflict is returned by SetStateVersioned if the state was written since the
+// expected version was read.
+var ErrVersionConflict = errors.New("version conflict")
+
+// SetStateVersioned is like SetState, but only applies the request if the state's version still
+// equals expectedVersion, as read from StateInfo.Version, so that external controllers doing
+// read-modify-write cycles don't overwrite each other's requests. Each accepted SetState, Reset,
+// ForceState, UpdateState and SetUnknown increments the version; delayed transitions firing
+// do not. Returns ErrVersionConflict on a mismatch. The state is not created if it does not exist.
+// With WithIdempotencyKey, a duplicate of a request that succeeded returns nil without being
+// applied, even though the version changed since.
+func (sc *StateController) SetStateVersioned(name string, active bool, expectedVersion uint64, opts ...SetOption) error {
+	if sc.Draining() {
+		return sc.stateError(name, ErrDraining)
+	}
+	if err := sc.Authorize(context.Background(), OpSet, name); err != nil {
+		return err
+	}
+	if sc.shadow != nil {
+		return sc.shadow.SetStateVersioned(name, active, expectedVersion, opts...)
+	}
+
+	o := newSetOptions(opts...)
+	if o.idempotencyKey == "" {
+		return sc.setStateVersioned(name, active, expectedVersion, o)
+	}
+	first, err := sc.idempotency.claim(context.Background(), o.idempotencyKey)
+	if !first {
+		return err
+	}
+	err = sc.setStateVersioned(name, active, expectedVersion, o)
+	sc.idempotency.complete(o.idempotencyKey, sc.idempotencyWindow, err)
+	return err
+}
+
+// setStateVersioned applies a SetStateVersioned request with the options o.
+func (sc *StateController) setStateVersioned(name string, active bool, expectedVersion uint64, o setOptions) error {
+	state := sc.lockState(name)
+	if state == nil {
+		return sc.stateError(name, ErrStateNotFound)
+	}
+	if state.version != expectedVersion {
+		version := state.version
+		state.mu.Unlock()
+		return sc.stateError(name, fmt.Errorf("%w: expected version %d, got %d", ErrVersionConflict, expectedVersion, version))
+	}
+	err := sc.request(o.context(context.Background()), name, state, active)
+	state.mu.Unlock()
+	sc.flush(state)
+
+	if err != nil {
+		return sc.stateError(name, err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSetStateVersioned(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{})
+
+	info, _ := sc.Info("state1")
+	if info.Version != 0 {
+		t.Fatalf("Expected version 0, got %d", info.Version)
+	}
+	if err := sc.SetStateVersioned("state1", true, info.Version); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// A second writer with the stale version loses.
+	if err := sc.SetStateVersioned("state1", false, info.Version); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("Expected ErrVersionConflict, got %v", err)
+	}
+	if !sc.IsActive("state1") {
+		t.Fatal("Expected the conflicting request not to be applied")
+	}
+
+	sc.Reset("state1")
+	sc.ForceState("state1", true)
+	if info, _ = sc.Info("state1"); info.Version != 3 {
+		t.Fatalf("Expected version 3, got %d", info.Version)
+	}
+
+	if err := sc.SetStateVersioned("missing", true, 0); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("Expected ErrStateNotFound, got %v", err)
+	}
+}
+
+func TestSetStateVersionedIdempotencyKey(t *testing.T) {
+	sc := NewStateController()
+	sc.AddState("state1", State{})
+
+	if err := sc.SetStateVersioned("state1", true, 0, WithIdempotencyKey("req-1")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// The retry of the same request is skipped instead of failing on the new version.
+	if err := sc.SetStateVersioned("state1", true, 0, WithIdempotencyKey("req-1")); err != nil {
+		t.Fatalf("Expected the duplicate to be skipped, got %v", err)
+	}
+	if info, _ := sc.Info("state1"); info.Version != 1 {
+		t.Fatalf("Expected version 1, got %d", info.Version)
+	}
+
+	// Failed requests are not remembered.
+	if err := sc.SetStateVersioned("state1", false, 0, WithIdempotencyKey("req-2")); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("Expected ErrVersionConflict, got %v", err)
+	}
+	if err := sc.SetStateVersioned("state1", false, 1, WithIdempotencyKey("req-2")); err != nil {
+		t.Fatalf("Expected the retry to be applied, got %v", err)
+	}
+	if info, _ := sc.Info("state1"); info.Version != 2 || info.Requested {
+		t.Fatalf("Expected version 2 requesting inactive, got %+v", info)
+	}
+}
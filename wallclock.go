@@ -0,0 +1,172 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"fmt"
+	"time"
+)
+
+// Clock decides how the delays of a state are measured, which matters when the wall clock is
+// changed, e.g. by an NTP step, a manual correction or a suspend of the host.
+type Clock int
+
+const (
+	// ClockDefault measures delays on the monotonic clock, like ClockMonotonic. With
+	// WithWallClockDeadlines, a transition also fires once its deadline passed by the wall clock,
+	// so a suspend or a wall clock set forward fires it early, while a wall clock set back does
+	// not postpone it.
+	ClockDefault Clock = iota
+	// ClockMonotonic measures delays as durations: a transition fires once its delay elapsed on
+	// the monotonic clock, whatever the wall clock does, e.g. for "on for 5 minutes". Time the
+	// host is suspended may not count, depending on the platform; WithWallClockDeadlines does not
+	// change this.
+	ClockMonotonic
+	// ClockWall measures delays as deadlines on the wall clock, e.g. for "until 17:00": a
+	// transition fires once the wall clock reaches its deadline. If the wall clock is set back,
+	// the transition is postponed accordingly. If it is set forward or the host was suspended,
+	// the transition fires at the next check of WithWallClockDeadlines, or otherwise once its
+	// delay elapsed on the monotonic clock at the latest.
+	ClockWall
+)
+
+// String returns the name of the clock.
+func (c Clock) String() string {
+	switch c {
+	case ClockDefault:
+		return "default"
+	case ClockMonotonic:
+		return "monotonic"
+	case ClockWall:
+		return "wall"
+	default:
+		return fmt.Sprintf("Clock(%d)", int(c))
+	}
+}
+
+// due reports whether the pending transition of state is due at now, according to its Clock.
+// Must be called with the state's mutex held.
+func (sc *StateController) due(state *delayedState, now time.Time) bool {
+	monotonic := !now.Before(state.deadline)
+	// Round(0) strips the monotonic reading, so the comparison uses the wall clock.
+	wall := !now.Round(0).Before(state.wallDeadline)
+	switch state.Clock {
+	case ClockMonotonic:
+		return monotonic
+	case ClockWall:
+		return wall
+	default:
+		return monotonic || (sc.wallCheckInterval > 0 && wall)
+	}
+}
+
+// untilDue returns how long after now the pending transition of state is due, by the clock that
+// decides it. Must be called with the state's mutex held.
+func (sc *StateController) untilDue(state *delayedState, now time.Time) time.Duration {
+	if state.Clock == ClockWall {
+		return state.wallDeadline.Sub(now.Round(0))
+	}
+	return state.deadline.Sub(now)
+}
+
+// ClockJump reports a change of the wall clock detected with WithClockJumpDetection.
+type ClockJump struct {
+	Controller string        // Name of the controller set with WithName, if any.
+	Offset     time.Duration // How far the wall clock moved; negative if it was set back.
+	Time       time.Time     // Wall-clock time the jump was detected at.
+}
+
+// watchWallClock checks the wall clock every interval until stop is closed: it reports jumps for
+// WithClockJumpDetection and, with WithWallClockDeadlines, fires pending transitions whose
+// deadline has passed by the wall clock. Timers measure delays on the monotonic clock, which on
+// most platforms does not advance while the host is suspended, so without this check a
+// transition due during a suspend would wait out its full remaining delay after the host resumes.
+func (sc *StateController) watchWallClock(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	base := time.Now()
+	prevWall, prevElapsed := base.Round(0), time.Duration(0)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		if sc.jumpThreshold > 0 {
+			wall, elapsed := now.Round(0), now.Sub(base)
+			sc.checkClockJump(wall.Sub(prevWall)-(elapsed-prevElapsed), wall)
+			prevWall, prevElapsed = wall, elapsed
+		}
+		if sc.wallCheckInterval > 0 {
+			sc.ReevaluateDeadlines()
+		}
+	}
+}
+
+// wallWatchInterval returns the interval of watchWallClock, or zero if it is not needed.
+func (sc *StateController) wallWatchInterval() time.Duration {
+	if sc.wallCheckInterval > 0 || sc.jumpThreshold <= 0 {
+		return sc.wallCheckInterval
+	}
+	if sc.jumpThreshold < time.Second {
+		return sc.jumpThreshold
+	}
+	return time.Second
+}
+
+// checkClockJump reports a jump if the wall clock moved by offset more than the monotonic clock
+// since the previous check.
+func (sc *StateController) checkClockJump(offset time.Duration, wall time.Time) {
+	if offset > -sc.jumpThreshold && offset < sc.jumpThreshold {
+		return
+	}
+	if sc.onClockJump != nil {
+		sc.onClockJump(ClockJump{Controller: sc.name, Offset: offset, Time: wall})
+	}
+}
+
+// ReevaluateDeadlines fires every pending transition that is due now by its Clock, e.g. after the
+// wall clock was set forward. Transitions of ClockWall states postponed by a wall clock set back
+// are rescheduled automatically and need no reevaluation.
+func (sc *StateController) ReevaluateDeadlines() {
+	now := sc.now()
+	for name, state := range sc.loadIndex() {
+		sc.fireIfDue(name, state, now)
+	}
+}
+
+// fireIfDue fires the pending transition of state if it is due at now; see due.
+// Reports whether the state is still pending afterwards.
+func (sc *StateController) fireIfDue(name string, state *delayedState, now time.Time) bool {
+	state.mu.Lock()
+	if state.removed || !state.pending() {
+		state.mu.Unlock()
+		return false
+	}
+	if !sc.due(state, now) {
+		state.mu.Unlock()
+		return true
+	}
+	sc.fire(name, state)
+	pending := state.pending()
+	sc.checkInvariants(name, state)
+	state.mu.Unlock()
+	sc.flush(state)
+	return pending
+}
+
+// stopBackground stops the goroutines started for WithTickInterval and WithWallClockDeadlines.
+func (sc *StateController) stopBackground() {
+	if sc.stop != nil {
+		sc.stopOnce.Do(func() {
+			close(sc.stop)
+		})
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2024 Emanuel Sonnek
+// Licensed under the MIT License. See LICENSE file for details.
+//
+// Email: sonnek.emanuel@gmail.com
+// Created: 2024-11-24
+
+package delayedstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWallClockDeadlines(t *testing.T) {
+	sc := NewStateController(WithWallClockDeadlines(5 * time.Millisecond))
+	defer sc.Close()
+	sc.AddState("state1", State{Delay: time.Hour})
+
+	sc.SetState("state1", true)
+	sc.SetState("state1", false)
+
+	// Simulate a suspend: the wall-clock deadline has passed, the monotonic timer has not fired.
+	state := sc.lockState("state1")
+	state.deadline = time.Now().Round(0).Add(-time.Second)
+	state.mu.Unlock()
+
+	deadline := time.Now().Add(time.Second)
+	for sc.IsActive("state1") {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected overdue transition to fire")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWallClockDeadlinesStoppedByClose(t *testing.T) {
+	sc := NewStateController(WithWallClockDeadlines(time.Millisecond))
+	sc.Close()
+	sc.Close() // idempotent
+
+	select {
+	case <-sc.stop:
+	default:
+		t.Fatal("Expected wall-clock check to be stopped")
+	}
+}
+
+// setWallDeadline simulates a wall clock change by moving the wall-clock deadline of the pending
+// transition of name to wall from now, keeping the monotonic deadline.
+func setWallDeadline(sc *StateController, name string, wall time.Duration) {
+	state := sc.lockState(name)
+	state.wallDeadline = time.Now().Round(0).Add(wall)
+	state.mu.Unlock()
+}
+
+func TestClockWallSetBack(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithTimerCoalescing(time.Millisecond)}, {WithTickInterval(time.Millisecond)}} {
+		sc := NewStateController(append(opts, WithInvariantChecks(true))...)
+		sc.AddState("until", State{Delay: 10 * time.Millisecond, Clock: ClockWall})
+		sc.AddState("for", State{Delay: 10 * time.Millisecond})
+
+		start := time.Now()
+		for _, name := range []string{"until", "for"} {
+			sc.SetState(name, true)
+			sc.SetState(name, false)
+			// The wall clock was set back by 90ms right after scheduling.
+			setWallDeadline(sc, name, 100*time.Millisecond)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := sc.Await(ctx, "for", false); err != nil {
+			t.Fatal(err)
+		}
+		if !sc.IsActive("until") {
+			t.Fatal("Expected the wall-clock transition to be postponed")
+		}
+		if err := sc.Await(ctx, "until", false); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Fatalf("Expected the wall-clock transition after its wall deadline, fired after %s", elapsed)
+		}
+		cancel()
+		sc.Close()
+	}
+}
+
+func TestClockWallSetForward(t *testing.T) {
+	sc := NewStateController(WithWallClockDeadlines(5 * time.Millisecond))
+	defer sc.Close()
+	sc.AddState("until", State{Delay: time.Hour, Clock: ClockWall})
+
+	sc.SetState("until", true)
+	sc.SetState("until", false)
+	setWallDeadline(sc, "until", -time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sc.Await(ctx, "until", false); err != nil {
+		t.Fatal("Expected the transition to fire once the wall clock passed its deadline")
+	}
+}
+
+func TestClockMonotonicIgnoresWallClock(t *testing.T) {
+	sc := NewStateController(WithWallClockDeadlines(time.Millisecond))
+	defer sc.Close()
+	sc.AddState("for", State{Delay: time.Hour, Clock: ClockMonotonic})
+	sc.AddState("default", State{Delay: time.Hour})
+
+	for _, name := range []string{"for", "default"} {
+		sc.SetState(name, true)
+		sc.SetState(name, false)
+		setWallDeadline(sc, name, -time.Second)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sc.Await(ctx, "default", false); err != nil {
+		t.Fatal("Expected the default clock to follow the wall clock with WithWallClockDeadlines")
+	}
+	if !sc.IsActive("for") {
+		t.Fatal("Expected the monotonic transition to ignore the wall clock")
+	}
+}
+
+func TestClockString(t *testing.T) {
+	if ClockWall.String() != "wall" || Clock(9).String() != "Clock(9)" {
+		t.Fatal("Unexpected clock names")
+	}
+	sc := NewStateController()
+	if err := sc.AddState("state1", State{Clock: Clock(9)}); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("Expected ErrInvalidState for an unknown clock, got %v", err)
+	}
+}
+
+func TestClockJumpDetection(t *testing.T) {
+	var jumps []ClockJump
+	sc := NewStateController(WithName("edge"), WithClockJumpDetection(time.Minute, func(j ClockJump) {
+		jumps = append(jumps, j)
+	}))
+	defer sc.Close()
+
+	if interval := sc.wallWatchInterval(); interval != time.Second {
+		t.Fatalf("Expected the clock to be checked every second, got %s", interval)
+	}
+
+	now := time.Now()
+	sc.checkClockJump(30*time.Second, now)
+	sc.checkClockJump(-3*time.Hour, now)
+	sc.checkClockJump(2*time.Hour, now)
+	if len(jumps) != 2 || jumps[0].Offset != -3*time.Hour || jumps[1].Offset != 2*time.Hour || jumps[0].Controller != "edge" {
+		t.Fatalf("Expected jumps back by 3h and forward by 2h, got %+v", jumps)
+	}
+}
+
+func TestReevaluateDeadlines(t *testing.T) {
+	sc := NewStateController()
+	defer sc.Close()
+	sc.AddState("until", State{Delay: time.Hour, Clock: ClockWall})
+	sc.AddState("for", State{Delay: time.Hour})
+
+	for _, name := range []string{"until", "for"} {
+		sc.SetState(name, true)
+		sc.SetState(name, false)
+		// The wall clock was set forward by two hours.
+		setWallDeadline(sc, name, -time.Hour)
+	}
+
+	sc.ReevaluateDeadlines()
+	if sc.IsActive("until") || !sc.IsActive("for") {
+		t.Fatal("Expected only the wall-clock transition to fire")
+	}
+}